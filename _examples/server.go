@@ -26,12 +26,9 @@ func onRegister(request *coap.Message) (*coap.Message, error) {
 		OnDELETE: OnDelete,
 	})
 
-	return coap.NewAcknowledgementMessageBuilder().
-		Code(coap.Created).
-		MessageId(request.MessageID).
-		Token(request.Token).
+	return request.Respond(coap.Created).
 		Option(coap.LocationPath, coap.NewLocationPathOption("/rd/cafe/babe")...).
-		Build(), nil
+		Build()
 
 }
 
@@ -39,12 +36,9 @@ func OnUpdate(request *coap.Message) (*coap.Message, error) {
 
 	logger.Debugf("onUpdate(%v)", request)
 
-	return coap.NewAcknowledgementMessageBuilder().
-		Code(coap.Changed).
-		MessageId(request.MessageID).
-		Token(request.Token).
+	return request.Respond(coap.Changed).
 		Option(coap.LocationPath, coap.NewLocationPathOption("/rd/cafe/babe")...).
-		Build(), nil
+		Build()
 }
 
 func OnDelete(request *coap.Message) (*coap.Message, error) {
@@ -53,12 +47,9 @@ func OnDelete(request *coap.Message) (*coap.Message, error) {
 
 	server.RemoveResourceByPath("/rd/cafe/babe")
 
-	return coap.NewAcknowledgementMessageBuilder().
-		Code(coap.Deleted).
-		MessageId(request.MessageID).
-		Token(request.Token).
+	return request.Respond(coap.Deleted).
 		Option(coap.LocationPath, coap.NewLocationPathOption("/rd/cafe/babe")...).
-		Build(), nil
+		Build()
 }
 
 func main() {
@@ -0,0 +1,121 @@
+package coap
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+const (
+	// MaxBodySize is the size up to which the server reassembles request bodies sent in blocks.
+	MaxBodySize = 1 << 16
+
+	// BodyLifetime is the time, for which the server keeps a partial request body after
+	// receiving its last block. Spec: EXCHANGE_LIFETIME of the default transmission parameters.
+	BodyLifetime = 247 * time.Second
+)
+
+// Value of the Block1 and Block2 options (RFC 7959, section 2.2).
+type blockOption struct {
+	num  uint32
+	more bool
+	szx  uint8
+}
+
+// Decodes the value of a block option. False is returned for the reserved size exponent 7.
+func decodeBlockOption(v OptionValueType) (blockOption, bool) {
+	u := optionUint(v)
+	block := blockOption{num: uint32(u >> 4), more: u&0x08 != 0, szx: uint8(u & 0x07)}
+	return block, block.szx != 7
+}
+
+// Size of the block in bytes.
+func (b blockOption) size() int {
+	return 1 << (b.szx + 4)
+}
+
+// Request body reassembled from the blocks received so far.
+type blockBody struct {
+	payload []byte
+	expires time.Time
+}
+
+// Identifies the body a block belongs to, by the peer and the options of the request other than
+// Block1 and those not part of the cache key, like Size1 and Echo.
+// Spec: Request-Tag is part of the key, so that blocks of concurrent bodies with different
+// Request-Tags are kept apart (RFC 9175, section 3.3).
+func bodyKey(msg *Message) string {
+	var b bytes.Buffer
+	if msg.Source != nil {
+		b.WriteString(msg.Source.String())
+	}
+	b.WriteString(fmt.Sprintf("|%d.%02d", msg.Code.CodeClass, msg.Code.CodeDetail))
+
+	for _, o := range *msg.Options {
+		if o.Number == Block1 || o.Number.IsNoCacheKey() {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("|%d=%x", o.Number, []byte(o.Value)))
+	}
+	return b.String()
+}
+
+// Reassembles the body of a request sent in blocks using Block1 (RFC 7959, section 2.5).
+// Either the request carrying the complete body is returned, or the response to the block,
+// which is 2.31 Continue for blocks followed by further blocks.
+func (s *Server) assembleBody(msg *Message, now time.Time) (*Message, *Message) {
+	value, ok := msg.first(Block1)
+	if !ok {
+		return msg, nil
+	}
+	block, ok := decodeBlockOption(value)
+	var payload []byte
+	if msg.Payload != nil {
+		payload = msg.Payload.Content
+	}
+	// Spec: all blocks but the last one carry a payload of the block size.
+	if !ok || block.more && len(payload) != block.size() {
+		return nil, msg.Respond(BadRequest).message()
+	}
+
+	s.bodiesLock.Lock()
+	defer s.bodiesLock.Unlock()
+	for key, body := range s.bodies {
+		if now.After(body.expires) {
+			delete(s.bodies, key)
+		}
+	}
+
+	key := bodyKey(msg)
+	body, ok := s.bodies[key]
+	offset := int(block.num) * block.size()
+	switch {
+	case block.num == 0:
+		body = &blockBody{}
+	case !ok || len(body.payload) != offset:
+		// Spec: blocks not continuing a body are answered by 4.08 Request Entity Incomplete.
+		delete(s.bodies, key)
+		return nil, msg.Respond(RequestEntityIncomplete).message()
+	}
+	if offset+len(payload) > MaxBodySize {
+		delete(s.bodies, key)
+		return nil, msg.Respond(RequestEntityTooLarge).Option(Size1, uintOptionValue(MaxBodySize)).message()
+	}
+	body.payload = append(body.payload, payload...)
+
+	if block.more {
+		body.expires = now.Add(BodyLifetime)
+		s.bodies[key] = body
+		return nil, msg.Respond(Continue).Option(Block1, value).message()
+	}
+	delete(s.bodies, key)
+
+	request := msg.Clone()
+	request.Options.Del(Block1)
+	request.Options.Del(Size1)
+	request.Payload = &PayloadType{Content: body.payload}
+	if msg.Payload != nil {
+		request.Payload.Type = msg.Payload.Type
+	}
+	return request, nil
+}
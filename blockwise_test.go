@@ -0,0 +1,94 @@
+package coap
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+// Creates a block of a POST request to "/upload", with block size 16 and the given Request-Tags.
+func newBlockRequest(num uint64, more bool, payload []byte, tags ...OptionValueType) *Message {
+	value := num << 4
+	if more {
+		value |= 0x08
+	}
+	builder := NewConfirmableMessageBuilder().
+		Code(POST).
+		WithRandomMessageId().
+		WithRandomToken().
+		Option(UriPath, []byte("upload")).
+		Option(Block1, uintOptionValue(value))
+	if len(tags) > 0 {
+		builder = builder.Option(RequestTag, tags...)
+	}
+	return builder.WithPayload(ContentTypeTextPlain, payload).Build()
+}
+
+func TestServer_Block1(t *testing.T) {
+	c.Convey("Given a server with a resource receiving bodies", t, func() {
+		var bodies [][]byte
+		server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{
+			Path: "/upload",
+			OnPOST: func(request *Message) (*Message, error) {
+				bodies = append(bodies, request.Payload.Content)
+				return request.Respond(Changed).message(), nil
+			},
+		})
+		peer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4711}
+		send := func(request *Message) *Message {
+			resp, _ := NewMessageFromBytes(server.handleMessage(request.ToBytes(), peer, nil))
+			return resp
+		}
+		first, last := bytes.Repeat([]byte("a"), 16), []byte("bcdef")
+
+		c.Convey("When a body is sent in two blocks", func() {
+			cont := send(newBlockRequest(0, true, first))
+			changed := send(newBlockRequest(1, false, last))
+
+			c.Convey("Then the first block is answered by 2.31 Continue", func() {
+				c.So(*cont.Code, c.ShouldResemble, *Continue)
+				c.So(cont.Options.Get(Block1), c.ShouldResemble, []OptionValueType{uintOptionValue(0x08)})
+			})
+
+			c.Convey("And the handler receives the complete body", func() {
+				c.So(*changed.Code, c.ShouldResemble, *Changed)
+				c.So(changed.Options.Get(Block1), c.ShouldResemble, []OptionValueType{uintOptionValue(0x10)})
+				c.So(bodies, c.ShouldResemble, [][]byte{append(append([]byte{}, first...), last...)})
+			})
+		})
+
+		c.Convey("When the blocks of two bodies with different Request-Tags interleave", func() {
+			send(newBlockRequest(0, true, first, OptionValueType{0x01}))
+			send(newBlockRequest(0, true, bytes.Repeat([]byte("z"), 16), OptionValueType{0x02}))
+			send(newBlockRequest(1, false, []byte("1"), OptionValueType{0x01}))
+			send(newBlockRequest(1, false, []byte("2"), OptionValueType{0x02}))
+
+			c.Convey("Then the bodies are kept apart", func() {
+				c.So(bodies, c.ShouldResemble, [][]byte{
+					append(append([]byte{}, first...), '1'),
+					append(bytes.Repeat([]byte("z"), 16), '2'),
+				})
+			})
+		})
+
+		c.Convey("When the last block is sent with another Request-Tag than the first one", func() {
+			send(newBlockRequest(0, true, first))
+			resp := send(newBlockRequest(1, false, last, OptionValueType{0x01}))
+
+			c.Convey("Then 'Request Entity Incomplete' is returned", func() {
+				c.So(*resp.Code, c.ShouldResemble, *RequestEntityIncomplete)
+				c.So(bodies, c.ShouldBeEmpty)
+			})
+		})
+
+		c.Convey("When a block with a payload shorter than the block size is followed by further blocks", func() {
+			resp := send(newBlockRequest(0, true, last))
+
+			c.Convey("Then 'Bad Request' is returned", func() {
+				c.So(*resp.Code, c.ShouldResemble, *BadRequest)
+			})
+		})
+	})
+}
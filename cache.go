@@ -0,0 +1,251 @@
+package coap
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultCacheSize is the size of cached responses in bytes, a proxy cache is bounded by.
+const DefaultCacheSize = 1 << 20
+
+// Cached response and the time it expires at.
+type cacheEntry struct {
+	key      string
+	resource string
+	response *Message
+	expires  time.Time
+	size     int
+}
+
+// CacheStats are the statistics of a response cache.
+type CacheStats struct {
+	// Hits counts requests answered from cache without contacting the server.
+	Hits uint64
+	// Misses counts requests sent to the server, including revalidations.
+	Misses uint64
+	// Revalidations counts stale responses confirmed by 2.03 Valid.
+	Revalidations uint64
+	// Evictions counts responses evicted to keep the cache within its size.
+	Evictions uint64
+	// Entries is the number of cached responses.
+	Entries int
+	// Size is the size of cached responses in bytes.
+	Size int
+}
+
+// ResponseCache caches responses keyed by the cache key of their requests (RFC 7252, section 5.6).
+// Least recently used responses are evicted, when the size of cached responses exceeds the maximum.
+type ResponseCache struct {
+	lock    sync.Mutex
+	maxSize int
+	entries map[string]*list.Element
+	lru     *list.List
+	stats   CacheStats
+}
+
+// NewResponseCache creates a cache bounded by the given size of cached responses in bytes.
+func NewResponseCache(maxSize int) *ResponseCache {
+	return &ResponseCache{
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Get string representation of the cache
+func (c *ResponseCache) String() string {
+	return fmt.Sprintf("ResponseCache{ maxSize=%d, stats=%+v }", c.maxSize, c.Stats())
+}
+
+// Stats returns the statistics of the cache.
+func (c *ResponseCache) Stats() CacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.stats
+}
+
+// Computes the cache key of the request sent to the endpoint: method and all options,
+// except NoCacheKey options and ETags used for validation.
+func cacheKey(endpoint string, request *Message) string {
+	var b bytes.Buffer
+	b.WriteString(endpoint)
+	b.WriteString(fmt.Sprintf("|%d.%02d", request.Code.CodeClass, request.Code.CodeDetail))
+
+	for _, o := range *request.Options {
+		if o.Number.IsNoCacheKey() || o.Number == ETag {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("|%d=%x", o.Number, []byte(o.Value)))
+	}
+	return b.String()
+}
+
+// Identifies the resource the request sent to the endpoint is for, by its Uri-* options.
+// All responses cached for a resource share the resource key, regardless of their cache keys.
+func resourceKey(endpoint string, request *Message) string {
+	var b bytes.Buffer
+	b.WriteString(endpoint)
+	for _, o := range *request.Options {
+		switch o.Number {
+		case UriHost, UriPort, UriPath, UriQuery:
+			b.WriteString(fmt.Sprintf("|%d=%x", o.Number, []byte(o.Value)))
+		}
+	}
+	return b.String()
+}
+
+// Checks whether the response may be stored.
+func isCacheable(response *Message) bool {
+	return *response.Code == *Content
+}
+
+// Returns the time the response stays fresh for, given by its Max-Age option.
+func maxAgeOf(response *Message) time.Duration {
+	if maxAge, ok := response.GetUint(MaxAge); ok {
+		return time.Duration(maxAge) * time.Second
+	}
+	return time.Duration(defaultMaxAge()) * time.Second
+}
+
+func defaultMaxAge() uint64 {
+	return uint64(OptionLookupTable[MaxAge].Default.(int))
+}
+
+// Looks up the entry for the key, counting a hit if it is fresh, otherwise a miss.
+func (c *ResponseCache) lookup(key string, now time.Time) (*cacheEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	entry := element.Value.(*cacheEntry)
+	if entry.isFresh(now) {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+	return entry, true
+}
+
+func (c *ResponseCache) get(key string) (*cacheEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, ok := c.entries[key]; ok {
+		return element.Value.(*cacheEntry), true
+	}
+	return nil, false
+}
+
+func (c *ResponseCache) put(key string, resource string, response *Message, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.store(&cacheEntry{key: key, resource: resource, response: response, expires: now.Add(maxAgeOf(response))})
+}
+
+// Removes all responses cached for the resource.
+func (c *ResponseCache) invalidate(resource string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for element := c.lru.Front(); element != nil; {
+		entry := element.Value.(*cacheEntry)
+		element = element.Next()
+		if entry.resource == resource {
+			c.remove(entry.key)
+		}
+	}
+}
+
+// Spec: a successful response to an unsafe request, like 2.04 Changed, makes the responses
+// cached for the resource stale (RFC 7252, section 5.9.1).
+func invalidatesCache(request *Message, response *Message) bool {
+	return *request.Code != *GET && response.Code.CodeClass == 2
+}
+
+// Updates freshness of the entry after successful revalidation with the given 2.03 Valid response.
+// Spec: the options present in the 2.03 response replace those of the stored response
+// (RFC 7252, section 5.9.1.3).
+func (c *ResponseCache) refresh(key string, valid *Message, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	entry := element.Value.(*cacheEntry)
+	options := copyOptions(entry.response.Options)
+	options.Del(MaxAge)
+	for _, o := range *valid.Options {
+		options.Del(o.Number)
+	}
+	for _, o := range *valid.Options {
+		options.Add(o.Number, o.Value)
+	}
+	response := *entry.response
+	response.Options = options
+	c.stats.Revalidations++
+	c.store(&cacheEntry{key: key, resource: entry.resource, response: &response, expires: now.Add(maxAgeOf(valid))})
+}
+
+// Stores the entry as most recently used and evicts least recently used entries
+// exceeding the size of the cache. Entries larger than the cache are not stored.
+func (c *ResponseCache) store(entry *cacheEntry) {
+	entry.size = len(entry.key) + len(entry.response.ToBytes())
+	c.remove(entry.key)
+	if entry.size > c.maxSize {
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.stats.Entries++
+	c.stats.Size += entry.size
+
+	for c.stats.Size > c.maxSize {
+		c.remove(c.lru.Back().Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+func (c *ResponseCache) remove(key string) {
+	if element, ok := c.entries[key]; ok {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+		c.stats.Entries--
+		c.stats.Size -= element.Value.(*cacheEntry).size
+	}
+}
+
+func (e *cacheEntry) isFresh(now time.Time) bool {
+	return now.Before(e.expires)
+}
+
+// ETag of the cached response, if any.
+func (e *cacheEntry) etag() (OptionValueType, bool) {
+	etag, ok := e.response.GetOpaque(ETag)
+	return etag, ok
+}
+
+// Creates the response to the request from a copy of the cached response. Spec: Max-Age is set to
+// the remaining time the response stays fresh.
+func (e *cacheEntry) responseTo(request *Message, now time.Time) *Message {
+	response := e.response.Clone()
+	remaining := e.expires.Sub(now)
+	if remaining < 0 {
+		remaining = 0
+	}
+	response.Options.Set(MaxAge, uintOptionValue(uint64(remaining/time.Second)))
+
+	reply := request.Respond(response.Code).message()
+	response.Type, response.MessageID, response.Token = reply.Type, reply.MessageID, reply.Token
+	response.Source, response.Identity = nil, nil
+	return response
+}
+
+func copyOptions(options *OptionsType) *OptionsType {
+	c := append(OptionsType(nil), *options...)
+	return &c
+}
@@ -0,0 +1,213 @@
+package coap
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCacheKey(t *testing.T) {
+	c.Convey("Given a request", t, func() {
+		req := newProxyRequest(GET).Option(UriPath, OptionValueType("temp")).Build()
+		key := cacheKey("device:5683", req)
+
+		c.Convey("Then NoCacheKey options and ETags do not change the key", func() {
+			other := newProxyRequest(GET).
+				Option(UriPath, OptionValueType("temp")).
+				Option(Size1, uintOptionValue(100)).
+				Option(ETag, OptionValueType{0x01}).
+				Build()
+			c.So(cacheKey("device:5683", other), c.ShouldEqual, key)
+		})
+
+		c.Convey("And other options, method and endpoint change the key", func() {
+			query := newProxyRequest(GET).Option(UriPath, OptionValueType("temp")).Option(UriQuery, OptionValueType("unit=C")).Build()
+			c.So(cacheKey("device:5683", query), c.ShouldNotEqual, key)
+			c.So(cacheKey("device:5683", newProxyRequest(POST).Option(UriPath, OptionValueType("temp")).Build()), c.ShouldNotEqual, key)
+			c.So(cacheKey("other:5683", req), c.ShouldNotEqual, key)
+		})
+	})
+}
+
+func TestResponseCache_Invalidate(t *testing.T) {
+	c.Convey("Given a cache with two representations of a resource and another resource", t, func() {
+		temp := newProxyRequest(GET).Option(UriPath, OptionValueType("temp")).Build()
+		json := newProxyRequest(GET).Option(UriPath, OptionValueType("temp")).Option(Accept, uintOptionValue(ContentTypeApplicationJson)).Build()
+		other := newProxyRequest(GET).Option(UriPath, OptionValueType("other")).Build()
+		cache := NewResponseCache(DefaultCacheSize)
+		now := time.Now()
+		for _, req := range []*Message{temp, json, other} {
+			cache.put(cacheKey("device:5683", req), resourceKey("device:5683", req), NewContentResponseMessage(req), now)
+		}
+
+		c.Convey("When the resource is invalidated", func() {
+			cache.invalidate(resourceKey("device:5683", newProxyRequest(PUT).Option(UriPath, OptionValueType("temp")).Build()))
+
+			c.Convey("Then all of its responses are removed", func() {
+				_, ok := cache.get(cacheKey("device:5683", temp))
+				c.So(ok, c.ShouldBeFalse)
+				_, ok = cache.get(cacheKey("device:5683", json))
+				c.So(ok, c.ShouldBeFalse)
+				_, ok = cache.get(cacheKey("device:5683", other))
+				c.So(ok, c.ShouldBeTrue)
+				c.So(cache.Stats().Entries, c.ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestResponseCache_Eviction(t *testing.T) {
+	c.Convey("Given a cache with room for two responses", t, func() {
+		response := NewContentResponseMessage(newTestRequest(Confirmable))
+		entrySize := len("a") + len(response.ToBytes())
+		cache := NewResponseCache(2 * entrySize)
+		now := time.Now()
+
+		c.Convey("When a third response is stored after using the first one", func() {
+			cache.put("a", "/a", response, now)
+			cache.put("b", "/b", response, now)
+			cache.lookup("a", now)
+			cache.put("c", "/c", response, now)
+
+			c.Convey("Then the least recently used response is evicted", func() {
+				_, a := cache.get("a")
+				_, b := cache.get("b")
+				c.So(a, c.ShouldBeTrue)
+				c.So(b, c.ShouldBeFalse)
+				c.So(cache.Stats().Evictions, c.ShouldEqual, 1)
+				c.So(cache.Stats().Entries, c.ShouldEqual, 2)
+				c.So(cache.Stats().Size, c.ShouldEqual, 2*entrySize)
+			})
+		})
+
+		c.Convey("When a response larger than the cache is stored", func() {
+			cache.put("large", "/large", NewAcknowledgementMessageBuilder().
+				Code(Content).
+				MessageId(1).
+				Token(&TokenType{}).
+				WithPayload(ContentTypeTextPlain, make([]byte, 2*entrySize)).
+				Build(), now)
+
+			c.Convey("Then it is not stored", func() {
+				c.So(cache.Stats().Entries, c.ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestResponseCache_Refresh(t *testing.T) {
+	c.Convey("Given a cache with a stale response", t, func() {
+		request := newProxyRequest(GET).Option(UriPath, OptionValueType("temp")).Build()
+		cache := NewResponseCache(DefaultCacheSize)
+		now := time.Now()
+		cache.put("temp", "/temp", NewAcknowledgementMessageBuilder().
+			Code(Content).
+			MessageId(request.MessageID).
+			Token(request.Token).
+			Option(ETag, OptionValueType{0x01}).
+			Option(MaxAge, uintOptionValue(0)).
+			WithPayload(ContentTypeTextPlain, []byte("21.5")).
+			Build(), now)
+
+		c.Convey("When it is refreshed by a 2.03 Valid response with another ETag", func() {
+			cache.refresh("temp", NewAcknowledgementMessageBuilder().
+				Code(Valid).
+				MessageId(request.MessageID).
+				Token(request.Token).
+				Option(ETag, OptionValueType{0x02}).
+				Option(MaxAge, uintOptionValue(30)).
+				Build(), now)
+
+			c.Convey("Then the stored response carries the options of the 2.03 response", func() {
+				entry, ok := cache.get("temp")
+				c.So(ok, c.ShouldBeTrue)
+				etag, _ := entry.etag()
+				c.So(etag, c.ShouldResemble, OptionValueType{0x02})
+				c.So(entry.response.Options.Get(ETag), c.ShouldHaveLength, 1)
+				c.So(entry.response.Options.Get(MaxAge), c.ShouldResemble, []OptionValueType{uintOptionValue(30)})
+				c.So(entry.response.Options.Get(ContentFormat), c.ShouldResemble, []OptionValueType{uintOptionValue(uint64(ContentTypeTextPlain))})
+				c.So(entry.response.Payload.Content, c.ShouldResemble, []byte("21.5"))
+			})
+		})
+	})
+}
+
+func TestClient_DoWithCache(t *testing.T) {
+	c.Convey("Given a client using a cache", t, func() {
+		conn, counts := startTestOrigin()
+		defer conn.Close()
+
+		client, _ := NewClient(conn.LocalAddr().String())
+		defer client.Close()
+		cache := NewResponseCache(DefaultCacheSize)
+		client.UseCache(cache)
+
+		get := func(path string) *Message {
+			resp, err := client.Do(newProxyRequest(GET).Option(UriPath, OptionValueType(path)).Build())
+			c.So(err, c.ShouldBeNil)
+			return resp
+		}
+
+		c.Convey("When a fresh resource is requested twice", func() {
+			get("temp")
+			resp := get("temp")
+
+			c.Convey("Then the second response is served from cache", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So(resp.Payload.Content, c.ShouldResemble, []byte("21.5"))
+				c.So(atomic.LoadInt32(counts["/temp"]), c.ShouldEqual, 1)
+				c.So(cache.Stats().Hits, c.ShouldEqual, 1)
+				c.So(cache.Stats().Misses, c.ShouldEqual, 1)
+			})
+		})
+
+		c.Convey("When a stale resource is requested again", func() {
+			get("stale")
+			resp := get("stale")
+
+			c.Convey("Then it is revalidated with its ETag", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So(resp.Payload.Content, c.ShouldResemble, []byte("21.5"))
+				c.So(atomic.LoadInt32(counts["/stale"]), c.ShouldEqual, 2)
+				c.So(cache.Stats().Revalidations, c.ShouldEqual, 1)
+			})
+		})
+
+		c.Convey("When a cached resource is changed", func() {
+			get("temp")
+			changed, err := client.Do(newProxyRequest(PUT).
+				Option(UriPath, OptionValueType("temp")).
+				WithPayload(ContentTypeTextPlain, []byte("22.0")).
+				Build())
+			get("temp")
+
+			c.Convey("Then the cached response is invalidated", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(*changed.Code, c.ShouldResemble, *Changed)
+				c.So(atomic.LoadInt32(counts["/temp"]), c.ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func TestCoapProxy_CacheStats(t *testing.T) {
+	c.Convey("Given a proxy which served a resource twice", t, func() {
+		conn, _ := startTestOrigin()
+		defer conn.Close()
+
+		proxy := NewCoapProxy(DefaultTransmissionParameters())
+		defer proxy.Close()
+		server, _ := NewInsecureCoapServerWithDefaultParameters()
+		server.AddProxy("coap", proxy.Forward)
+		for i := 0; i < 2; i++ {
+			server.routeRequest(newProxyRequest(GET).Option(ProxyUri, OptionValueType("coap://"+conn.LocalAddr().String()+"/temp")).Build())
+		}
+
+		c.Convey("Then one hit and one miss are counted", func() {
+			c.So(proxy.CacheStats().Hits, c.ShouldEqual, 1)
+			c.So(proxy.CacheStats().Misses, c.ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,78 @@
+package coap
+
+import (
+	"errors"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+var PayloadIsNotCBOR = errors.New("payload is not cbor")
+
+var (
+	cborEncoder              cbor.EncMode
+	cborDeterministicEncoder cbor.EncMode
+)
+
+func init() {
+	var err error
+	if cborEncoder, err = (cbor.EncOptions{}).EncMode(); err != nil {
+		panic(err)
+	}
+	// Spec: core deterministic encoding requirements of RFC 8949, section 4.2.1
+	if cborDeterministicEncoder, err = cbor.CoreDetEncOptions().EncMode(); err != nil {
+		panic(err)
+	}
+}
+
+// MarshalCBOR encodes the value as CBOR (RFC 8949), Go structs are encoded as maps
+// keyed by field names or their "cbor" tags.
+func MarshalCBOR(v interface{}) ([]byte, error) {
+	return cborEncoder.Marshal(v)
+}
+
+// MarshalCBORDeterministic encodes the value using core deterministic encoding (RFC 8949, section 4.2.1),
+// e.g. for content which is hashed or signed.
+func MarshalCBORDeterministic(v interface{}) ([]byte, error) {
+	return cborDeterministicEncoder.Marshal(v)
+}
+
+// Encodes fixed structures, which can't fail to be encoded, deterministically.
+func mustMarshalCBOR(v interface{}) []byte {
+	b, err := MarshalCBORDeterministic(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// UnmarshalCBOR decodes the CBOR data into the value.
+func UnmarshalCBOR(data []byte, v interface{}) error {
+	return cbor.Unmarshal(data, v)
+}
+
+// DiagnoseCBOR returns the CBOR data in diagnostic notation (RFC 8949, section 8).
+func DiagnoseCBOR(data []byte) (string, error) {
+	return cbor.Diagnose(data)
+}
+
+// DecodeCBOR decodes the CBOR payload of the message into the value.
+func (m *Message) DecodeCBOR(v interface{}) error {
+	if m.Payload == nil || m.Payload.Type == nil || *m.Payload.Type != ContentTypeApplicationCbor {
+		return PayloadIsNotCBOR
+	}
+	return UnmarshalCBOR(m.Payload.Content, v)
+}
+
+// NewCBORContentResponseMessage creates a 2.05 Content response to the request with the value encoded as CBOR.
+func NewCBORContentResponseMessage(request *Message, v interface{}) (*Message, error) {
+	content, err := MarshalCBOR(v)
+	if err != nil {
+		return nil, err
+	}
+	return NewAcknowledgementMessageBuilder().
+		Code(Content).
+		MessageId(request.MessageID).
+		Token(request.Token).
+		WithPayload(ContentTypeApplicationCbor, content).
+		Build(), nil
+}
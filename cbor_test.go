@@ -0,0 +1,82 @@
+package coap
+
+import (
+	"testing"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+type testReading struct {
+	Name  string  `cbor:"n"`
+	Value float64 `cbor:"v"`
+}
+
+func TestCBOR_RoundTrip(t *testing.T) {
+	c.Convey("Given a Go struct", t, func() {
+		reading := testReading{Name: "temp", Value: 21.5}
+
+		c.Convey("When encoded and decoded", func() {
+			data, err := MarshalCBOR(reading)
+			c.So(err, c.ShouldBeNil)
+			var decoded testReading
+			err = UnmarshalCBOR(data, &decoded)
+
+			c.Convey("Then the struct is preserved", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(decoded, c.ShouldResemble, reading)
+			})
+		})
+	})
+}
+
+func TestCBOR_Deterministic(t *testing.T) {
+	c.Convey("Given values with several valid encodings", t, func() {
+		c.Convey("Then map keys are sorted bytewise", func() {
+			data, _ := MarshalCBORDeterministic(map[string]int{"aa": 3, "b": 2, "a": 1})
+			c.So(data, c.ShouldResemble, fromHex("a361610161620262616103"))
+		})
+		c.Convey("And numbers take the shortest form (RFC 8949, appendix A)", func() {
+			data, _ := MarshalCBORDeterministic([]interface{}{1000000, 1.5})
+			c.So(data, c.ShouldResemble, fromHex("821a000f4240f93e00"))
+		})
+	})
+}
+
+func TestPayloadType_StringWithCBOR(t *testing.T) {
+	c.Convey("Given a CBOR payload", t, func() {
+		cType := ContentType(ContentTypeApplicationCbor)
+		payload := &PayloadType{Type: &cType, Content: fromHex("a1616101")}
+
+		c.Convey("Then it is printed in diagnostic notation", func() {
+			c.So(payload.String(), c.ShouldEqual, `{"a": 1}`)
+		})
+	})
+}
+
+func TestMessage_DecodeCBOR(t *testing.T) {
+	c.Convey("Given a CBOR response", t, func() {
+		resp, err := NewCBORContentResponseMessage(newTestRequest(Confirmable), testReading{Name: "temp", Value: 21.5})
+		c.So(err, c.ShouldBeNil)
+
+		c.Convey("When it is decoded", func() {
+			var reading testReading
+			err := resp.DecodeCBOR(&reading)
+
+			c.Convey("Then the value is restored", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(reading.Value, c.ShouldEqual, 21.5)
+				c.So(resp.Options.Get(ContentFormat)[0], c.ShouldResemble, OptionValueType{60})
+			})
+		})
+	})
+
+	c.Convey("Given a plain text response", t, func() {
+		resp := NewAcknowledgementMessageBuilder().Code(Content).MessageId(1).Token(&TokenType{}).
+			WithPayload(ContentTypeTextPlain, []byte("21.5")).Build()
+
+		c.Convey("Then decoding CBOR fails", func() {
+			var reading testReading
+			c.So(resp.DecodeCBOR(&reading), c.ShouldEqual, PayloadIsNotCBOR)
+		})
+	})
+}
@@ -0,0 +1,269 @@
+package coap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	RequestTimedOut = errors.New("request timed out")
+	RequestWasReset = errors.New("request was reset by peer")
+)
+
+// Client sends requests to a single CoAP endpoint.
+// Requests are sent one after another, which respects NSTART of 1.
+type Client struct {
+	conn       *net.UDPConn
+	parameters TransmissionParameters
+	oscore     *OSCOREContext
+	cache      *ResponseCache
+	options    *OptionRegistry
+	lock       sync.Mutex
+}
+
+// NewClient creates a client for the endpoint at given address ("host:port"),
+// using default transmission parameters.
+func NewClient(addr string) (*Client, error) {
+	return NewClientWithParameters(addr, DefaultTransmissionParameters())
+}
+
+// NewClientWithParameters creates a client for the endpoint at given address ("host:port"),
+// using given transmission parameters.
+func NewClientWithParameters(addr string, parameters TransmissionParameters) (*Client, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{conn: conn, parameters: parameters, options: NewOptionRegistry()}, nil
+}
+
+// Get string representation of the client
+func (c *Client) String() string {
+	return fmt.Sprintf("Client{ remote=%v, parameters=%v }", c.conn.RemoteAddr(), c.parameters)
+}
+
+// Close releases the connection of the client.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// UseOSCORE protects all further requests with the given OSCORE security context.
+func (c *Client) UseOSCORE(ctx *OSCOREContext) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.oscore = ctx
+}
+
+// DefineOption defines a custom option of given number, which the client accepts in responses.
+func (c *Client) DefineOption(number OptionNumberType, definition OptionDefinition) error {
+	return c.options.Define(number, definition)
+}
+
+// UseCache answers further GET requests from the given cache while fresh,
+// stale responses are revalidated using ETag. Requests carrying an ETag bypass the cache.
+// Successful POST, PUT or DELETE requests remove the cached responses of their resource.
+func (c *Client) UseCache(cache *ResponseCache) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.cache = cache
+}
+
+// Do sends the request and waits for its response. Confirmable requests are retransmitted
+// until acknowledged, both piggybacked and separate responses are accepted.
+// A request challenged with an Echo option (RFC 9175) is repeated once carrying the Echo value.
+// If the request suppresses responses using No-Response (RFC 7967) and none is received,
+// nil is returned without error. Requests with options violating their definitions are not sent.
+func (c *Client) Do(request *Message) (*Message, error) {
+	if err := c.options.Validate(request.Options); err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.cache != nil && *request.Code == *GET && !request.HasOption(ETag) {
+		return c.doCached(request)
+	}
+	resp, err := c.doWithEcho(request)
+	if c.cache != nil && resp != nil && invalidatesCache(request, resp) {
+		c.cache.invalidate(resourceKey(c.conn.RemoteAddr().String(), request))
+	}
+	return resp, err
+}
+
+func (c *Client) doWithEcho(request *Message) (*Message, error) {
+	resp, err := c.do(request)
+	if err != nil || resp == nil || *resp.Code != *Unauthorized || !resp.HasOption(Echo) || request.HasOption(Echo) {
+		return resp, err
+	}
+	logger.Debugf("repeating request %v with echo value", request.MessageID)
+	return c.do(withEcho(request, resp.Options.Get(Echo)[0]))
+}
+
+func (c *Client) doCached(request *Message) (*Message, error) {
+	key := cacheKey(c.conn.RemoteAddr().String(), request)
+	entry, ok := c.cache.lookup(key, time.Now())
+	if ok && entry.isFresh(time.Now()) {
+		return entry.responseTo(request, time.Now()), nil
+	}
+
+	send := request
+	etag, revalidate := OptionValueType(nil), false
+	if ok {
+		if etag, revalidate = entry.etag(); revalidate {
+			options := copyOptions(request.Options)
+			options.Set(ETag, etag)
+			validation := *request
+			validation.Options = options
+			send = &validation
+		}
+	}
+
+	resp, err := c.doWithEcho(send)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+	if revalidate && *resp.Code == *Valid && (!resp.HasOption(ETag) || hasETag(resp, etag)) {
+		c.cache.refresh(key, resp, time.Now())
+		if entry, ok = c.cache.get(key); ok {
+			return entry.responseTo(resp, time.Now()), nil
+		}
+	} else if isCacheable(resp) {
+		c.cache.put(key, resourceKey(c.conn.RemoteAddr().String(), request), resp, time.Now())
+	}
+	return resp, nil
+}
+
+func (c *Client) do(request *Message) (*Message, error) {
+	if c.oscore == nil {
+		return c.exchange(request)
+	}
+
+	protected, exchange, err := c.oscore.protectRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.exchange(protected)
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	return c.oscore.unprotectResponse(resp, exchange, c.options)
+}
+
+func (c *Client) exchange(req *Message) (*Message, error) {
+	packet, err := req.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("will send message %v", req)
+	if _, err := c.conn.Write(packet); err != nil {
+		return nil, err
+	}
+
+	// Spec: initial timeout is a random duration between ACK_TIMEOUT and ACK_TIMEOUT * ACK_RANDOM_FACTOR
+	timeout := c.parameters.initialTimeout()
+	retransmit := time.Now().Add(timeout)
+	deadline := time.Now().Add(c.parameters.MaxTransmitWait())
+	retransmissions := 0
+	acknowledged := req.Type != Confirmable
+	suppressed := req.SuppressedResponses()
+	if acknowledged && suppressed == SuppressAll {
+		return nil, nil
+	}
+
+	buffer := make([]byte, MaxPacketSize)
+	for {
+		wait := deadline
+		if !acknowledged && retransmit.Before(wait) {
+			wait = retransmit
+		}
+		c.conn.SetReadDeadline(wait)
+
+		n, err := c.conn.Read(buffer)
+		if err != nil {
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				return nil, err
+			}
+			if acknowledged && suppressed != 0 {
+				// response was suppressed by the peer
+				return nil, nil
+			}
+			if acknowledged || retransmissions >= c.parameters.MaxRetransmit || time.Now().After(deadline) {
+				return nil, RequestTimedOut
+			}
+			retransmissions++
+			timeout *= 2
+			retransmit = time.Now().Add(timeout)
+			logger.Debugf("retransmission #%d of message %v", retransmissions, req.MessageID)
+			if _, err := c.conn.Write(packet); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		msg, err := decode(buffer[0:n], nil, c.options)
+		if err != nil {
+			logger.Debugf("error decoding message: %v", err)
+			continue
+		}
+		logger.Debugf("message received: %v", msg)
+
+		switch msg.Type {
+
+		case Acknowledgement:
+			if msg.MessageID != req.MessageID {
+				continue
+			}
+			if *msg.Code == *EmptyMessage {
+				if suppressed == SuppressAll {
+					return nil, nil
+				}
+				// separate response will follow
+				acknowledged = true
+				deadline = time.Now().Add(c.parameters.MaxTransmitWait())
+				continue
+			}
+			if bytes.Equal(*msg.Token, *req.Token) {
+				return msg, nil
+			}
+
+		case Reset:
+			if msg.MessageID == req.MessageID {
+				return nil, RequestWasReset
+			}
+
+		case Confirmable, NonConfirmable:
+			if !bytes.Equal(*msg.Token, *req.Token) {
+				if msg.Type == Confirmable {
+					// Spec: unexpected confirmable messages are rejected with reset.
+					c.conn.Write(NewResetMessageBuilder().Code(EmptyMessage).MessageId(msg.MessageID).Token(&TokenType{}).Build().ToBytes())
+				}
+				continue
+			}
+			if msg.Type == Confirmable {
+				c.conn.Write(NewAcknowledgementMessageBuilder().Code(EmptyMessage).MessageId(msg.MessageID).Token(&TokenType{}).Build().ToBytes())
+			}
+			return msg, nil
+		}
+	}
+}
+
+// Creates a copy of the request with a new message ID, which carries the given Echo value.
+func withEcho(request *Message, echo OptionValueType) *Message {
+	options := copyOptions(request.Options)
+	options.Set(Echo, echo)
+
+	repeated := *request
+	repeated.MessageID = NewMessageId()
+	repeated.Options = options
+	return &repeated
+}
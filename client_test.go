@@ -0,0 +1,158 @@
+package coap
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+// Parameters with short timeouts, so that retransmissions can be tested quickly.
+func testTransmissionParameters() TransmissionParameters {
+	p := DefaultTransmissionParameters()
+	p.AckTimeout = 20 * time.Millisecond
+	p.MaxRetransmit = 2
+	return p
+}
+
+// Starts a fake peer on loopback, which answers every received message using the given function.
+func startTestPeer(respond func(msg *Message) []*Message) (*net.UDPConn, func() int) {
+	conn, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	received := make(chan int, 100)
+	go func() {
+		buffer := make([]byte, MaxPacketSize)
+		count := 0
+		for {
+			n, peer, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			count++
+			received <- count
+			msg, _ := NewMessageFromBytes(buffer[0:n])
+			for _, r := range respond(msg) {
+				conn.WriteToUDP(r.ToBytes(), peer)
+			}
+		}
+	}()
+	return conn, func() int {
+		count := 0
+		for {
+			select {
+			case count = <-received:
+			default:
+				return count
+			}
+		}
+	}
+}
+
+func newTestRequest(mType MessageType) *Message {
+	return NewMessageBuilderOfType(mType).
+		Code(GET).
+		WithRandomMessageId().
+		WithRandomToken().
+		Option(UriPath, []byte("rd")).
+		Build()
+}
+
+func TestClient_DoWithServer(t *testing.T) {
+	c.Convey("Given a coap server on loopback", t, func() {
+		server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{
+			Path: "/rd",
+			OnGET: func(request *Message) (*Message, error) {
+				return NewContentResponseMessage(request), nil
+			},
+		})
+		conn, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		go server.Serve(conn)
+		defer conn.Close()
+
+		client, err := NewClient(conn.LocalAddr().String())
+		c.So(err, c.ShouldBeNil)
+		defer client.Close()
+
+		c.Convey("When a confirmable request is sent", func() {
+			req := newTestRequest(Confirmable)
+			resp, err := client.Do(req)
+
+			c.Convey("Then the piggybacked response is returned", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So(resp.MessageID, c.ShouldEqual, req.MessageID)
+				c.So(*resp.Token, c.ShouldResemble, *req.Token)
+			})
+		})
+	})
+}
+
+func TestClient_DoWithSeparateResponse(t *testing.T) {
+	c.Convey("Given a peer sending an empty ACK followed by a separate response", t, func() {
+		conn, _ := startTestPeer(func(msg *Message) []*Message {
+			if msg.Type == Acknowledgement {
+				return nil
+			}
+			return []*Message{
+				NewAcknowledgementMessageBuilder().Code(EmptyMessage).MessageId(msg.MessageID).Token(&TokenType{}).Build(),
+				NewConfirmableMessageBuilder().Code(Content).MessageId(0x4711).Token(msg.Token).Build(),
+			}
+		})
+		defer conn.Close()
+
+		client, _ := NewClientWithParameters(conn.LocalAddr().String(), testTransmissionParameters())
+		defer client.Close()
+
+		c.Convey("When a confirmable request is sent", func() {
+			resp, err := client.Do(newTestRequest(Confirmable))
+
+			c.Convey("Then the separate response is returned", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So(resp.Type, c.ShouldEqual, Confirmable)
+			})
+		})
+	})
+}
+
+func TestClient_DoRetransmitsUntilTimeout(t *testing.T) {
+	c.Convey("Given a peer which never responds", t, func() {
+		conn, received := startTestPeer(func(msg *Message) []*Message {
+			return nil
+		})
+		defer conn.Close()
+
+		params := testTransmissionParameters()
+		client, _ := NewClientWithParameters(conn.LocalAddr().String(), params)
+		defer client.Close()
+
+		c.Convey("When a confirmable request is sent", func() {
+			_, err := client.Do(newTestRequest(Confirmable))
+
+			c.Convey("Then the request is retransmitted and times out", func() {
+				c.So(err, c.ShouldEqual, RequestTimedOut)
+				c.So(received(), c.ShouldEqual, 1+params.MaxRetransmit)
+			})
+		})
+	})
+}
+
+func TestClient_DoWithReset(t *testing.T) {
+	c.Convey("Given a peer which rejects every message", t, func() {
+		conn, _ := startTestPeer(func(msg *Message) []*Message {
+			return []*Message{NewResetMessageBuilder().Code(EmptyMessage).MessageId(msg.MessageID).Token(&TokenType{}).Build()}
+		})
+		defer conn.Close()
+
+		client, _ := NewClientWithParameters(conn.LocalAddr().String(), testTransmissionParameters())
+		defer client.Close()
+
+		c.Convey("When a confirmable request is sent", func() {
+			_, err := client.Do(newTestRequest(Confirmable))
+
+			c.Convey("Then 'Request Was Reset' is indicated by error", func() {
+				c.So(err, c.ShouldEqual, RequestWasReset)
+			})
+		})
+	})
+}
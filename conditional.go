@@ -0,0 +1,92 @@
+package coap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// Evaluates the conditions of the request against the current ETag of the resource (RFC 7252, section 5.10).
+// Returns the response to send instead of calling the handler, or nil to go on.
+func (r *Resource) evaluateConditions(msg *Message) *Message {
+	if r.ETag == nil {
+		return nil
+	}
+	etag := r.ETag()
+
+	switch *msg.Code {
+	case *GET:
+		// validation applies to the representation negotiated for the request
+		if len(r.Representations) > 0 {
+			representation, ok := r.selectRepresentation(msg)
+			if !ok {
+				// answered with 4.06 Not Acceptable by negotiation
+				return nil
+			}
+			etag = representationETag(etag, representation.ContentType)
+		}
+		// Spec: a matching ETag validates the representation of the client.
+		for _, v := range msg.Options.Get(ETag) {
+			if etag != nil && bytes.Equal(v, etag) {
+				return msg.Respond(Valid).Option(ETag, etag).message()
+			}
+		}
+
+	case *PUT, *DELETE:
+		if values := msg.Options.Get(IfMatch); len(values) > 0 && !r.matchesAny(values, etag) {
+			return msg.Respond(PreconditionFailed).message()
+		}
+		// Spec: If-None-Match is only fulfilled, if the resource does not exist.
+		if msg.HasOption(IfNoneMatch) && etag != nil {
+			return msg.Respond(PreconditionFailed).message()
+		}
+	}
+	return nil
+}
+
+// Sets the current ETag of the resource on successful GET responses, which do not carry one.
+// Responses of representations carry the ETag of their content format.
+func (r *Resource) tagResponse(resp *Message) *Message {
+	if r.ETag == nil || *resp.Code != *Content || resp.HasOption(ETag) {
+		return resp
+	}
+	etag := r.ETag()
+	if format, ok := resp.GetUint(ContentFormat); ok && len(r.Representations) > 0 {
+		etag = representationETag(etag, ContentType(format))
+	}
+	if etag != nil {
+		resp.Options.Set(ETag, etag)
+	}
+	return resp
+}
+
+// Checks If-Match values against the ETag, an empty value matches any existing resource.
+// A resource with representations matches the ETags of each of them as well.
+func (r *Resource) matchesAny(values []OptionValueType, etag []byte) bool {
+	if etag == nil {
+		return false
+	}
+	for _, v := range values {
+		if len(v) == 0 || bytes.Equal(v, etag) {
+			return true
+		}
+		for _, representation := range r.Representations {
+			if bytes.Equal(v, representationETag(etag, representation.ContentType)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Derives the ETag of a representation from the ETag of the resource and its content format,
+// as representations in different content formats must not share an ETag (RFC 7252, section 5.10.6).
+func representationETag(etag []byte, cType ContentType) []byte {
+	if etag == nil {
+		return nil
+	}
+	h := sha256.New()
+	h.Write(etag)
+	binary.Write(h, binary.BigEndian, uint16(cType))
+	return h.Sum(nil)[0:maxETagLength]
+}
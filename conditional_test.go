@@ -0,0 +1,129 @@
+package coap
+
+import (
+	"testing"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+func TestServer_ConditionalRequests(t *testing.T) {
+	c.Convey("Given a server with a config resource declaring its ETag", t, func() {
+		config := []byte("v1")
+		etag := func() []byte {
+			if config == nil {
+				return nil
+			}
+			return []byte{byte(len(config)), config[len(config)-1]}
+		}
+		server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{
+			Path: "/config",
+			OnGET: func(request *Message) (*Message, error) {
+				return NewAcknowledgementMessageBuilder().
+					Code(Content).
+					MessageId(request.MessageID).
+					Token(request.Token).
+					WithPayload(ContentTypeTextPlain, config).
+					Build(), nil
+			},
+			OnPUT: func(request *Message) (*Message, error) {
+				config = request.Payload.Content
+				return responseWithCode(request, Changed), nil
+			},
+			OnDELETE: func(request *Message) (*Message, error) {
+				config = nil
+				return responseWithCode(request, Deleted), nil
+			},
+			ETag: etag,
+		})
+		request := func(code *CodeType) messageTokenBuilder {
+			return newProxyRequest(code).Option(UriPath, OptionValueType("config"))
+		}
+		put := func(builder messageTokenBuilder) *Message {
+			return server.routeRequest(builder.WithPayload(ContentTypeTextPlain, []byte("v2")).Build())
+		}
+
+		c.Convey("When the resource is requested", func() {
+			resp := server.routeRequest(request(GET).Build())
+
+			c.Convey("Then the response carries the current ETag", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So([]byte(resp.Options.Get(ETag)[0]), c.ShouldResemble, etag())
+			})
+		})
+
+		c.Convey("When the resource is requested with the current ETag", func() {
+			resp := server.routeRequest(request(GET).Option(ETag, etag()).Build())
+
+			c.Convey("Then 'Valid' is returned without payload", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Valid)
+				c.So(resp.Payload, c.ShouldBeNil)
+			})
+		})
+
+		c.Convey("When the resource is updated with matching If-Match", func() {
+			resp := put(request(PUT).Option(IfMatch, etag()))
+
+			c.Convey("Then it is changed", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Changed)
+				c.So(config, c.ShouldResemble, []byte("v2"))
+			})
+		})
+
+		c.Convey("When the resource is updated with an outdated If-Match", func() {
+			resp := put(request(PUT).Option(IfMatch, OptionValueType{0x02, '0'}))
+
+			c.Convey("Then 'Precondition Failed' is returned", func() {
+				c.So(*resp.Code, c.ShouldResemble, *PreconditionFailed)
+				c.So(config, c.ShouldResemble, []byte("v1"))
+			})
+		})
+
+		c.Convey("When the resource is updated non-confirmably with an outdated If-Match", func() {
+			resp := server.routeRequest(NewNonConfirmableMessageBuilder().
+				Code(PUT).
+				MessageId(7).
+				Token(&TokenType{1}).
+				Option(UriPath, OptionValueType("config")).
+				Option(IfMatch, OptionValueType{0x02, '0'}).
+				Build())
+
+			c.Convey("Then 'Precondition Failed' is returned in a non-confirmable response", func() {
+				c.So(*resp.Code, c.ShouldResemble, *PreconditionFailed)
+				c.So(resp.Type, c.ShouldEqual, NonConfirmable)
+				c.So(*resp.Token, c.ShouldResemble, TokenType{1})
+			})
+		})
+
+		c.Convey("When the resource is validated non-confirmably", func() {
+			resp := server.routeRequest(NewNonConfirmableMessageBuilder().
+				Code(GET).
+				MessageId(7).
+				Token(&TokenType{1}).
+				Option(UriPath, OptionValueType("config")).
+				Option(ETag, etag()).
+				Build())
+
+			c.Convey("Then 'Valid' is returned in a non-confirmable response", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Valid)
+				c.So(resp.Type, c.ShouldEqual, NonConfirmable)
+			})
+		})
+
+		c.Convey("When the existing resource is created using If-None-Match", func() {
+			resp := put(request(PUT).Option(IfNoneMatch, OptionValueType{}))
+
+			c.Convey("Then 'Precondition Failed' is returned", func() {
+				c.So(*resp.Code, c.ShouldResemble, *PreconditionFailed)
+			})
+		})
+
+		c.Convey("When the deleted resource is deleted again requiring existence", func() {
+			server.routeRequest(request(DELETE).Build())
+			resp := server.routeRequest(request(DELETE).Option(IfMatch, OptionValueType{}).Build())
+
+			c.Convey("Then 'Precondition Failed' is returned", func() {
+				c.So(*resp.Code, c.ShouldResemble, *PreconditionFailed)
+			})
+		})
+	})
+}
@@ -1,42 +1,253 @@
 package coap
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+	"sync"
+)
 
 type ContentType uint16
 
 const (
-	ContentTypeTextPlain              ContentType = iota
-	ContentTypeApplicationLinkFormat              = 40
-	ContentTypeApplicationXml                     = 41
-	ContentTypeApplicationOctetStream             = 42
-	ContentTypeApplicationExi                     = 47
-	ContentTypeApplicationJson                    = 50
+	ContentTypeTextPlain                 ContentType = iota
+	ContentTypeImageGif                              = 21
+	ContentTypeImageJpeg                             = 22
+	ContentTypeImagePng                              = 23
+	ContentTypeApplicationLinkFormat                 = 40
+	ContentTypeApplicationXml                        = 41
+	ContentTypeApplicationOctetStream                = 42
+	ContentTypeApplicationExi                        = 47
+	ContentTypeApplicationJson                       = 50
+	ContentTypeApplicationJsonPatchJson              = 51
+	ContentTypeApplicationMergePatchJson             = 52
+	ContentTypeApplicationCbor                       = 60
+	ContentTypeApplicationCwt                        = 61
+	ContentTypeApplicationMultipartCore              = 62
+	ContentTypeApplicationCborSeq                    = 63
+	ContentTypeApplicationCoseKey                    = 101
+	ContentTypeApplicationCoseKeySet                 = 102
+	ContentTypeApplicationSenmlJson                  = 110
+	ContentTypeApplicationSensmlJson                 = 111
+	ContentTypeApplicationSenmlCbor                  = 112
+	ContentTypeApplicationSensmlCbor                 = 113
+	ContentTypeApplicationSenmlExi                   = 114
+	ContentTypeApplicationSensmlExi                  = 115
+	ContentTypeApplicationSenmlEtchJson              = 320
+	ContentTypeApplicationSenmlEtchCbor              = 322
+	ContentTypeApplicationTdJson                     = 432
+	ContentTypeApplicationOscore                     = 10001
+	ContentTypeApplicationJsonDeflate                = 11050
+	ContentTypeApplicationCborDeflate                = 11060
+	ContentTypeApplicationLwm2mTlv                   = 11542
+	ContentTypeApplicationLwm2mJson                  = 11543
+	ContentTypeApplicationLwm2mCbor                  = 11544
+)
+
+// Range of content types reserved for experimental use (RFC 7252, section 12.3),
+// which applications may register with RegisterContentType.
+const (
+	MinPrivateContentType ContentType = 65000
+	MaxPrivateContentType ContentType = 65535
+)
+
+var (
+	ContentTypeIsNotPrivate      = errors.New("content type is outside the range for experimental use")
+	ContentTypeAlreadyRegistered = errors.New("content type is already registered")
+	InvalidMediaType             = errors.New("media type is invalid")
 )
 
-var AllContentTypes = []ContentType{
-	ContentTypeTextPlain,
-	ContentTypeApplicationLinkFormat,
-	ContentTypeApplicationXml,
-	ContentTypeApplicationOctetStream,
-	ContentTypeApplicationExi,
-	ContentTypeApplicationJson,
+// Media type and content coding of a content type.
+type contentTypeDefinition struct {
+	mediaType     string
+	contentCoding string
+}
+
+// CoAP Content-Formats registry, as registered with IANA.
+var contentTypeRegistry = map[ContentType]contentTypeDefinition{
+	ContentTypeTextPlain:                 {mediaType: "text/plain;charset=utf-8"},
+	16:                                   {mediaType: `application/cose; cose-type="cose-encrypt0"`},
+	17:                                   {mediaType: `application/cose; cose-type="cose-mac0"`},
+	18:                                   {mediaType: `application/cose; cose-type="cose-sign1"`},
+	19:                                   {mediaType: "application/ace+cbor"},
+	ContentTypeImageGif:                  {mediaType: "image/gif"},
+	ContentTypeImageJpeg:                 {mediaType: "image/jpeg"},
+	ContentTypeImagePng:                  {mediaType: "image/png"},
+	ContentTypeApplicationLinkFormat:     {mediaType: "application/link-format"},
+	ContentTypeApplicationXml:            {mediaType: "application/xml"},
+	ContentTypeApplicationOctetStream:    {mediaType: "application/octet-stream"},
+	ContentTypeApplicationExi:            {mediaType: "application/exi"},
+	ContentTypeApplicationJson:           {mediaType: "application/json"},
+	ContentTypeApplicationJsonPatchJson:  {mediaType: "application/json-patch+json"},
+	ContentTypeApplicationMergePatchJson: {mediaType: "application/merge-patch+json"},
+	ContentTypeApplicationCbor:           {mediaType: "application/cbor"},
+	ContentTypeApplicationCwt:            {mediaType: "application/cwt"},
+	ContentTypeApplicationMultipartCore:  {mediaType: "application/multipart-core"},
+	ContentTypeApplicationCborSeq:        {mediaType: "application/cbor-seq"},
+	96:                                   {mediaType: `application/cose; cose-type="cose-encrypt"`},
+	97:                                   {mediaType: `application/cose; cose-type="cose-mac"`},
+	98:                                   {mediaType: `application/cose; cose-type="cose-sign"`},
+	ContentTypeApplicationCoseKey:        {mediaType: "application/cose-key"},
+	ContentTypeApplicationCoseKeySet:     {mediaType: "application/cose-key-set"},
+	ContentTypeApplicationSenmlJson:      {mediaType: "application/senml+json"},
+	ContentTypeApplicationSensmlJson:     {mediaType: "application/sensml+json"},
+	ContentTypeApplicationSenmlCbor:      {mediaType: "application/senml+cbor"},
+	ContentTypeApplicationSensmlCbor:     {mediaType: "application/sensml+cbor"},
+	ContentTypeApplicationSenmlExi:       {mediaType: "application/senml-exi"},
+	ContentTypeApplicationSensmlExi:      {mediaType: "application/sensml-exi"},
+	140:                                  {mediaType: "application/yang-data+cbor; id=sid"},
+	256:                                  {mediaType: "application/coap-group+json"},
+	257:                                  {mediaType: "application/concise-problem-details+cbor"},
+	258:                                  {mediaType: "application/swid+cbor"},
+	259:                                  {mediaType: "application/pkixcmp"},
+	271:                                  {mediaType: "application/dots+cbor"},
+	272:                                  {mediaType: "application/missing-blocks+cbor-seq"},
+	280:                                  {mediaType: "application/pkcs7-mime; smime-type=server-generated-key"},
+	281:                                  {mediaType: "application/pkcs7-mime; smime-type=certs-only"},
+	284:                                  {mediaType: "application/pkcs8"},
+	285:                                  {mediaType: "application/csrattrs"},
+	286:                                  {mediaType: "application/pkcs10"},
+	287:                                  {mediaType: "application/pkix-cert"},
+	290:                                  {mediaType: "application/aif+cbor"},
+	291:                                  {mediaType: "application/aif+json"},
+	310:                                  {mediaType: "application/senml+xml"},
+	311:                                  {mediaType: "application/sensml+xml"},
+	ContentTypeApplicationSenmlEtchJson:  {mediaType: "application/senml-etch+json"},
+	ContentTypeApplicationSenmlEtchCbor:  {mediaType: "application/senml-etch+cbor"},
+	340:                                  {mediaType: "application/yang-data+cbor"},
+	341:                                  {mediaType: "application/yang-data+cbor; id=name"},
+	ContentTypeApplicationTdJson:         {mediaType: "application/td+json"},
+	433:                                  {mediaType: "application/tm+json"},
+	10000:                                {mediaType: "application/vnd.ocf+cbor"},
+	ContentTypeApplicationOscore:         {mediaType: "application/oscore"},
+	10002:                                {mediaType: "application/javascript"},
+	ContentTypeApplicationJsonDeflate:    {mediaType: "application/json", contentCoding: "deflate"},
+	ContentTypeApplicationCborDeflate:    {mediaType: "application/cbor", contentCoding: "deflate"},
+	ContentTypeApplicationLwm2mTlv:       {mediaType: "application/vnd.oma.lwm2m+tlv"},
+	ContentTypeApplicationLwm2mJson:      {mediaType: "application/vnd.oma.lwm2m+json"},
+	ContentTypeApplicationLwm2mCbor:      {mediaType: "application/vnd.oma.lwm2m+cbor"},
+	20000:                                {mediaType: "text/css"},
+	30000:                                {mediaType: "image/svg+xml"},
+}
+
+// Guards registration of private content types.
+var contentTypeLock sync.RWMutex
+
+// AllContentTypes lists the content types registered with IANA, in ascending order.
+var AllContentTypes = sortedContentTypes()
+
+func sortedContentTypes() []ContentType {
+	all := make([]ContentType, 0, len(contentTypeRegistry))
+	for c := range contentTypeRegistry {
+		all = append(all, c)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	return all
+}
+
+// RegisterContentType registers a private content type for experimental use, with its media type
+// and content coding, which is empty if none applies.
+func RegisterContentType(c ContentType, mediaType string, contentCoding string) error {
+	if c < MinPrivateContentType {
+		return ContentTypeIsNotPrivate
+	}
+	if _, _, err := mime.ParseMediaType(mediaType); err != nil {
+		return InvalidMediaType
+	}
+	contentTypeLock.Lock()
+	defer contentTypeLock.Unlock()
+	if _, ok := contentTypeRegistry[c]; ok {
+		return ContentTypeAlreadyRegistered
+	}
+	contentTypeRegistry[c] = contentTypeDefinition{mediaType: mediaType, contentCoding: strings.ToLower(contentCoding)}
+	return nil
+}
+
+func lookupContentType(c ContentType) (contentTypeDefinition, bool) {
+	contentTypeLock.RLock()
+	defer contentTypeLock.RUnlock()
+	definition, ok := contentTypeRegistry[c]
+	return definition, ok
 }
 
 func (c ContentType) String() string {
-	switch c {
-	case ContentTypeTextPlain:
-		return fmt.Sprintf("%d (%s)", c, "text/plain;charset=utf-8")
-	case ContentTypeApplicationLinkFormat:
-		return fmt.Sprintf("%d (%s)", c, "application/link-format")
-	case ContentTypeApplicationXml:
-		return fmt.Sprintf("%d (%s)", c, "application/xml")
-	case ContentTypeApplicationOctetStream:
-		return fmt.Sprintf("%d (%s)", c, "application/octet-stream")
-	case ContentTypeApplicationExi:
-		return fmt.Sprintf("%d (%s)", c, "application/exi")
-	case ContentTypeApplicationJson:
-		return fmt.Sprintf("%d (%s)", c, "application/json")
-	default:
+	definition, ok := lookupContentType(c)
+	if !ok {
 		return fmt.Sprintf("%d (%s)", c, "unknown")
 	}
+	if definition.contentCoding != "" {
+		return fmt.Sprintf("%d (%s; %s)", c, definition.mediaType, definition.contentCoding)
+	}
+	return fmt.Sprintf("%d (%s)", c, definition.mediaType)
+}
+
+// MediaType returns the media type of the content type including parameters, e.g. "application/json".
+func (c ContentType) MediaType() (string, bool) {
+	definition, ok := lookupContentType(c)
+	return definition.mediaType, ok
+}
+
+// ContentCoding returns the content coding of the content type, e.g. "deflate", or an empty string if none applies.
+func (c ContentType) ContentCoding() string {
+	definition, _ := lookupContentType(c)
+	return definition.contentCoding
+}
+
+// ContentTypeOfMediaType looks up the content type of a media type without content coding,
+// as given e.g. in HTTP headers.
+func ContentTypeOfMediaType(mediaType string) (ContentType, bool) {
+	return ContentTypeOf(mediaType, "")
+}
+
+// ContentTypeOf looks up the content type of a media type and content coding. Parameters of the media type
+// must match the registered ones, except charset: a missing charset is taken as the registered one,
+// and charset is ignored for media types registered without.
+func ContentTypeOf(mediaType string, contentCoding string) (ContentType, bool) {
+	name, params, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return 0, false
+	}
+	contentCoding = strings.ToLower(strings.TrimSpace(contentCoding))
+	if contentCoding == "identity" {
+		contentCoding = ""
+	}
+
+	contentTypeLock.RLock()
+	defer contentTypeLock.RUnlock()
+	found, ok := ContentType(0), false
+	for c, definition := range contentTypeRegistry {
+		if definition.contentCoding != contentCoding || !matchesMediaType(definition.mediaType, name, params) {
+			continue
+		}
+		// the lowest one wins, if private content types are registered for the same media type
+		if !ok || c < found {
+			found, ok = c, true
+		}
+	}
+	return found, ok
+}
+
+func matchesMediaType(registered string, name string, params map[string]string) bool {
+	registeredName, registeredParams, _ := mime.ParseMediaType(registered)
+	if name != registeredName {
+		return false
+	}
+	for k, v := range registeredParams {
+		given, ok := params[k]
+		if k == "charset" {
+			if ok && !strings.EqualFold(given, v) {
+				return false
+			}
+		} else if !ok || given != v {
+			return false
+		}
+	}
+	for k := range params {
+		if _, ok := registeredParams[k]; !ok && k != "charset" {
+			return false
+		}
+	}
+	return true
 }
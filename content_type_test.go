@@ -13,3 +13,55 @@ func TestContentType_String(t *testing.T) {
 		t.Error("unknown content type may not be empty")
 	}
 }
+
+func TestContentTypeOf(t *testing.T) {
+	known := map[string]ContentType{
+		"application/json; charset=utf-8":               ContentTypeApplicationJson,
+		"Text/Plain":                                    ContentTypeTextPlain,
+		"application/vnd.oma.lwm2m+tlv":                 ContentTypeApplicationLwm2mTlv,
+		`application/cose; cose-type="cose-sign1"`:      18,
+		"application/yang-data+cbor":                    340,
+		"application/yang-data+cbor; id=name":           341,
+		"application/senml-etch+cbor":                   ContentTypeApplicationSenmlEtchCbor,
+		"application/pkcs7-mime; smime-type=certs-only": 281,
+	}
+	for mediaType, expected := range known {
+		if c, ok := ContentTypeOfMediaType(mediaType); !ok || c != expected {
+			t.Errorf("expected %v for %s, got %v", expected, mediaType, c)
+		}
+	}
+	for _, mediaType := range []string{"text/plain; charset=iso-8859-1", "application/cose", "application/json; foo=bar", "invalid"} {
+		if c, ok := ContentTypeOfMediaType(mediaType); ok {
+			t.Errorf("expected no content type for %s, got %v", mediaType, c)
+		}
+	}
+	if c, ok := ContentTypeOf("application/json", "deflate"); !ok || c != ContentTypeApplicationJsonDeflate {
+		t.Errorf("expected json with deflate coding, got %v", c)
+	}
+	if c := ContentType(ContentTypeApplicationCborDeflate); c.ContentCoding() != "deflate" || c.String() != "11060 (application/cbor; deflate)" {
+		t.Errorf("unexpected content coding of %v", c)
+	}
+}
+
+func TestRegisterContentType(t *testing.T) {
+	if err := RegisterContentType(ContentTypeApplicationJson, "application/foo", ""); err != ContentTypeIsNotPrivate {
+		t.Errorf("expected registered content type to be rejected, got %v", err)
+	}
+	if err := RegisterContentType(65100, "application/vnd.example+cbor", ""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		contentTypeLock.Lock()
+		defer contentTypeLock.Unlock()
+		delete(contentTypeRegistry, 65100)
+	})
+	if err := RegisterContentType(65100, "application/vnd.other", ""); err != ContentTypeAlreadyRegistered {
+		t.Errorf("expected duplicate registration to be rejected, got %v", err)
+	}
+	if c, ok := ContentTypeOfMediaType("application/vnd.example+cbor"); !ok || c != 65100 {
+		t.Errorf("expected private content type, got %v", c)
+	}
+	if mediaType, _ := ContentType(65100).MediaType(); mediaType != "application/vnd.example+cbor" {
+		t.Errorf("unexpected media type %s", mediaType)
+	}
+}
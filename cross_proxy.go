@@ -0,0 +1,287 @@
+package coap
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DefaultCrossProxyPrefix is the path prefix of the default HTTP-CoAP URI mapping (RFC 8075, section 5.3).
+const DefaultCrossProxyPrefix = "/hc/"
+
+// CrossProxy is an http.Handler, which translates HTTP requests into CoAP requests (RFC 8075).
+// The target URI follows the prefix, e.g. "GET /hc/coap://device/sensors/temp".
+// Mounted on a http.ServeMux, clients are redirected to "/hc/coap:/device/sensors/temp", which is accepted as well.
+type CrossProxy struct {
+	prefix     string
+	parameters TransmissionParameters
+}
+
+// Mapping of CoAP response codes to HTTP status codes (RFC 8075, section 7).
+// Codes depending on the response, like 2.03 Valid or 2.04 Changed, are mapped in httpStatus.
+var httpStatusCodes = map[CodeType]int{
+	*Created: http.StatusCreated,
+	*Content: http.StatusOK,
+
+	*BadRequest:   http.StatusBadRequest,
+	*Unauthorized: http.StatusForbidden,
+	*BadOption:    http.StatusBadRequest,
+	*Forbidden:    http.StatusForbidden,
+	*NotFound:     http.StatusNotFound,
+	// Spec: 405 requires an Allow header, which cannot be derived from the CoAP response.
+	*MethodNotAllowed:         http.StatusBadRequest,
+	*NotAcceptable:            http.StatusNotAcceptable,
+	*PreconditionFailed:       http.StatusPreconditionFailed,
+	*RequestEntityTooLarge:    http.StatusRequestEntityTooLarge,
+	*UnsupportedContentFormat: http.StatusUnsupportedMediaType,
+
+	*InternalServerError:  http.StatusInternalServerError,
+	*NotImplemented:       http.StatusNotImplemented,
+	*BadGateway:           http.StatusBadGateway,
+	*ServiceUnavailable:   http.StatusServiceUnavailable,
+	*GatewayTimeout:       http.StatusGatewayTimeout,
+	*ProxyingNotSupported: http.StatusBadGateway,
+}
+
+var httpMethods = map[string]*CodeType{
+	http.MethodGet:    GET,
+	http.MethodPost:   POST,
+	http.MethodPut:    PUT,
+	http.MethodDelete: DELETE,
+}
+
+// NewCrossProxy creates a cross-proxy serving target URIs after given prefix, using default transmission parameters.
+func NewCrossProxy(prefix string) *CrossProxy {
+	return NewCrossProxyWithParameters(prefix, DefaultTransmissionParameters())
+}
+
+// NewCrossProxyWithParameters creates a cross-proxy serving target URIs after given prefix,
+// using given transmission parameters towards CoAP servers.
+func NewCrossProxyWithParameters(prefix string, parameters TransmissionParameters) *CrossProxy {
+	return &CrossProxy{prefix: prefix, parameters: parameters}
+}
+
+// Get string representation of the cross-proxy
+func (p *CrossProxy) String() string {
+	return fmt.Sprintf("CrossProxy{ prefix=%v, parameters=%v }", p.prefix, p.parameters)
+}
+
+func (p *CrossProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target, err := p.targetURI(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	code, ok := httpMethods[r.Method]
+	if !ok {
+		http.Error(w, "method not supported", http.StatusNotImplemented)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxPacketSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > MaxPacketSize {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	req, status := newCoapRequest(code, target, r.Header, body)
+	if req == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	client, err := NewClientWithParameters(net.JoinHostPort(target.Hostname(), portOf(target)), p.parameters)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer client.Close()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, RequestTimedOut) {
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+	p.writeResponse(w, target, req, resp)
+}
+
+// Extracts the CoAP target URI following the prefix.
+// The escaped path is used, so that escaped slashes within path segments are kept.
+func (p *CrossProxy) targetURI(r *http.Request) (*url.URL, error) {
+	path := r.URL.EscapedPath()
+	if !strings.HasPrefix(path, p.prefix) {
+		return nil, errors.New("target URI missing")
+	}
+	uri := strings.TrimPrefix(path, p.prefix)
+	// a http.ServeMux redirects to the cleaned path, which has "coap:/" instead of "coap://"
+	if strings.HasPrefix(uri, "coap:/") && !strings.HasPrefix(uri, "coap://") {
+		uri = "coap://" + strings.TrimPrefix(uri, "coap:/")
+	}
+	target, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme != "coap" || target.Hostname() == "" {
+		return nil, errors.New("target URI must be an absolute coap URI")
+	}
+	target.RawQuery = r.URL.RawQuery
+	return target, nil
+}
+
+// Creates the CoAP request for the HTTP request. If the request can't be mapped,
+// nil and the HTTP status code to respond with is returned.
+func newCoapRequest(code *CodeType, target *url.URL, header http.Header, body []byte) (*Message, int) {
+	builder := NewConfirmableMessageBuilder().Code(code).WithRandomMessageId().WithRandomToken()
+
+	if net.ParseIP(target.Hostname()) == nil {
+		builder = builder.Option(UriHost, OptionValueType(target.Hostname()))
+	}
+	for _, segment := range strings.Split(strings.Trim(target.EscapedPath(), "/"), "/") {
+		value, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, http.StatusBadRequest
+		}
+		if value != "" {
+			builder = builder.Option(UriPath, OptionValueType(value))
+		}
+	}
+	if target.RawQuery != "" {
+		for _, arg := range strings.Split(target.RawQuery, "&") {
+			// unlike in HTML forms, '+' stands for itself, so arguments are unescaped like path segments
+			q, err := url.PathUnescape(arg)
+			if err != nil {
+				return nil, http.StatusBadRequest
+			}
+			builder = builder.Option(UriQuery, OptionValueType(q))
+		}
+	}
+
+	if accept := header.Get("Accept"); accept != "" && !strings.Contains(accept, ",") && accept != "*/*" {
+		cType, ok := ContentTypeOfMediaType(accept)
+		if !ok {
+			return nil, http.StatusNotAcceptable
+		}
+		builder = builder.Option(Accept, uintOptionValue(uint64(cType)))
+	}
+	for _, etag := range parseEntityTags(header.Get("If-Match")) {
+		builder = builder.Option(IfMatch, etag)
+	}
+	// Spec: a GET with If-None-Match is translated into a validation request.
+	if code == GET {
+		for _, etag := range parseEntityTags(header.Get("If-None-Match")) {
+			builder = builder.Option(ETag, etag)
+		}
+	}
+
+	if len(body) == 0 {
+		return builder.Build(), 0
+	}
+	cType := ContentType(ContentTypeApplicationOctetStream)
+	if mediaType := header.Get("Content-Type"); mediaType != "" {
+		var ok bool
+		if cType, ok = ContentTypeOfMediaType(mediaType); !ok {
+			return nil, http.StatusUnsupportedMediaType
+		}
+	}
+	return builder.WithPayload(cType, body).Build(), 0
+}
+
+func (p *CrossProxy) writeResponse(w http.ResponseWriter, target *url.URL, req *Message, resp *Message) {
+	header := w.Header()
+	if format, ok := resp.GetUint(ContentFormat); ok {
+		cType := ContentType(format)
+		if mediaType, ok := cType.MediaType(); ok {
+			header.Set("Content-Type", mediaType)
+			if coding := cType.ContentCoding(); coding != "" {
+				header.Set("Content-Encoding", coding)
+			}
+		} else {
+			header.Set("Content-Type", "application/octet-stream")
+		}
+	}
+	for _, etag := range resp.Options.Get(ETag) {
+		header.Add("ETag", fmt.Sprintf("\"%s\"", hex.EncodeToString(etag)))
+	}
+	// Spec: Max-Age defaults to 60 seconds, if not present.
+	maxAge, ok := resp.GetUint(MaxAge)
+	if !ok {
+		maxAge = defaultMaxAge()
+	}
+	header.Set("Cache-Control", fmt.Sprintf("max-age=%d", maxAge))
+	if location := resp.Options.Get(LocationPath); len(location) > 0 {
+		header.Set("Location", p.prefix+(&url.URL{Scheme: target.Scheme, Host: target.Host, Path: UriPathOptionToString(location)}).String())
+	}
+
+	w.WriteHeader(httpStatus(req, resp))
+	if resp.Payload != nil {
+		w.Write(resp.Payload.Content)
+	}
+}
+
+// Maps the code of the CoAP response to HTTP status code (RFC 8075, section 7).
+func httpStatus(req *Message, resp *Message) int {
+	hasPayload := resp.Payload != nil && len(resp.Payload.Content) > 0
+
+	switch *resp.Code {
+	case *Valid:
+		if req.HasOption(ETag) {
+			return http.StatusNotModified
+		}
+		return http.StatusOK
+	case *Deleted, *Changed:
+		if hasPayload {
+			return http.StatusOK
+		}
+		return http.StatusNoContent
+	}
+
+	if status, ok := httpStatusCodes[*resp.Code]; ok {
+		return status
+	}
+	switch resp.Code.CodeClass {
+	case 2:
+		return http.StatusOK
+	case 4:
+		return http.StatusBadRequest
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// Parses the entity tags of If-Match or If-None-Match headers, as created by the proxy
+// from CoAP ETags. Weak or foreign entity tags are ignored.
+func parseEntityTags(header string) []OptionValueType {
+	var etags []OptionValueType
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		if etag, err := hex.DecodeString(tag[1 : len(tag)-1]); err == nil && len(etag) > 0 {
+			etags = append(etags, etag)
+		}
+	}
+	return etags
+}
+
+func portOf(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	return strconv.Itoa(int(InsecurePort))
+}
@@ -0,0 +1,213 @@
+package coap
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCrossProxy(t *testing.T) {
+	c.Convey("Given a cross-proxy in front of a coap server on loopback", t, func() {
+		created := make(chan []byte, 1)
+
+		server, _ := NewInsecureCoapServerWithDefaultParameters(
+			&Resource{
+				Path: "/sensors/temp",
+				OnGET: func(request *Message) (*Message, error) {
+					if etags := request.Options.Get(ETag); len(etags) > 0 && bytes.Equal(etags[0], []byte{0xCA, 0xFE}) {
+						return NewAcknowledgementMessageBuilder().
+							Code(Valid).
+							MessageId(request.MessageID).
+							Token(request.Token).
+							Option(ETag, OptionValueType{0xCA, 0xFE}).
+							Build(), nil
+					}
+					return NewAcknowledgementMessageBuilder().
+						Code(Content).
+						MessageId(request.MessageID).
+						Token(request.Token).
+						Option(ETag, OptionValueType{0xCA, 0xFE}).
+						Option(MaxAge, OptionValueType{30}).
+						WithPayload(ContentTypeTextPlain, []byte("21.5")).
+						Build(), nil
+				},
+			},
+			&Resource{
+				Path: "/sensors",
+				OnPOST: func(request *Message) (*Message, error) {
+					created <- request.Payload.Content
+					return NewAcknowledgementMessageBuilder().
+						Code(Created).
+						MessageId(request.MessageID).
+						Token(request.Token).
+						Option(LocationPath, NewLocationPathOption("/sensors/humidity")...).
+						Build(), nil
+				},
+			},
+		)
+		conn, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		go server.Serve(conn)
+		defer conn.Close()
+
+		proxy := httptest.NewServer(NewCrossProxy(DefaultCrossProxyPrefix))
+		defer proxy.Close()
+		target := proxy.URL + "/hc/coap://" + conn.LocalAddr().String()
+
+		c.Convey("When a resource is requested using GET", func() {
+			resp, err := http.Get(target + "/sensors/temp")
+			c.So(err, c.ShouldBeNil)
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			c.Convey("Then the coap response is translated", func() {
+				c.So(resp.StatusCode, c.ShouldEqual, http.StatusOK)
+				c.So(string(body), c.ShouldEqual, "21.5")
+				c.So(resp.Header.Get("Content-Type"), c.ShouldEqual, "text/plain;charset=utf-8")
+				c.So(resp.Header.Get("ETag"), c.ShouldEqual, "\"cafe\"")
+				c.So(resp.Header.Get("Cache-Control"), c.ShouldEqual, "max-age=30")
+			})
+		})
+
+		c.Convey("When a resource is validated using If-None-Match", func() {
+			req, _ := http.NewRequest(http.MethodGet, target+"/sensors/temp", nil)
+			req.Header.Set("If-None-Match", "\"cafe\"")
+			resp, err := http.DefaultClient.Do(req)
+			c.So(err, c.ShouldBeNil)
+			resp.Body.Close()
+
+			c.Convey("Then 'Not Modified' is returned", func() {
+				c.So(resp.StatusCode, c.ShouldEqual, http.StatusNotModified)
+			})
+		})
+
+		c.Convey("When a resource is created using POST", func() {
+			resp, err := http.Post(target+"/sensors", "application/json; charset=utf-8", strings.NewReader("{}"))
+			c.So(err, c.ShouldBeNil)
+			resp.Body.Close()
+
+			c.Convey("Then 'Created' with location is returned", func() {
+				c.So(resp.StatusCode, c.ShouldEqual, http.StatusCreated)
+				c.So(resp.Header.Get("Location"), c.ShouldEqual, "/hc/coap://"+conn.LocalAddr().String()+"/sensors/humidity")
+				c.So(<-created, c.ShouldResemble, []byte("{}"))
+			})
+		})
+
+		c.Convey("When a resource is requested through a ServeMux", func() {
+			mux := http.NewServeMux()
+			mux.Handle(DefaultCrossProxyPrefix, NewCrossProxy(DefaultCrossProxyPrefix))
+			muxProxy := httptest.NewServer(mux)
+			defer muxProxy.Close()
+
+			resp, err := http.Get(muxProxy.URL + "/hc/coap://" + conn.LocalAddr().String() + "/sensors/temp")
+			c.So(err, c.ShouldBeNil)
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			c.Convey("Then the cleaned target URI is proxied after the redirect", func() {
+				c.So(resp.StatusCode, c.ShouldEqual, http.StatusOK)
+				c.So(resp.Request.URL.Path, c.ShouldEqual, "/hc/coap:/"+conn.LocalAddr().String()+"/sensors/temp")
+				c.So(string(body), c.ShouldEqual, "21.5")
+			})
+		})
+
+		c.Convey("When a body of unsupported media type is sent", func() {
+			resp, err := http.Post(target+"/sensors", "image/bmp", strings.NewReader("bmp"))
+			c.So(err, c.ShouldBeNil)
+			resp.Body.Close()
+
+			c.Convey("Then 'Unsupported Media Type' is returned", func() {
+				c.So(resp.StatusCode, c.ShouldEqual, http.StatusUnsupportedMediaType)
+			})
+		})
+
+		c.Convey("When an unknown resource is requested", func() {
+			resp, err := http.Get(target + "/unknown")
+			c.So(err, c.ShouldBeNil)
+			resp.Body.Close()
+
+			c.Convey("Then 'Not Found' is returned", func() {
+				c.So(resp.StatusCode, c.ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		c.Convey("When an unsupported method is used", func() {
+			req, _ := http.NewRequest(http.MethodPatch, target+"/sensors/temp", nil)
+			resp, err := http.DefaultClient.Do(req)
+			c.So(err, c.ShouldBeNil)
+			resp.Body.Close()
+
+			c.Convey("Then 'Not Implemented' is returned", func() {
+				c.So(resp.StatusCode, c.ShouldEqual, http.StatusNotImplemented)
+			})
+		})
+
+		c.Convey("When the target is no coap URI", func() {
+			resp, err := http.Get(proxy.URL + "/hc/http://example.com/")
+			c.So(err, c.ShouldBeNil)
+			resp.Body.Close()
+
+			c.Convey("Then 'Bad Request' is returned", func() {
+				c.So(resp.StatusCode, c.ShouldEqual, http.StatusBadRequest)
+			})
+		})
+	})
+}
+
+func TestCrossProxyTimeout(t *testing.T) {
+	c.Convey("Given a cross-proxy in front of a coap server which never responds", t, func() {
+		conn, _ := startTestPeer(func(msg *Message) []*Message {
+			return nil
+		})
+		defer conn.Close()
+
+		proxy := httptest.NewServer(NewCrossProxyWithParameters(DefaultCrossProxyPrefix, testTransmissionParameters()))
+		defer proxy.Close()
+
+		c.Convey("When a resource is requested", func() {
+			resp, err := http.Get(proxy.URL + "/hc/coap://" + conn.LocalAddr().String() + "/sensors/temp")
+			c.So(err, c.ShouldBeNil)
+			resp.Body.Close()
+
+			c.Convey("Then 'Gateway Timeout' is returned", func() {
+				c.So(resp.StatusCode, c.ShouldEqual, http.StatusGatewayTimeout)
+			})
+		})
+	})
+}
+
+func TestNewCoapRequest(t *testing.T) {
+	c.Convey("Given a target URI with query", t, func() {
+		target, _ := url.Parse("coap://device/sensors/temp?tz=+01&unit=%C2%B0C")
+
+		c.Convey("When a coap request is created for it", func() {
+			req, _ := newCoapRequest(GET, target, http.Header{}, nil)
+
+			c.Convey("Then '+' is kept in Uri-Query and escapes are decoded", func() {
+				c.So(req.Options.Get(UriQuery), c.ShouldResemble, []OptionValueType{
+					OptionValueType("tz=+01"),
+					OptionValueType("unit=°C"),
+				})
+			})
+		})
+	})
+
+	c.Convey("Given a target URI with an invalid escape in its query", t, func() {
+		target, _ := url.Parse("coap://device/sensors/temp?unit=%zz")
+
+		c.Convey("When a coap request is created for it", func() {
+			req, status := newCoapRequest(GET, target, http.Header{}, nil)
+
+			c.Convey("Then 'Bad Request' is returned instead of dropping the argument", func() {
+				c.So(req, c.ShouldBeNil)
+				c.So(status, c.ShouldEqual, http.StatusBadRequest)
+			})
+		})
+	})
+}
@@ -0,0 +1,117 @@
+package coap
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+const (
+	// EchoFreshnessWindow is the time, for which an Echo value issued by the server is accepted.
+	EchoFreshnessWindow = 10 * time.Second
+
+	// PeerVerificationLifetime is the time, for which a peer address stays verified after
+	// the peer returned a valid Echo value.
+	PeerVerificationLifetime = 10 * time.Minute
+
+	// DefaultAmplificationFactor is the factor recommended by RFC 9175, section 2.4.
+	DefaultAmplificationFactor = 3
+)
+
+const (
+	echoTimestampLength = 8
+	echoMacLength       = 8
+)
+
+// LimitAmplification restricts responses to peers, whose address was not verified using the
+// Echo option, to the given multiple of the request size (RFC 9175, section 2.4).
+// Larger responses are replaced by 4.01 Unauthorized carrying an Echo value.
+// Servers limit responses by DefaultAmplificationFactor, unless changed.
+func (s *Server) LimitAmplification(factor int) {
+	s.echoLock.Lock()
+	defer s.echoLock.Unlock()
+	s.amplificationFactor = factor
+}
+
+// DisableAmplificationLimit lets the server send responses of any size to unverified peers,
+// e.g. if the network prevents address spoofing already.
+func (s *Server) DisableAmplificationLimit() {
+	s.LimitAmplification(0)
+}
+
+// Creates an Echo value, which is bound to the peer and the time of creation:
+// 8 bytes timestamp followed by 8 bytes of a MAC over timestamp and peer address.
+func (s *Server) newEchoValue(peer *net.UDPAddr, now time.Time) OptionValueType {
+	value := make([]byte, echoTimestampLength, echoTimestampLength+echoMacLength)
+	binary.BigEndian.PutUint64(value, uint64(now.UnixNano()))
+	return append(value, s.echoMac(value, peer)...)
+}
+
+func (s *Server) echoMac(timestamp []byte, peer *net.UDPAddr) []byte {
+	mac := hmac.New(sha256.New, s.echoKey)
+	mac.Write(timestamp)
+	if peer != nil {
+		mac.Write([]byte(peer.String()))
+	}
+	return mac.Sum(nil)[0:echoMacLength]
+}
+
+// Checks whether the message carries an Echo value issued by this server for the
+// source of the message within the freshness window.
+func (s *Server) hasFreshEcho(msg *Message, now time.Time) bool {
+	values := msg.Options.Get(Echo)
+	if len(values) != 1 || len(values[0]) != echoTimestampLength+echoMacLength {
+		return false
+	}
+	value := values[0]
+	if !hmac.Equal(value[echoTimestampLength:], s.echoMac(value[0:echoTimestampLength], msg.Source)) {
+		return false
+	}
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(value[0:echoTimestampLength])))
+	return !issued.After(now) && now.Sub(issued) <= EchoFreshnessWindow
+}
+
+// Answers the request with 4.01 Unauthorized and a new Echo value.
+func (s *Server) echoChallenge(msg *Message) *Message {
+	resp := NewUnauthorizedResponseMessage(msg)
+	resp.Options.Set(Echo, s.newEchoValue(msg.Source, time.Now()))
+	return resp
+}
+
+// Marks the source of the message as verified, if it returned a valid Echo value.
+func (s *Server) verifyPeer(msg *Message, now time.Time) {
+	if msg.Source == nil || !s.hasFreshEcho(msg, now) {
+		return
+	}
+	s.echoLock.Lock()
+	defer s.echoLock.Unlock()
+	for peer, verified := range s.verifiedPeers {
+		if now.Sub(verified) > PeerVerificationLifetime {
+			delete(s.verifiedPeers, peer)
+		}
+	}
+	s.verifiedPeers[msg.Source.String()] = now
+}
+
+// Checks whether a response of given size may be sent for the request without
+// verifying the address of the peer first.
+func (s *Server) mayAmplify(msg *Message, requestSize int, responseSize int, now time.Time) bool {
+	s.echoLock.Lock()
+	defer s.echoLock.Unlock()
+	if s.amplificationFactor == 0 || msg.Source == nil || responseSize <= s.amplificationFactor*requestSize {
+		return true
+	}
+	verified, ok := s.verifiedPeers[msg.Source.String()]
+	return ok && now.Sub(verified) <= PeerVerificationLifetime
+}
+
+func newEchoKey() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
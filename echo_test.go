@@ -0,0 +1,146 @@
+package coap
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+func TestServer_EchoValue(t *testing.T) {
+	c.Convey("Given a server and a request with an Echo value issued for its peer", t, func() {
+		server, _ := NewInsecureCoapServerWithDefaultParameters()
+		peer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4711}
+		now := time.Now()
+
+		req := newTestRequest(Confirmable)
+		req.Source = peer
+		req.Options.Set(Echo, server.newEchoValue(peer, now))
+
+		c.Convey("Then the value is fresh within the freshness window", func() {
+			c.So(server.hasFreshEcho(req, now.Add(EchoFreshnessWindow)), c.ShouldBeTrue)
+		})
+
+		c.Convey("And the value is stale after the freshness window", func() {
+			c.So(server.hasFreshEcho(req, now.Add(EchoFreshnessWindow+time.Millisecond)), c.ShouldBeFalse)
+		})
+
+		c.Convey("And the value is rejected from another peer", func() {
+			req.Source = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4712}
+			c.So(server.hasFreshEcho(req, now), c.ShouldBeFalse)
+		})
+
+		c.Convey("And a manipulated value is rejected", func() {
+			req.Options.Get(Echo)[0][0] ^= 0xFF
+			c.So(server.hasFreshEcho(req, now), c.ShouldBeFalse)
+		})
+	})
+}
+
+func TestServer_RequireFreshness(t *testing.T) {
+	c.Convey("Given a server with an actuator demanding freshness", t, func() {
+		server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{
+			Path: "/rd",
+			OnGET: func(request *Message) (*Message, error) {
+				return NewContentResponseMessage(request), nil
+			},
+			RequireFreshness: true,
+		})
+
+		c.Convey("When a request without Echo is routed", func() {
+			resp := server.routeRequest(newTestRequest(Confirmable))
+
+			c.Convey("Then it is answered with 4.01 and an Echo value", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Unauthorized)
+				c.So(resp.HasOption(Echo), c.ShouldBeTrue)
+			})
+
+			c.Convey("And the request repeated with the Echo value is served", func() {
+				req := newTestRequest(Confirmable)
+				req.Options.Set(Echo, resp.Options.Get(Echo)...)
+				c.So(*server.routeRequest(req).Code, c.ShouldResemble, *Content)
+			})
+		})
+	})
+}
+
+func TestServer_LimitAmplification(t *testing.T) {
+	c.Convey("Given a server with default amplification limit and a large resource", t, func() {
+		server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{
+			Path: "/rd",
+			OnGET: func(request *Message) (*Message, error) {
+				return NewAcknowledgementMessageBuilder().
+					Code(Content).
+					MessageId(request.MessageID).
+					Token(request.Token).
+					WithPayload(ContentTypeTextPlain, bytes.Repeat([]byte("x"), 500)).
+					Build(), nil
+			},
+		})
+		peer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4711}
+
+		c.Convey("When an unverified peer requests the resource", func() {
+			resp, _ := NewMessageFromBytes(server.handleMessage(newTestRequest(Confirmable).ToBytes(), peer, nil))
+
+			c.Convey("Then the response is replaced by 4.01 with an Echo value", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Unauthorized)
+				c.So(resp.HasOption(Echo), c.ShouldBeTrue)
+			})
+
+			c.Convey("And the peer repeating the request with the Echo value gets the full response", func() {
+				req := newTestRequest(Confirmable)
+				req.Options.Set(Echo, resp.Options.Get(Echo)...)
+				resp, _ := NewMessageFromBytes(server.handleMessage(req.ToBytes(), peer, nil))
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So(len(resp.Payload.Content), c.ShouldEqual, 500)
+			})
+		})
+
+		c.Convey("When the request is received over a secure session", func() {
+			resp, _ := NewMessageFromBytes(server.handleMessage(newTestRequest(Confirmable).ToBytes(), peer, &PeerIdentity{}))
+
+			c.Convey("Then the full response is sent", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+			})
+		})
+
+		c.Convey("When the amplification limit is disabled", func() {
+			server.DisableAmplificationLimit()
+			resp, _ := NewMessageFromBytes(server.handleMessage(newTestRequest(Confirmable).ToBytes(), peer, nil))
+
+			c.Convey("Then the full response is sent to an unverified peer", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So(len(resp.Payload.Content), c.ShouldEqual, 500)
+			})
+		})
+	})
+}
+
+func TestClient_DoRepeatsWithEcho(t *testing.T) {
+	c.Convey("Given a server demanding freshness on loopback", t, func() {
+		server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{
+			Path: "/rd",
+			OnGET: func(request *Message) (*Message, error) {
+				return NewContentResponseMessage(request), nil
+			},
+			RequireFreshness: true,
+		})
+		conn, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		go server.Serve(conn)
+		defer conn.Close()
+
+		client, _ := NewClient(conn.LocalAddr().String())
+		defer client.Close()
+
+		c.Convey("When a request is sent", func() {
+			resp, err := client.Do(newTestRequest(Confirmable))
+
+			c.Convey("Then it is repeated with the Echo value and served", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+			})
+		})
+	})
+}
@@ -0,0 +1,79 @@
+package coap
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Seed corpora in testdata/fuzz are taken from the test vectors of message_test.go.
+
+func addFuzzSeeds(f *testing.F) {
+	f.Add(newTestRequest(Confirmable).ToBytes())
+	f.Add(NewConfirmableMessageBuilder().
+		Code(POST).
+		WithRandomMessageId().
+		WithRandomToken().
+		Option(UriHost, OptionValueType("localhost")).
+		Option(UriPath, OptionValueType("rd")).
+		Option(Echo, OptionValueType("0123456789abcdef")).
+		Option(RequestTag, OptionValueType{0x01}, OptionValueType{}).
+		WithPayload(ContentTypeApplicationJson, []byte(`{"a":1}`)).
+		Build().ToBytes())
+	f.Add([]byte{0x40, 0x01, 0x12, 0x34, 0xFF})
+	f.Add([]byte{0x40, 0x01, 0x12, 0x34, 0xF0, 0x00})
+	f.Add([]byte{0x40, 0x01, 0x12, 0x34, 0xE0, 0xFE, 0xF2, 0x10})
+}
+
+func FuzzNewMessageFromBytes(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		msg, err := NewMessageFromBytes(b)
+		if err != nil {
+			return
+		}
+		_ = msg.String()
+		_ = msg.URI()
+		_ = msg.Validate()
+	})
+}
+
+func FuzzDecodeOptions(f *testing.F) {
+	f.Add([]byte{0xB2, 'r', 'd'})
+	f.Add([]byte{0xD1, 0x00, 0x42, 0xFF, 0x01})
+	f.Add([]byte{0xE0, 0xFE, 0xF2, 0x10})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		options := OptionsType{}
+		pos, err := decodeOptions(&options, b, nil)
+		if err != nil {
+			return
+		}
+		if pos > len(b) {
+			t.Fatalf("position %d beyond %d bytes", pos, len(b))
+		}
+		// option encoding is canonical, as long as no options are dropped
+		if encoded := encodeOptions(&options); !bytes.Equal(encoded, b[:pos]) {
+			t.Fatalf("options %x encoded as %x", b[:pos], encoded)
+		}
+	})
+}
+
+func FuzzMessageRoundTrip(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		msg, err := NewMessageFromBytes(b)
+		if err != nil {
+			return
+		}
+		encoded, err := msg.MarshalBinary()
+		if err != nil {
+			t.Fatalf("decoded message %v cannot be encoded: %v", msg, err)
+		}
+		decoded, err := NewMessageFromBytes(encoded)
+		if err != nil {
+			t.Fatalf("encoded message %x cannot be decoded: %v", encoded, err)
+		}
+		if again := decoded.ToBytes(); !bytes.Equal(again, encoded) {
+			t.Fatalf("message %x encoded again as %x", encoded, again)
+		}
+	})
+}
@@ -6,13 +6,21 @@ require (
 	bou.ke/monkey v1.0.1
 	github.com/aellwein/slf4go v0.4.2
 	github.com/aellwein/slf4go-logrus-adaptor v0.4.10
+	github.com/fxamacker/cbor/v2 v2.6.0
+	github.com/gorilla/websocket v1.5.3
+	github.com/pion/dtls/v2 v2.2.12
+	github.com/pion/transport/v2 v2.2.10
 	github.com/smartystreets/goconvey v0.0.0-20190330032615-68dc04aab96a
+	golang.org/x/crypto v0.18.0
 )
 
 require (
 	github.com/gopherjs/gopherjs v0.0.0-20190430165422-3e4dfb77656c // indirect
 	github.com/jtolds/gls v4.20.0+incompatible // indirect
+	github.com/pion/logging v0.2.2 // indirect
 	github.com/sirupsen/logrus v1.9.0 // indirect
 	github.com/smartystreets/assertions v0.0.0-20190401211740-f487f9de1cd3 // indirect
-	golang.org/x/sys v0.5.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/net v0.20.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
 )
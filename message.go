@@ -7,9 +7,8 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"net/url"
 	"time"
-
-	"github.com/aellwein/slf4go"
 )
 
 /* ERRORS */
@@ -19,8 +18,16 @@ var (
 	InvalidTokenLength    = errors.New("invalid token length")
 	MessageFormatError    = errors.New("message format error")
 	InvalidOptionNumber   = errors.New("invalid option number")
+	BufferIsTooSmall      = errors.New("buffer is too small")
+	InvalidMessageType    = errors.New("invalid message type")
+	InvalidCode           = errors.New("invalid code")
+	EmptyMessageNotEmpty  = errors.New("empty message must not carry token, options or payload")
+	OptionIsTooLong       = errors.New("option value is too long")
 )
 
+// The longest option value, which can be encoded with an extended length of 2 bytes.
+const maxOptionLength = 0xFFFF + 269
+
 /* MESSAGE */
 
 // Message ID, 16 bits.
@@ -66,8 +73,12 @@ type Message struct {
 	MessageID MessageIdType
 	Token     *TokenType
 	Source    *net.UDPAddr
+	Identity  *PeerIdentity
 	Options   *OptionsType
 	Payload   *PayloadType
+
+	// scheme and authority of the URI the request was created with, if any
+	endpoint *url.URL
 }
 
 // message type to string
@@ -82,9 +93,7 @@ func (mt MessageType) String() string {
 	case Reset:
 		return "RST"
 	default:
-		logger := slf4go.GetLogger("message")
-		logger.Panicf("unknown message type: %d", mt)
-		return ""
+		return fmt.Sprintf("Unknown(%d)", int8(mt))
 	}
 }
 
@@ -109,9 +118,8 @@ func NewToken() *TokenType {
 }
 
 func (t *TokenType) Copy() *TokenType {
-	to := make([]byte, 8)
-	copy(to, *t)
-	return t
+	to := TokenType(append([]byte{}, *t...))
+	return &to
 }
 
 /* PAYLOAD */
@@ -121,6 +129,9 @@ type PayloadType struct {
 }
 
 func (p *PayloadType) String() string {
+	if p.Type == nil {
+		return HexContent(p.Content)
+	}
 	switch *p.Type {
 
 	case ContentTypeTextPlain,
@@ -129,6 +140,12 @@ func (p *PayloadType) String() string {
 		ContentTypeApplicationJson:
 		return string(p.Content)
 
+	case ContentTypeApplicationCbor:
+		if diag, err := DiagnoseCBOR(p.Content); err == nil {
+			return diag
+		}
+		return HexContent(p.Content)
+
 	default:
 		return HexContent(p.Content)
 	}
@@ -141,7 +158,15 @@ func init() {
 }
 
 // Reads and parses a CoAP Message from packet
-func decode(buffer []byte, peer *net.UDPAddr) (*Message, error) {
+// Options are checked against the given registry. If a critical option is invalid,
+// the message is returned without options along with an *OptionError, so that it can be rejected.
+func decode(buffer []byte, peer *net.UDPAddr, registry *OptionRegistry) (*Message, error) {
+	return decodeMessage(buffer, peer, registry, false)
+}
+
+// Decodes the packet. Unless noCopy is set, the packet is copied once, so that token, option values
+// and payload of the message do not reference the given buffer.
+func decodeMessage(buffer []byte, peer *net.UDPAddr, registry *OptionRegistry, noCopy bool) (*Message, error) {
 
 	if len(buffer) < 4 {
 		// packet is too short
@@ -170,93 +195,231 @@ func decode(buffer []byte, peer *net.UDPAddr) (*Message, error) {
 	codeDetail := buffer[1] & 31
 	messageId := binary.BigEndian.Uint16(buffer[2:])
 
-	var tkn TokenType
-	if tokenLength != 0 {
-		tkn = TokenType(make([]byte, tokenLength))
-		copy(tkn, buffer[4:4+tokenLength])
-	} else {
-		tkn = []byte{}
+	// Spec: bytes after the Message ID field of an Empty message MUST be processed as a message format error.
+	if codeClass == 0 && codeDetail == 0 && len(buffer) > 4 {
+		return nil, MessageFormatError
 	}
 
-	opts := make(OptionsType)
-	buf := buffer[4+int(tokenLength):]
+	if !noCopy {
+		buffer = append([]byte(nil), buffer...)
+	}
+	// capacity is limited, so that appending to the token does not overwrite the options
+	tkn := TokenType(buffer[4 : 4+tokenLength : 4+tokenLength])
 
-	// parse options, if any
-	pos, err := decodeOptions(&opts, buf)
+	msg := &Message{
+		Type: MessageType(mType),
+		Code: &CodeType{
+			CodeClass:  CodeClassType(codeClass),
+			CodeDetail: CodeDetailType(codeDetail),
+		},
+		MessageID: MessageIdType(messageId),
+		Token:     &tkn,
+		Source:    peer,
+	}
+
+	opts, payload, err := decodeOptionsAndPayload(buffer[4+int(tokenLength):], registry)
 	if err != nil {
+		var optionError *OptionError
+		if errors.As(err, &optionError) {
+			// the header allows the message to be rejected
+			msg.Options = &OptionsType{}
+			return msg, err
+		}
 		return nil, err
 	}
+	msg.Options = opts
+	msg.Payload = payload
+
+	return msg, nil
+}
+
+// Reads options and payload, which follow the token in every CoAP transport.
+// Option values and payload reference the buffer.
+// If registry is nil, option numbers are not checked against any registry.
+func decodeOptionsAndPayload(buf []byte, registry *OptionRegistry) (*OptionsType, *PayloadType, error) {
+	// room for the options of typical messages, to avoid growing the slice
+	opts := make(OptionsType, 0, 8)
+
+	// parse options, if any
+	pos, err := decodeOptions(&opts, buf, registry)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// parse payload, if any
-	pos += int(tokenLength) + 4
-	payloadLen := len(buffer) - pos
+	payloadLen := len(buf) - pos
 	var payload *PayloadType
 
 	if payloadLen > 0 {
 		payload = new(PayloadType)
-		payload.Content = make([]byte, payloadLen-1)
-		copy(payload.Content, buffer[pos+1:])
-
-		if v, ok := opts[ContentFormat]; ok {
-			var c ContentType
-			switch len(v[0]) {
-			case 0:
-				c = 0
-				payload.Type = &c
-			default:
-				if be, err := ToBigEndianNumber(v[0]); err == nil {
-					if b, casts := be.(uint8); casts {
-						c = ContentType(b)
-					} else if u, casts := be.(uint16); casts {
-						c = ContentType(u)
-					} else {
-						return nil, errors.New("invalid content format")
-					}
-					payload.Type = &c
-				} else {
-					return nil, err
-				}
+		payload.Content = buf[pos+1:]
+
+		if v, ok := opts.first(ContentFormat); ok {
+			if len(v) > 2 {
+				return nil, nil, errors.New("invalid content format")
 			}
+			c := ContentType(optionUint(v))
+			payload.Type = &c
 		}
 		// the case that the content format is not provided
 		// is handled in message.Validate()
 	}
+	return &opts, payload, nil
+}
 
-	msg := &Message{
-		Type: MessageType(mType),
-		Code: &CodeType{
-			CodeClass:  CodeClassType(codeClass),
-			CodeDetail: CodeDetailType(codeDetail),
-		},
-		MessageID: MessageIdType(messageId),
-		Token:     &tkn,
-		Source:    peer,
-		Options:   &opts,
-		Payload:   payload,
+// Encode the message to a byte array. Nil is returned, if the message cannot be encoded, see MarshalBinary.
+func (m *Message) ToBytes() []byte {
+	b, err := m.MarshalBinary()
+	if err != nil {
+		return nil
 	}
+	return b
+}
 
-	return msg, nil
+// MarshalBinary encodes the message, after checking that it can be encoded: type and code must fit
+// into their fields, the token must not exceed 8 bytes and option values must not exceed 65804 bytes.
+// A nil token is encoded as empty token.
+func (m *Message) MarshalBinary() ([]byte, error) {
+	if err := m.checkEncoding(); err != nil {
+		return nil, err
+	}
+	return m.AppendTo(nil), nil
 }
 
-// Encode the message to a byte array.
-func (m *Message) ToBytes() []byte {
-	var pkt bytes.Buffer
+// UnmarshalBinary decodes the message from data, which is copied.
+// Options are checked against the predefined options.
+func (m *Message) UnmarshalBinary(data []byte) error {
+	msg, err := decode(data, nil, DefaultOptionRegistry)
+	if err != nil {
+		return err
+	}
+	*m = *msg
+	return nil
+}
 
-	pkt.WriteByte(byte(64 + byte(m.Type<<4) + byte(len(*m.Token))))
-	pkt.WriteByte(byte(m.Code.CodeClass<<5) + byte(m.Code.CodeDetail))
+// Checks the invariants of the header, token and options, which are required to encode the message.
+func (m *Message) checkEncoding() error {
+	if m.Type < Confirmable || m.Type > Reset {
+		return fmt.Errorf("%w: %d", InvalidMessageType, m.Type)
+	}
+	if m.Code == nil {
+		return fmt.Errorf("%w: code is missing", InvalidCode)
+	}
+	if !m.Code.isValid() {
+		return fmt.Errorf("%w: %d.%02d", InvalidCode, m.Code.CodeClass, m.Code.CodeDetail)
+	}
+	if len(m.token()) > 8 {
+		return fmt.Errorf("%w: %d bytes", InvalidTokenLength, len(m.token()))
+	}
+	if m.Options != nil {
+		for _, o := range *m.Options {
+			if len(o.Value) > maxOptionLength {
+				return &OptionError{Number: o.Number, Reason: OptionIsTooLong}
+			}
+		}
+	}
+	// Spec: an Empty message has no bytes of data after the Message ID field.
+	if m.Code.IsEmpty() && (len(m.token()) > 0 ||
+		(m.Options != nil && len(*m.Options) > 0) || (m.Payload != nil && len(m.Payload.Content) > 0)) {
+		return EmptyMessageNotEmpty
+	}
+	return nil
+}
 
-	msgId := make([]byte, 2)
-	binary.BigEndian.PutUint16(msgId, uint16(m.MessageID))
-	pkt.Write(msgId)
-	pkt.Write(*m.Token)
+func (m *Message) token() []byte {
+	if m.Token == nil {
+		return nil
+	}
+	return *m.Token
+}
 
-	pkt.Write(encodeOptions(m.Options))
+// AppendTo appends the encoded message to dst and returns the extended buffer.
+// Given a buffer of sufficient capacity, encoding does not allocate.
+// The message is not checked, use MarshalBinary to encode messages not known to be valid.
+func (m *Message) AppendTo(dst []byte) []byte {
+	dst = append(dst,
+		byte(64+byte(m.Type<<4)+byte(len(m.token()))),
+		byte(m.Code.CodeClass<<5)+byte(m.Code.CodeDetail),
+		byte(m.MessageID>>8),
+		byte(m.MessageID))
+	dst = append(dst, m.token()...)
+	return m.appendOptionsAndPayload(dst)
+}
+
+// MarshalTo encodes the message into dst and returns the number of bytes written.
+// BufferIsTooSmall is returned, if the message does not fit into dst.
+func (m *Message) MarshalTo(dst []byte) (int, error) {
+	if err := m.checkEncoding(); err != nil {
+		return 0, err
+	}
+	encoded := m.AppendTo(dst[:0:len(dst)])
+	if len(encoded) > len(dst) {
+		return 0, BufferIsTooSmall
+	}
+	return len(encoded), nil
+}
+
+// Appends options and payload, which follow the token in every CoAP transport.
+func (m *Message) appendOptionsAndPayload(dst []byte) []byte {
+	if m.Options != nil {
+		dst = appendOptions(dst, m.Options)
+	}
 
 	if m.Payload != nil && len(m.Payload.Content) > 0 {
-		pkt.WriteByte(0xff)
-		pkt.Write(m.Payload.Content)
+		dst = append(dst, 0xff)
+		dst = append(dst, m.Payload.Content...)
+	}
+	return dst
+}
+
+// Clone returns a deep copy of the message: code, token, options and payload are copied,
+// so that changes to the copy do not affect the message. Source and identity are shared.
+func (m *Message) Clone() *Message {
+	clone := *m
+	if m.Code != nil {
+		code := *m.Code
+		clone.Code = &code
+	}
+
+	// token, option values and payload are copied into a single buffer
+	size := len(m.token())
+	if m.Options != nil {
+		for _, o := range *m.Options {
+			size += len(o.Value)
+		}
+	}
+	if m.Payload != nil {
+		size += len(m.Payload.Content)
+	}
+	buffer := make([]byte, 0, size)
+	duplicate := func(b []byte) []byte {
+		buffer = append(buffer, b...)
+		return buffer[len(buffer)-len(b) : len(buffer) : len(buffer)]
+	}
+
+	if m.Token != nil {
+		token := TokenType(duplicate(*m.Token))
+		clone.Token = &token
+	}
+	if m.Options != nil {
+		options := make(OptionsType, len(*m.Options))
+		for i, o := range *m.Options {
+			options[i] = Option{Number: o.Number, Value: duplicate(o.Value)}
+		}
+		clone.Options = &options
+	}
+	if m.Payload != nil {
+		payload := PayloadType{Content: m.Payload.Content}
+		if m.Payload.Content != nil {
+			payload.Content = duplicate(m.Payload.Content)
+		}
+		if m.Payload.Type != nil {
+			contentType := *m.Payload.Type
+			payload.Type = &contentType
+		}
+		clone.Payload = &payload
 	}
-	return pkt.Bytes()
+	return &clone
 }
 
 // Stringify message
@@ -273,14 +436,14 @@ func (m *Message) String() string {
 
 // Returns true, if message contains an option of given code.
 func (m *Message) HasOption(opt OptionNumberType) bool {
-	_, ok := (*m.Options)[opt]
-	return ok
+	return m.Options.Has(opt)
 }
 
 // Validates the message, returning one of the ok codes, if message is alright,
 // otherwise specific error is returned.
 func (m *Message) Validate() *CodeType {
-	if m.Payload != nil && m.Payload.Content != nil && !m.HasOption(ContentFormat) {
+	// the payload of OSCORE messages is the ciphertext, which has no content format
+	if m.Payload != nil && m.Payload.Content != nil && !m.HasOption(ContentFormat) && !m.HasOption(OSCORE) {
 		return BadRequest
 	}
 	return Ok
@@ -1,7 +1,6 @@
 package coap
 
 import (
-	"encoding/binary"
 	"net"
 )
 
@@ -37,7 +36,7 @@ type messageContext struct {
 
 // NewMessageBuilderOfType creates a new message builder for message of given type.
 func NewMessageBuilderOfType(mt MessageType) messageBuilder {
-	opts := make(OptionsType)
+	opts := OptionsType{}
 	return messageBuilder{&messageContext{mType: mt, options: &opts}}
 }
 
@@ -99,7 +98,7 @@ func (m messageIdBuilder) WithRandomToken() messageTokenBuilder {
 
 func (m messageTokenBuilder) Build() *Message {
 	if m.msgCtx.options == nil {
-		opts := make(OptionsType)
+		opts := OptionsType{}
 		m.msgCtx.options = &opts
 	}
 	return &Message{
@@ -115,24 +114,16 @@ func (m messageTokenBuilder) Build() *Message {
 // Option builder method adds an option (code), with one or many values given.
 func (m messageTokenBuilder) Option(opt OptionNumberType, valueTypes ...OptionValueType) messageTokenBuilder {
 	if m.msgCtx.options == nil {
-		opts := make(OptionsType)
+		opts := OptionsType{}
 		m.msgCtx.options = &opts
-		(*m.msgCtx.options)[opt] = valueTypes
-	} else {
-		(*m.msgCtx.options)[opt] = append((*m.msgCtx.options)[opt], valueTypes...)
 	}
+	m.msgCtx.options.Add(opt, valueTypes...)
 	return m
 }
 
 // WithPayload provides a payload of given type to the message builder.
 func (m messageTokenBuilder) WithPayload(cType ContentType, payload []byte) messagePayloadBuilder {
-	if cType < 256 {
-		(*m.msgCtx.options)[ContentFormat] = []OptionValueType{[]byte{byte(cType)}}
-	} else {
-		b := make([]byte, 2)
-		binary.BigEndian.PutUint16(b, uint16(cType))
-		(*m.msgCtx.options)[ContentFormat] = []OptionValueType{b}
-	}
+	m.msgCtx.options.Set(ContentFormat, uintOptionValue(uint64(cType)))
 	m.msgCtx.payload = &PayloadType{
 		Type:    &cType,
 		Content: payload,
@@ -156,13 +147,19 @@ func (m messagePayloadBuilder) Build() *Message {
 // NewMessageFromBytes constructs a new message from the given bytes packet,
 // if not successful, an error is returned.
 func NewMessageFromBytes(buffer []byte) (*Message, error) {
-	return decode(buffer, nil)
+	return decode(buffer, nil, DefaultOptionRegistry)
+}
+
+// NewMessageFromBytesNoCopy constructs a new message from the given bytes packet without copying it:
+// token, option values and payload reference the packet, which must not be modified while the message is in use.
+func NewMessageFromBytesNoCopy(buffer []byte) (*Message, error) {
+	return decodeMessage(buffer, nil, DefaultOptionRegistry, true)
 }
 
 // NewMessageFromBytesAndPeer constructs a new message from the given bytes packet and
 // sets the source address of the message. If not successful, an error is returned.
 func NewMessageFromBytesAndPeer(buffer []byte, peer *net.UDPAddr) (*Message, error) {
-	return decode(buffer, peer)
+	return decode(buffer, peer, DefaultOptionRegistry)
 }
 
 func responseWithCode(request *Message, code *CodeType) *Message {
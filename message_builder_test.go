@@ -27,7 +27,7 @@ func TestNewConfirmableMessageBuilder(t *testing.T) {
 				c.So(msg.MessageID, c.ShouldNotEqual, 0)
 				c.So(msg.Token, c.ShouldNotResemble, []byte{0, 0, 0, 0, 0, 0, 0, 0})
 				c.So(len(*msg.Options), c.ShouldEqual, 3)
-				c.So((*msg.Options)[UriPath], c.ShouldResemble, []OptionValueType{[]byte("rd")})
+				c.So(msg.Options.Get(UriPath), c.ShouldResemble, []OptionValueType{[]byte("rd")})
 				c.So(*msg.Payload.Type, c.ShouldEqual, ContentTypeApplicationJson)
 				c.So(msg.Payload.Content, c.ShouldResemble, []byte("lalala"))
 			})
@@ -53,8 +53,8 @@ func TestNewConfirmableMessageBuilderWithCustomPayloadType(t *testing.T) {
 				c.So(msg.MessageID, c.ShouldNotEqual, 0)
 				c.So(msg.Token, c.ShouldNotResemble, []byte{0, 0, 0, 0, 0, 0, 0, 0})
 				c.So(len(*msg.Options), c.ShouldEqual, 3)
-				c.So((*msg.Options)[UriPath], c.ShouldResemble, []OptionValueType{[]byte("rd")})
-				c.So(binary.BigEndian.Uint16((*msg.Options)[ContentFormat][0]), c.ShouldEqual, 65000)
+				c.So(msg.Options.Get(UriPath), c.ShouldResemble, []OptionValueType{[]byte("rd")})
+				c.So(binary.BigEndian.Uint16(msg.Options.Get(ContentFormat)[0]), c.ShouldEqual, 65000)
 				c.So(*msg.Payload.Type, c.ShouldEqual, 65000)
 				c.So(msg.Payload.Content, c.ShouldResemble, []byte("lalala"))
 			})
@@ -80,7 +80,7 @@ func TestNewNonConfirmableMessageBuilder(t *testing.T) {
 				c.So(msg.MessageID, c.ShouldNotEqual, 0)
 				c.So(msg.Token, c.ShouldNotResemble, []byte{0, 0, 0, 0, 0, 0, 0, 0})
 				c.So(len(*msg.Options), c.ShouldEqual, 2)
-				c.So((*msg.Options)[UriPath], c.ShouldResemble, []OptionValueType{[]byte("rd")})
+				c.So(msg.Options.Get(UriPath), c.ShouldResemble, []OptionValueType{[]byte("rd")})
 				c.So(*msg.Payload.Type, c.ShouldEqual, ContentTypeTextPlain)
 				c.So(msg.Payload.Content, c.ShouldResemble, []byte("lalala"))
 				c.So(msg.Source, c.ShouldEqual, addr)
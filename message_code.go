@@ -1,7 +1,11 @@
 package coap
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 /* MESSAGE CODES */
@@ -15,6 +19,10 @@ var (
 	POST   = &CodeType{CodeClass: 0, CodeDetail: 2}
 	PUT    = &CodeType{CodeClass: 0, CodeDetail: 3}
 	DELETE = &CodeType{CodeClass: 0, CodeDetail: 4}
+	// methods of RFC 8132
+	FETCH  = &CodeType{CodeClass: 0, CodeDetail: 5}
+	PATCH  = &CodeType{CodeClass: 0, CodeDetail: 6}
+	IPATCH = &CodeType{CodeClass: 0, CodeDetail: 7}
 )
 
 var (
@@ -25,6 +33,8 @@ var (
 	Valid   = &CodeType{CodeClass: 2, CodeDetail: 3}
 	Changed = &CodeType{CodeClass: 2, CodeDetail: 4}
 	Content = &CodeType{CodeClass: 2, CodeDetail: 5}
+	// Continue is used by block-wise transfers (RFC 7959)
+	Continue = &CodeType{CodeClass: 2, CodeDetail: 31}
 
 	// Client error codes
 	BadRequest               = &CodeType{CodeClass: 4, CodeDetail: 0}
@@ -34,9 +44,13 @@ var (
 	NotFound                 = &CodeType{CodeClass: 4, CodeDetail: 4}
 	MethodNotAllowed         = &CodeType{CodeClass: 4, CodeDetail: 5}
 	NotAcceptable            = &CodeType{CodeClass: 4, CodeDetail: 6}
+	RequestEntityIncomplete  = &CodeType{CodeClass: 4, CodeDetail: 8}
+	Conflict                 = &CodeType{CodeClass: 4, CodeDetail: 9}
 	PreconditionFailed       = &CodeType{CodeClass: 4, CodeDetail: 12}
 	RequestEntityTooLarge    = &CodeType{CodeClass: 4, CodeDetail: 13}
 	UnsupportedContentFormat = &CodeType{CodeClass: 4, CodeDetail: 15}
+	UnprocessableEntity      = &CodeType{CodeClass: 4, CodeDetail: 22}
+	TooManyRequests          = &CodeType{CodeClass: 4, CodeDetail: 29}
 
 	// Server error codes
 	InternalServerError  = &CodeType{CodeClass: 5, CodeDetail: 0}
@@ -45,6 +59,16 @@ var (
 	ServiceUnavailable   = &CodeType{CodeClass: 5, CodeDetail: 3}
 	GatewayTimeout       = &CodeType{CodeClass: 5, CodeDetail: 4}
 	ProxyingNotSupported = &CodeType{CodeClass: 5, CodeDetail: 5}
+	HopLimitReached      = &CodeType{CodeClass: 5, CodeDetail: 8}
+)
+
+// Signaling Codes (RFC 8323), used by reliable transports only
+var (
+	CSM     = &CodeType{CodeClass: 7, CodeDetail: 1}
+	Ping    = &CodeType{CodeClass: 7, CodeDetail: 2}
+	Pong    = &CodeType{CodeClass: 7, CodeDetail: 3}
+	Release = &CodeType{CodeClass: 7, CodeDetail: 4}
+	Abort   = &CodeType{CodeClass: 7, CodeDetail: 5}
 )
 
 var AllMessageCodes = []*CodeType{
@@ -53,12 +77,16 @@ var AllMessageCodes = []*CodeType{
 	POST,
 	PUT,
 	DELETE,
+	FETCH,
+	PATCH,
+	IPATCH,
 	Ok,
 	Created,
 	Deleted,
 	Valid,
 	Changed,
 	Content,
+	Continue,
 	BadRequest,
 	Unauthorized,
 	BadOption,
@@ -66,74 +94,169 @@ var AllMessageCodes = []*CodeType{
 	NotFound,
 	MethodNotAllowed,
 	NotAcceptable,
+	RequestEntityIncomplete,
+	Conflict,
 	PreconditionFailed,
 	RequestEntityTooLarge,
 	UnsupportedContentFormat,
+	UnprocessableEntity,
+	TooManyRequests,
 	InternalServerError,
 	NotImplemented,
 	BadGateway,
 	ServiceUnavailable,
 	GatewayTimeout,
 	ProxyingNotSupported,
+	HopLimitReached,
+	CSM,
+	Ping,
+	Pong,
+	Release,
+	Abort,
+}
+
+var (
+	InvalidCodeString     = errors.New("code must be given as c.dd or by name")
+	CodeAlreadyRegistered = errors.New("code is already registered")
+)
+
+// Names of the codes, as registered with IANA (CoAP Method and Response Codes, Signaling Codes).
+var codeRegistry = map[CodeType]string{
+	*EmptyMessage:             "Empty",
+	*GET:                      "GET",
+	*POST:                     "POST",
+	*PUT:                      "PUT",
+	*DELETE:                   "DELETE",
+	*FETCH:                    "FETCH",
+	*PATCH:                    "PATCH",
+	*IPATCH:                   "iPATCH",
+	*Ok:                       "Ok",
+	*Created:                  "Created",
+	*Deleted:                  "Deleted",
+	*Valid:                    "Valid",
+	*Changed:                  "Changed",
+	*Content:                  "Content",
+	*Continue:                 "Continue",
+	*BadRequest:               "BadRequest",
+	*Unauthorized:             "Unauthorized",
+	*BadOption:                "BadOption",
+	*Forbidden:                "Forbidden",
+	*NotFound:                 "NotFound",
+	*MethodNotAllowed:         "MethodNotAllowed",
+	*NotAcceptable:            "NotAcceptable",
+	*RequestEntityIncomplete:  "RequestEntityIncomplete",
+	*Conflict:                 "Conflict",
+	*PreconditionFailed:       "PreconditionFailed",
+	*RequestEntityTooLarge:    "RequestEntityTooLarge",
+	*UnsupportedContentFormat: "UnsupportedContentFormat",
+	*UnprocessableEntity:      "UnprocessableEntity",
+	*TooManyRequests:          "TooManyRequests",
+	*InternalServerError:      "InternalServerError",
+	*NotImplemented:           "NotImplemented",
+	*BadGateway:               "BadGateway",
+	*ServiceUnavailable:       "ServiceUnavailable",
+	*GatewayTimeout:           "GatewayTimeout",
+	*ProxyingNotSupported:     "ProxyingNotSupported",
+	*HopLimitReached:          "HopLimitReached",
+	*CSM:                      "CSM",
+	*Ping:                     "Ping",
+	*Pong:                     "Pong",
+	*Release:                  "Release",
+	*Abort:                    "Abort",
+}
+
+// Guards registration of codes.
+var codeLock sync.RWMutex
+
+// RegisterCode registers the name of a code not known yet, e.g. of a new RFC.
+func RegisterCode(code *CodeType, name string) error {
+	if !code.isValid() || name == "" {
+		return fmt.Errorf("%w: %d.%02d", InvalidCode, code.CodeClass, code.CodeDetail)
+	}
+	codeLock.Lock()
+	defer codeLock.Unlock()
+	if _, ok := codeRegistry[*code]; ok {
+		return CodeAlreadyRegistered
+	}
+	codeRegistry[*code] = name
+	return nil
+}
+
+// ParseCode parses a code given in c.dd notation, e.g. "4.04", or by its registered name, e.g. "NotFound".
+// Codes in c.dd notation do not need to be registered.
+func ParseCode(s string) (*CodeType, error) {
+	if class, detail, ok := strings.Cut(s, "."); ok {
+		c, err := strconv.ParseUint(class, 10, 8)
+		if err != nil || len(class) != 1 || len(detail) != 2 {
+			return nil, fmt.Errorf("%w: %q", InvalidCodeString, s)
+		}
+		dd, err := strconv.ParseUint(detail, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", InvalidCodeString, s)
+		}
+		code := &CodeType{CodeClass: CodeClassType(c), CodeDetail: CodeDetailType(dd)}
+		if !code.isValid() {
+			return nil, fmt.Errorf("%w: %q", InvalidCode, s)
+		}
+		return code, nil
+	}
+
+	codeLock.RLock()
+	defer codeLock.RUnlock()
+	for code, name := range codeRegistry {
+		if strings.EqualFold(name, s) {
+			return &code, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", InvalidCodeString, s)
 }
 
 func (c *CodeType) String() string {
-	switch *c {
-	case *EmptyMessage:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "Empty")
-	case *GET:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "GET")
-	case *POST:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "POST")
-	case *PUT:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "PUT")
-	case *DELETE:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "DELETE")
-	case *Ok:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "Ok")
-	case *Created:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "Created")
-	case *Deleted:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "Deleted")
-	case *Valid:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "Valid")
-	case *Changed:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "Changed")
-	case *Content:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "Content")
-	case *BadRequest:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "BadRequest")
-	case *Unauthorized:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "Unauthorized")
-	case *BadOption:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "BadOption")
-	case *Forbidden:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "Forbidden")
-	case *NotFound:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "NotFound")
-	case *MethodNotAllowed:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "MethodNotAllowed")
-	case *NotAcceptable:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "NotAcceptable")
-	case *PreconditionFailed:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "PreconditionFailed")
-	case *RequestEntityTooLarge:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "RequestEntityTooLarge")
-	case *UnsupportedContentFormat:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "UnsupportedContentFormat")
-	case *InternalServerError:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "InternalServerError")
-	case *NotImplemented:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "NotImplemented")
-	case *BadGateway:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "BadGateway")
-	case *ServiceUnavailable:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "ServiceUnavailable")
-	case *GatewayTimeout:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "GatewayTimeout")
-	case *ProxyingNotSupported:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "ProxyingNotSupported")
-	default:
-		return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, "Unknown Code")
+	codeLock.RLock()
+	name, ok := codeRegistry[*c]
+	codeLock.RUnlock()
+	if !ok {
+		name = "Unknown Code"
 	}
+	return fmt.Sprintf("%d.%02d (%s)", c.CodeClass, c.CodeDetail, name)
+}
+
+// IsEmpty checks whether the code is 0.00, the code of empty messages.
+func (c *CodeType) IsEmpty() bool {
+	return *c == *EmptyMessage
+}
+
+// IsRequest checks whether the code is a method code, i.e. of class 0 except 0.00.
+func (c *CodeType) IsRequest() bool {
+	return c.CodeClass == 0 && c.CodeDetail != 0
+}
+
+// IsResponse checks whether the code is a response code, i.e. of class 2, 4 or 5.
+func (c *CodeType) IsResponse() bool {
+	return c.IsSuccess() || c.IsClientError() || c.IsServerError()
+}
+
+// IsSuccess checks whether the code is a success response code of class 2.
+func (c *CodeType) IsSuccess() bool {
+	return c.CodeClass == 2
+}
+
+// IsClientError checks whether the code is a client error response code of class 4.
+func (c *CodeType) IsClientError() bool {
+	return c.CodeClass == 4
+}
+
+// IsServerError checks whether the code is a server error response code of class 5.
+func (c *CodeType) IsServerError() bool {
+	return c.CodeClass == 5
+}
+
+// IsSignaling checks whether the code is a signaling code of class 7, used by reliable transports only.
+func (c *CodeType) IsSignaling() bool {
+	return c.CodeClass == 7
+}
+
+// Checks whether the code fits into the code field.
+func (c *CodeType) isValid() bool {
+	return c.CodeClass <= 7 && c.CodeDetail <= 31
 }
@@ -0,0 +1,114 @@
+package coap
+
+import (
+	"errors"
+	"testing"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseCode(t *testing.T) {
+	c.Convey("Given codes in c.dd notation and by name", t, func() {
+		codes := map[string]*CodeType{
+			"4.04":            NotFound,
+			"2.31":            Continue,
+			"5.08":            HopLimitReached,
+			"7.02":            Ping,
+			"3.17":            {CodeClass: 3, CodeDetail: 17},
+			"NotFound":        NotFound,
+			"ipatch":          IPATCH,
+			"TooManyRequests": TooManyRequests,
+		}
+
+		c.Convey("Then they are parsed", func() {
+			for s, expected := range codes {
+				code, err := ParseCode(s)
+				c.So(err, c.ShouldBeNil)
+				c.So(*code, c.ShouldResemble, *expected)
+			}
+		})
+	})
+
+	c.Convey("Given invalid codes", t, func() {
+		c.Convey("Then parsing fails", func() {
+			for _, s := range []string{"", "404", "4.4", "8.00", "4.32", "x.04", "NoSuchCode"} {
+				_, err := ParseCode(s)
+				c.So(err, c.ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestCodeType_Classes(t *testing.T) {
+	c.Convey("Given codes of every class", t, func() {
+		c.Convey("Then their classes are told apart", func() {
+			c.So(EmptyMessage.IsEmpty(), c.ShouldBeTrue)
+			c.So(EmptyMessage.IsRequest(), c.ShouldBeFalse)
+			c.So(FETCH.IsRequest(), c.ShouldBeTrue)
+			c.So(Continue.IsSuccess(), c.ShouldBeTrue)
+			c.So(Conflict.IsClientError(), c.ShouldBeTrue)
+			c.So(Conflict.IsResponse(), c.ShouldBeTrue)
+			c.So(HopLimitReached.IsServerError(), c.ShouldBeTrue)
+			c.So(Abort.IsSignaling(), c.ShouldBeTrue)
+			c.So(Abort.IsResponse(), c.ShouldBeFalse)
+		})
+	})
+}
+
+func TestRegisterCode(t *testing.T) {
+	c.Convey("Given a code not registered yet", t, func() {
+		code := &CodeType{CodeClass: 4, CodeDetail: 30}
+		c.So(code.String(), c.ShouldEqual, "4.30 (Unknown Code)")
+
+		c.Convey("When it is registered", func() {
+			err := RegisterCode(code, "Teapot")
+			defer func() {
+				codeLock.Lock()
+				delete(codeRegistry, *code)
+				codeLock.Unlock()
+			}()
+
+			c.Convey("Then it is known by name", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(code.String(), c.ShouldEqual, "4.30 (Teapot)")
+				parsed, _ := ParseCode("Teapot")
+				c.So(*parsed, c.ShouldResemble, *code)
+			})
+		})
+	})
+
+	c.Convey("Given codes already registered or invalid", t, func() {
+		c.Convey("Then registering them fails", func() {
+			c.So(RegisterCode(NotFound, "Missing"), c.ShouldEqual, CodeAlreadyRegistered)
+			c.So(errors.Is(RegisterCode(&CodeType{CodeClass: 8}, "Invalid"), InvalidCode), c.ShouldBeTrue)
+		})
+	})
+}
+
+func TestMessageType_String(t *testing.T) {
+	c.Convey("Given an unknown message type", t, func() {
+		c.Convey("Then its string representation does not panic", func() {
+			c.So(MessageType(7).String(), c.ShouldEqual, "Unknown(7)")
+		})
+	})
+}
+
+func TestServer_UnknownMethod(t *testing.T) {
+	c.Convey("Given a coap server", t, func() {
+		server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{Path: "/rd"})
+
+		c.Convey("When a request with a method not supported is routed", func() {
+			msg := NewConfirmableMessageBuilder().
+				Code(FETCH).
+				WithRandomMessageId().
+				WithRandomToken().
+				Option(UriPath, []byte("rd")).
+				Build()
+			resp := server.routeRequest(msg)
+
+			c.Convey("Then it is answered by 4.05 Method Not Allowed", func() {
+				c.So(*resp.Code, c.ShouldResemble, *MethodNotAllowed)
+			})
+		})
+	})
+}
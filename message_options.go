@@ -0,0 +1,135 @@
+package coap
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+var OptionFormatMismatch = errors.New("option is defined with another format")
+
+// GetUint returns the first value of the uint option. False is returned, if the option is missing
+// or its value exceeds 8 bytes.
+func (m *Message) GetUint(opt OptionNumberType) (uint64, bool) {
+	v, ok := m.first(opt)
+	if !ok || len(v) > 8 {
+		return 0, false
+	}
+	return optionUint(v), true
+}
+
+// GetString returns the first value of the string option. False is returned, if the option is missing
+// or its value is not valid UTF-8.
+func (m *Message) GetString(opt OptionNumberType) (string, bool) {
+	v, ok := m.first(opt)
+	if !ok || !utf8.Valid(v) {
+		return "", false
+	}
+	return string(v), true
+}
+
+// GetStrings returns all values of the string option, e.g. of Uri-Query.
+func (m *Message) GetStrings(opt OptionNumberType) []string {
+	var s []string
+	for _, v := range m.Options.Get(opt) {
+		s = append(s, string(v))
+	}
+	return s
+}
+
+// GetOpaque returns the first value of the opaque option.
+func (m *Message) GetOpaque(opt OptionNumberType) ([]byte, bool) {
+	return m.first(opt)
+}
+
+func (m *Message) first(opt OptionNumberType) (OptionValueType, bool) {
+	return m.Options.first(opt)
+}
+
+// Path returns the Uri-Path options as slash-style path, e.g. "/sensors/temp".
+func (m *Message) Path() string {
+	return UriPathOptionToString(m.Options.Get(UriPath))
+}
+
+// SetUint sets the uint option to the value, encoded with as few bytes as possible,
+// replacing previous values.
+func (m *Message) SetUint(opt OptionNumberType, v uint64) error {
+	return m.setOption(opt, Uint, uintOptionValue(v))
+}
+
+// SetString sets the string option to the value, replacing previous values.
+func (m *Message) SetString(opt OptionNumberType, v string) error {
+	return m.setOption(opt, String, OptionValueType(v))
+}
+
+// SetOpaque sets the opaque option to the value, replacing previous values.
+func (m *Message) SetOpaque(opt OptionNumberType, v []byte) error {
+	return m.setOption(opt, Opaque, v)
+}
+
+// AddString adds the value to the repeatable string option, e.g. a segment of Uri-Path.
+func (m *Message) AddString(opt OptionNumberType, v string) error {
+	if m.Options == nil {
+		m.Options = &OptionsType{}
+	}
+	if err := checkOption(opt, String, OptionValueType(v), len(m.Options.Get(opt))); err != nil {
+		return err
+	}
+	m.Options.Add(opt, OptionValueType(v))
+	return nil
+}
+
+// Del removes all values of the option.
+func (m *Message) Del(opt OptionNumberType) {
+	m.Options.Del(opt)
+}
+
+func (m *Message) setOption(opt OptionNumberType, format OptionFormat, v OptionValueType) error {
+	if err := checkOption(opt, format, v, 0); err != nil {
+		return err
+	}
+	if m.Options == nil {
+		m.Options = &OptionsType{}
+	}
+	m.Options.Set(opt, v)
+	return nil
+}
+
+// Checks the value to be set against the predefined option, options unknown are not checked.
+func checkOption(opt OptionNumberType, format OptionFormat, v OptionValueType, preceding int) error {
+	definition, ok := DefaultOptionRegistry.Lookup(opt)
+	if !ok {
+		return nil
+	}
+	if definition.Format != format {
+		return &OptionError{Number: opt, Reason: OptionFormatMismatch}
+	}
+	if err := DefaultOptionRegistry.validate(opt, v, preceding, true); err != nil {
+		return &OptionError{Number: opt, Reason: err}
+	}
+	return nil
+}
+
+// SetUint sets the uint option to the value, encoded with as few bytes as possible.
+// Options set by the builder are validated when the message is sent.
+func (m messageTokenBuilder) SetUint(opt OptionNumberType, v uint64) messageTokenBuilder {
+	m.msgCtx.options.Set(opt, uintOptionValue(v))
+	return m
+}
+
+// AddString adds the value to the string option.
+func (m messageTokenBuilder) AddString(opt OptionNumberType, v string) messageTokenBuilder {
+	m.msgCtx.options.Add(opt, OptionValueType(v))
+	return m
+}
+
+// Path sets the Uri-Path options to the segments of the slash-style path, e.g. "/sensors/temp".
+func (m messageTokenBuilder) Path(path string) messageTokenBuilder {
+	m.msgCtx.options.Set(UriPath, NewLocationPathOption(path)...)
+	return m
+}
+
+// Del removes all values of the option.
+func (m messageTokenBuilder) Del(opt OptionNumberType) messageTokenBuilder {
+	m.msgCtx.options.Del(opt)
+	return m
+}
@@ -0,0 +1,80 @@
+package coap
+
+import (
+	"errors"
+	"testing"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMessage_TypedOptions(t *testing.T) {
+	c.Convey("Given a message built with typed options", t, func() {
+		msg := NewConfirmableMessageBuilder().
+			Code(GET).
+			WithRandomMessageId().
+			WithRandomToken().
+			Path("/sensors/temp").
+			AddString(UriQuery, "unit=C").
+			SetUint(Observe, 0).
+			Build()
+
+		c.Convey("Then the options can be read typed", func() {
+			c.So(msg.Path(), c.ShouldEqual, "/sensors/temp")
+			c.So(msg.GetStrings(UriQuery), c.ShouldResemble, []string{"unit=C"})
+			observe, ok := msg.GetUint(Observe)
+			c.So(ok, c.ShouldBeTrue)
+			c.So(observe, c.ShouldEqual, 0)
+			_, ok = msg.GetUint(MaxAge)
+			c.So(ok, c.ShouldBeFalse)
+		})
+
+		c.Convey("When options are set", func() {
+			c.So(msg.SetUint(MaxAge, 300), c.ShouldBeNil)
+			c.So(msg.SetOpaque(ETag, []byte{0xCA, 0xFE}), c.ShouldBeNil)
+			c.So(msg.AddString(UriPath, "celsius"), c.ShouldBeNil)
+			msg.Del(Observe)
+
+			c.Convey("Then uints are encoded minimally", func() {
+				c.So(msg.Options.Get(MaxAge), c.ShouldResemble, []OptionValueType{{0x01, 0x2C}})
+				maxAge, _ := msg.GetUint(MaxAge)
+				c.So(maxAge, c.ShouldEqual, 300)
+			})
+
+			c.Convey("And the options are changed", func() {
+				etag, _ := msg.GetOpaque(ETag)
+				c.So(etag, c.ShouldResemble, []byte{0xCA, 0xFE})
+				c.So(msg.Path(), c.ShouldEqual, "/sensors/temp/celsius")
+				c.So(msg.HasOption(Observe), c.ShouldBeFalse)
+			})
+		})
+
+		c.Convey("When options violating their definitions are set", func() {
+			c.Convey("Then errors are returned", func() {
+				c.So(errors.Is(msg.SetString(MaxAge, "60"), OptionFormatMismatch), c.ShouldBeTrue)
+				c.So(errors.Is(msg.SetOpaque(ETag, make([]byte, 9)), OptionValueTooLong), c.ShouldBeTrue)
+				c.So(msg.AddString(UriHost, "a"), c.ShouldBeNil)
+				c.So(errors.Is(msg.AddString(UriHost, "b"), OptionNotRepeatable), c.ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestMessage_NilOptions(t *testing.T) {
+	c.Convey("Given a message without options", t, func() {
+		msg := &Message{Type: Confirmable, Code: GET}
+
+		c.Convey("Then options are read as missing and deleting them does not panic", func() {
+			_, ok := msg.GetUint(MaxAge)
+			c.So(ok, c.ShouldBeFalse)
+			_, ok = msg.GetString(UriHost)
+			c.So(ok, c.ShouldBeFalse)
+			_, ok = msg.GetOpaque(ETag)
+			c.So(ok, c.ShouldBeFalse)
+			c.So(msg.GetStrings(UriQuery), c.ShouldBeNil)
+			c.So(msg.HasOption(Observe), c.ShouldBeFalse)
+			c.So(func() { msg.Del(Observe) }, c.ShouldNotPanic)
+			c.So(msg.Path(), c.ShouldEqual, "")
+			c.So(msg.URI(), c.ShouldEqual, "coap:///")
+		})
+	})
+}
@@ -1,6 +1,7 @@
 package coap
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -131,22 +132,22 @@ func TestOptionsAreParsedCorrectly(t *testing.T) {
 				c.So(err, c.ShouldBeNil)
 				c.So(m.String(), c.ShouldNotBeNil)
 			})
-			c.Convey("And message should have 4 options", func() {
-				c.So(len(*m.Options), c.ShouldEqual, 4)
+			c.Convey("And message should have 7 option values", func() {
+				c.So(len(*m.Options), c.ShouldEqual, 7)
 			})
 			c.Convey("And message should have Uri-Host to be set to 'localhost'", func() {
-				v, ok := (*m.Options)[UriHost]
-				c.So(ok, c.ShouldBeTrue)
+				v := m.Options.Get(UriHost)
+				c.So(v, c.ShouldNotBeEmpty)
 				c.So(string(v[0]), c.ShouldEqual, "localhost")
 			})
 			c.Convey("And message should have Uri-Path to be set to 'rd'", func() {
-				v, ok := (*m.Options)[UriPath]
-				c.So(ok, c.ShouldBeTrue)
+				v := m.Options.Get(UriPath)
+				c.So(v, c.ShouldNotBeEmpty)
 				c.So(string(v[0]), c.ShouldEqual, "rd")
 			})
 			c.Convey("And message should have Uri-Port to be set to 5683", func() {
-				v, ok := (*m.Options)[UriPort]
-				c.So(ok, c.ShouldBeTrue)
+				v := m.Options.Get(UriPort)
+				c.So(v, c.ShouldNotBeEmpty)
 				up, _ := ToBigEndianNumber(v[0])
 				c.So(up.(uint16), c.ShouldEqual, 5683)
 			})
@@ -158,8 +159,8 @@ func TestOptionsAreParsedCorrectly(t *testing.T) {
 						"0123456789012345678901234567890123456789012345678901234567890123456789012345678901234567890123456789",
 					}, "")
 
-				v, ok := (*m.Options)[UriQuery]
-				c.So(ok, c.ShouldBeTrue)
+				v := m.Options.Get(UriQuery)
+				c.So(v, c.ShouldNotBeEmpty)
 				c.ShouldEqual(string(v[3]), expected)
 			})
 		})
@@ -343,7 +344,8 @@ func TestParseOptionWithLongExtendedLength(t *testing.T) {
 			0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
 		}
 		c.Convey("When decoded", func() {
-			m, err := NewMessageFromBytes(b)
+			// the value exceeds the length defined for Uri-Query, hence it is decoded without registry
+			m, err := decode(b, nil, nil)
 			c.Convey("Then Uri-Query[3] should be as expected", func() {
 				expected := strings.Join(
 					[]string{
@@ -354,8 +356,8 @@ func TestParseOptionWithLongExtendedLength(t *testing.T) {
 
 				c.So(err, c.ShouldBeNil)
 
-				v, ok := (*m.Options)[UriQuery]
-				c.So(ok, c.ShouldBeTrue)
+				v := m.Options.Get(UriQuery)
+				c.So(v, c.ShouldNotBeEmpty)
 				c.ShouldEqual(string(v[3]), expected)
 			})
 		})
@@ -379,7 +381,7 @@ func TestMissingLongExtendedLengthBytesCauseError(t *testing.T) {
 }
 
 func TestInvalidOptionNumber(t *testing.T) {
-	c.Convey("Given a message with invalid option number", t, func() {
+	c.Convey("Given a message with unknown critical option number", t, func() {
 		/*
 			00000000  44 02 ec 8e 00 00 e8 17  39 6c 6f 63 61 6c 68 6f  |D.......9localho|
 			00000010  73 74 42 16 33 42 72 64  47 65 70 3d 61 6c 65 78  |stB.3BrdGep=alex|
@@ -388,13 +390,13 @@ func TestInvalidOptionNumber(t *testing.T) {
 		b := []byte{
 			0x44, 0x02, 0x5D, 0x28, 0x00, 0x00, 0x82, 0x1C, 0x39, 0x6C, 0x6F, 0x63, 0x61, 0x6C, 0x68, 0x6F,
 			0x73, 0x74, 0x42, 0x16, 0x33, 0x42, 0x72, 0x64, 0x47, 0x65, 0x70, 0x3D, 0x61, 0x6C, 0x65, 0x78,
-			0x03, 0x62, 0x3D, 0x55, 0x16, 0x6C, 0x74, 0x3D, 0x33, 0x30, 0x30,
+			0x03, 0x62, 0x3D, 0x55, 0xA6, 0x6C, 0x74, 0x3D, 0x33, 0x30, 0x30,
 		}
 		c.Convey("When message is decoded", func() {
 			_, err := NewMessageFromBytes(b)
 
 			c.Convey("Then error should show 'InvalidOptionNumber'", func() {
-				c.So(err, c.ShouldEqual, InvalidOptionNumber)
+				c.So(errors.Is(err, InvalidOptionNumber), c.ShouldBeTrue)
 			})
 		})
 	})
@@ -486,6 +488,24 @@ func TestEncodeDecodedMessageWithoutPayloadGivesTheSameByteContent(t *testing.T)
 	})
 }
 
+func TestEncodeDecodedMessageWithUnrecognizedElectiveOptionGivesTheSameByteContent(t *testing.T) {
+	c.Convey("Given a message with Uri-Path and the unrecognized elective option 2048", t, func() {
+		b := []byte{0x40, 0x01, 0x00, 0x00, 0xB1, 0x61, 0xE1, 0x06, 0xE8, 0x78}
+
+		c.Convey("When decoded", func() {
+			m, err := NewMessageFromBytes(b)
+
+			c.Convey("And encoded again", func() {
+				b2 := m.ToBytes()
+				c.Convey("Then the encoded message gives the same byte content", func() {
+					c.So(err, c.ShouldBeNil)
+					c.So(b2, c.ShouldResemble, b)
+				})
+			})
+		})
+	})
+}
+
 func TestEncodeDecodedMessageWithExtraLongOptionsGivesTheSameByteContent(t *testing.T) {
 	c.Convey("Given a message with a long option (1 byte option length)", t, func() {
 		b := []byte{
@@ -513,7 +533,8 @@ func TestEncodeDecodedMessageWithExtraLongOptionsGivesTheSameByteContent(t *test
 			0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
 		}
 		c.Convey("When decoded", func() {
-			m, _ := NewMessageFromBytes(b)
+			// the value exceeds the length defined for Uri-Query, hence it is decoded without registry
+			m, _ := decode(b, nil, nil)
 			c.Convey("And encoded again", func() {
 				b2 := m.ToBytes()
 				c.Convey("The byte content should be the same", func() {
@@ -564,6 +585,10 @@ func TestTokenType_Copy(t *testing.T) {
 			c.Convey("Then the resulting token matches exactly the given one", func() {
 				c.So(tkn2, c.ShouldResemble, tkn)
 			})
+			c.Convey("And changes to the copy do not affect the given one", func() {
+				tkn2[0] = 0x42
+				c.So(tkn[0], c.ShouldEqual, 0x13)
+			})
 		})
 	})
 }
@@ -574,8 +599,10 @@ func TestCodeType_String(t *testing.T) {
 			c.Convey(fmt.Sprintf("A string representation of code '%v' exists", code), func() {})
 		}
 
-		unknown := &CodeType{CodeClass: 13, CodeDetail: 37}
-		c.Convey(fmt.Sprintf("And unknown code type '%v' is handled properly", unknown), func() {})
+		unknown := &CodeType{CodeClass: 6, CodeDetail: 31}
+		c.Convey(fmt.Sprintf("And unknown code type '%v' is handled properly", unknown), func() {
+			c.So(unknown.String(), c.ShouldEqual, "6.31 (Unknown Code)")
+		})
 	})
 }
 
@@ -734,10 +761,250 @@ func TestMessage_InvalidContentFormat(t *testing.T) {
 		m.Payload.Content = []byte("some payload")
 
 		c.Convey("When message is decoded", func() {
-			_, err := NewMessageFromBytes(m.ToBytes())
+			msg, err := NewMessageFromBytes(m.ToBytes())
+
+			c.Convey("Then the content format is ignored and the message is invalid", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(msg.HasOption(ContentFormat), c.ShouldBeFalse)
+				c.So(msg.Validate(), c.ShouldEqual, BadRequest)
+			})
+		})
+	})
+}
+
+func TestMessage_DecodeWithoutCopy(t *testing.T) {
+	c.Convey("Given an encoded message with token, options and payload", t, func() {
+		b := NewConfirmableMessageBuilder().
+			Code(POST).
+			WithRandomMessageId().
+			WithRandomToken().
+			Option(UriPath, []byte("rd")).
+			WithPayload(ContentTypeTextPlain, []byte("payload")).
+			Build().ToBytes()
+
+		c.Convey("When decoded with copying and the packet is overwritten", func() {
+			msg, err := NewMessageFromBytes(b)
+			c.So(err, c.ShouldBeNil)
+			copy(b[4:], make([]byte, len(b)-4))
+
+			c.Convey("Then the message is unchanged", func() {
+				c.So(msg.Path(), c.ShouldEqual, "/rd")
+				c.So(string(msg.Payload.Content), c.ShouldEqual, "payload")
+			})
+		})
+
+		c.Convey("When decoded without copying and the packet is overwritten", func() {
+			msg, err := NewMessageFromBytesNoCopy(b)
+			c.So(err, c.ShouldBeNil)
+			c.So(msg.Path(), c.ShouldEqual, "/rd")
+			copy(b[len(b)-7:], "PAYLOAD")
+
+			c.Convey("Then the message references the packet", func() {
+				c.So(string(msg.Payload.Content), c.ShouldEqual, "PAYLOAD")
+			})
+		})
+	})
+}
+
+func TestMessage_AppendToAndMarshalTo(t *testing.T) {
+	c.Convey("Given a message", t, func() {
+		m := NewConfirmableMessageBuilder().
+			Code(GET).
+			WithRandomMessageId().
+			WithRandomToken().
+			Option(UriPath, []byte("rd")).
+			Build()
+
+		c.Convey("When appended to a buffer", func() {
+			b := m.AppendTo([]byte{0xAB})
+
+			c.Convey("Then the encoded message follows the contents of the buffer", func() {
+				c.So(b[0], c.ShouldEqual, 0xAB)
+				c.So(b[1:], c.ShouldResemble, m.ToBytes())
+			})
+		})
+
+		c.Convey("When marshaled to a buffer large enough", func() {
+			b := make([]byte, MaxPacketSize)
+			n, err := m.MarshalTo(b)
+
+			c.Convey("Then the encoded message is written to the buffer", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(b[:n], c.ShouldResemble, m.ToBytes())
+			})
+		})
+
+		c.Convey("When marshaled to a buffer too small", func() {
+			_, err := m.MarshalTo(make([]byte, 4))
 
 			c.Convey("Then an error is returned", func() {
-				c.So(err, c.ShouldNotBeNil)
+				c.So(err, c.ShouldEqual, BufferIsTooSmall)
+			})
+		})
+	})
+}
+
+func benchmarkMessage() []byte {
+	return NewConfirmableMessageBuilder().
+		Code(POST).
+		MessageId(0x1234).
+		Token(&TokenType{0xCA, 0xFE, 0xBA, 0xBE}).
+		Option(UriHost, []byte("localhost")).
+		Option(UriPath, []byte("sensors"), []byte("temp")).
+		Option(UriQuery, []byte("a=1")).
+		WithPayload(ContentTypeApplicationJson, []byte(`{"temp":21.5}`)).
+		Build().ToBytes()
+}
+
+func BenchmarkDecode(b *testing.B) {
+	packet := benchmarkMessage()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewMessageFromBytes(packet); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeNoCopy(b *testing.B) {
+	packet := benchmarkMessage()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewMessageFromBytesNoCopy(packet); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkToBytes(b *testing.B) {
+	msg, _ := NewMessageFromBytes(benchmarkMessage())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		msg.ToBytes()
+	}
+}
+
+func BenchmarkAppendTo(b *testing.B) {
+	msg, _ := NewMessageFromBytes(benchmarkMessage())
+	buffer := make([]byte, 0, MaxPacketSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buffer = msg.AppendTo(buffer[:0])
+	}
+}
+
+func TestMessage_MarshalBinary(t *testing.T) {
+	c.Convey("Given a valid message", t, func() {
+		m := NewConfirmableMessageBuilder().
+			Code(POST).
+			WithRandomMessageId().
+			WithRandomToken().
+			Option(UriPath, []byte("rd")).
+			WithPayload(ContentTypeTextPlain, []byte("payload")).
+			Build()
+
+		c.Convey("When marshaled and unmarshaled", func() {
+			b, err := m.MarshalBinary()
+			decoded := &Message{}
+			c.So(err, c.ShouldBeNil)
+			err = decoded.UnmarshalBinary(b)
+
+			c.Convey("Then the message is restored", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(decoded.Code, c.ShouldResemble, m.Code)
+				c.So(decoded.MessageID, c.ShouldEqual, m.MessageID)
+				c.So(decoded.Token, c.ShouldResemble, m.Token)
+				c.So(decoded.Options, c.ShouldResemble, m.Options)
+				c.So(decoded.Payload.Content, c.ShouldResemble, m.Payload.Content)
+				c.So(b, c.ShouldResemble, m.ToBytes())
+			})
+		})
+	})
+
+	c.Convey("Given messages which cannot be encoded", t, func() {
+		token := TokenType(make([]byte, 9))
+		invalid := map[error]*Message{
+			InvalidMessageType: {Type: 4, Code: GET, Token: &TokenType{}},
+			InvalidCode:        {Code: nil, Token: &TokenType{}},
+			InvalidTokenLength: {Code: GET, Token: &token},
+			OptionIsTooLong: {Code: GET, Token: &TokenType{}, Options: &OptionsType{
+				{UriQuery, make(OptionValueType, maxOptionLength+1)},
+			}},
+			EmptyMessageNotEmpty: {Code: EmptyMessage, Token: NewToken()},
+		}
+
+		c.Convey("Then marshaling reports the reason and ToBytes returns nil", func() {
+			for reason, m := range invalid {
+				_, err := m.MarshalBinary()
+				c.So(errors.Is(err, reason), c.ShouldBeTrue)
+				c.So(m.ToBytes(), c.ShouldBeNil)
+			}
+		})
+	})
+
+	c.Convey("Given a message without token", t, func() {
+		m := &Message{Type: Reset, Code: EmptyMessage, MessageID: 0x1234}
+
+		c.Convey("Then it is encoded with an empty token", func() {
+			b, err := m.MarshalBinary()
+			c.So(err, c.ShouldBeNil)
+			c.So(b, c.ShouldResemble, []byte{0x70, 0x00, 0x12, 0x34})
+		})
+	})
+}
+
+func TestMessage_Clone(t *testing.T) {
+	c.Convey("Given a message with token, options and payload", t, func() {
+		m := NewConfirmableMessageBuilder().
+			Code(POST).
+			WithRandomMessageId().
+			WithRandomToken().
+			Option(UriPath, []byte("sensors"), []byte("temp")).
+			WithPayload(ContentTypeTextPlain, []byte("payload")).
+			Build()
+		encoded := m.ToBytes()
+
+		c.Convey("When the message is cloned and the clone is changed", func() {
+			clone := m.Clone()
+			c.So(clone.ToBytes(), c.ShouldResemble, encoded)
+
+			clone.Code.CodeDetail = 3
+			(*clone.Token)[0] ^= 0xFF
+			clone.Options.Get(UriPath)[0][0] = 'S'
+			clone.Options.Add(UriQuery, OptionValueType("a=1"))
+			clone.Payload.Content[0] = 'P'
+			*clone.Payload.Type = ContentTypeApplicationJson
+
+			c.Convey("Then the message is unchanged", func() {
+				c.So(m.ToBytes(), c.ShouldResemble, encoded)
+				c.So(*m.Payload.Type, c.ShouldEqual, ContentTypeTextPlain)
+			})
+		})
+
+		c.Convey("When the token of the clone is appended to", func() {
+			clone := m.Clone()
+			*clone.Token = append(*clone.Token, 0x42)
+
+			c.Convey("Then the options of the clone are unchanged", func() {
+				c.So(clone.Path(), c.ShouldEqual, "/sensors/temp")
+			})
+		})
+	})
+}
+
+func TestEmptyMessageWithData(t *testing.T) {
+	c.Convey("Given an empty message with a token", t, func() {
+		b := []byte{0x41, 0x00, 0x30, 0x30, 0x30}
+
+		c.Convey("When decoded", func() {
+			_, err := NewMessageFromBytes(b)
+
+			c.Convey("Then a message format error is indicated", func() {
+				c.So(err, c.ShouldEqual, MessageFormatError)
 			})
 		})
 	})
@@ -0,0 +1,40 @@
+package coap
+
+// RepresentationFunc renders the representation of a resource for the request.
+type RepresentationFunc func(request *Message) ([]byte, error)
+
+// Representation of a resource in a specific content format.
+type Representation struct {
+	ContentType ContentType
+	Render      RepresentationFunc
+}
+
+// Selects the representation requested by the Accept option, the first representation
+// is taken without Accept. Returns false, if none matches.
+func (r *Resource) selectRepresentation(msg *Message) (Representation, bool) {
+	value, ok := msg.GetUint(Accept)
+	if !ok {
+		return r.Representations[0], true
+	}
+	accept := ContentType(value)
+	for _, representation := range r.Representations {
+		if representation.ContentType == accept {
+			return representation, true
+		}
+	}
+	return Representation{}, false
+}
+
+// Answers GET by rendering the representation negotiated using Accept (RFC 7252, section 5.10.4),
+// with Content-Format set accordingly. Answers 4.06 Not Acceptable, if none matches.
+func (r *Resource) negotiate(msg *Message) *Message {
+	representation, ok := r.selectRepresentation(msg)
+	if !ok {
+		return msg.Respond(NotAcceptable).message()
+	}
+	content, err := representation.Render(msg)
+	if err != nil {
+		return msg.Respond(InternalServerError).message()
+	}
+	return msg.Respond(Content).WithPayload(representation.ContentType, content).message()
+}
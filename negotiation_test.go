@@ -0,0 +1,147 @@
+package coap
+
+import (
+	"testing"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+func TestServer_ContentNegotiation(t *testing.T) {
+	c.Convey("Given a server with a resource in JSON and plain text", t, func() {
+		server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{
+			Path: "/temp",
+			Representations: []Representation{
+				{ContentTypeApplicationJson, func(request *Message) ([]byte, error) {
+					return []byte(`{"temp":21.5}`), nil
+				}},
+				{ContentTypeTextPlain, func(request *Message) ([]byte, error) {
+					return []byte("21.5"), nil
+				}},
+			},
+		})
+		get := func(accept ...OptionValueType) *Message {
+			builder := newProxyRequest(GET).Option(UriPath, OptionValueType("temp"))
+			if len(accept) > 0 {
+				builder = builder.Option(Accept, accept...)
+			}
+			return server.routeRequest(builder.Build())
+		}
+
+		c.Convey("When plain text is accepted", func() {
+			resp := get(uintOptionValue(uint64(ContentTypeTextPlain)))
+
+			c.Convey("Then the plain text representation is returned", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So(resp.Payload.Content, c.ShouldResemble, []byte("21.5"))
+				c.So(resp.Options.Get(ContentFormat)[0], c.ShouldResemble, uintOptionValue(uint64(ContentTypeTextPlain)))
+			})
+		})
+
+		c.Convey("When no Accept is given", func() {
+			resp := get()
+
+			c.Convey("Then the first representation is returned", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So(resp.Options.Get(ContentFormat)[0], c.ShouldResemble, uintOptionValue(ContentTypeApplicationJson))
+			})
+		})
+
+		c.Convey("When an unavailable content format is accepted", func() {
+			resp := get(uintOptionValue(ContentTypeApplicationXml))
+
+			c.Convey("Then 'Not Acceptable' is returned", func() {
+				c.So(*resp.Code, c.ShouldResemble, *NotAcceptable)
+			})
+		})
+
+		c.Convey("When representations are requested non-confirmably", func() {
+			request := func(accept ContentType) *Message {
+				return NewNonConfirmableMessageBuilder().
+					Code(GET).
+					MessageId(7).
+					Token(&TokenType{1}).
+					Option(UriPath, OptionValueType("temp")).
+					Option(Accept, uintOptionValue(uint64(accept))).
+					Build()
+			}
+			content := server.routeRequest(request(ContentTypeTextPlain))
+			notAcceptable := server.routeRequest(request(ContentTypeApplicationXml))
+
+			c.Convey("Then they are returned in non-confirmable responses", func() {
+				c.So(*content.Code, c.ShouldResemble, *Content)
+				c.So(content.Type, c.ShouldEqual, NonConfirmable)
+				c.So(*notAcceptable.Code, c.ShouldResemble, *NotAcceptable)
+				c.So(notAcceptable.Type, c.ShouldEqual, NonConfirmable)
+			})
+		})
+	})
+}
+
+func TestServer_ConditionalNegotiation(t *testing.T) {
+	c.Convey("Given a server with a resource in JSON and CBOR declaring its ETag", t, func() {
+		server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{
+			Path: "/temp",
+			Representations: []Representation{
+				{ContentTypeApplicationJson, func(request *Message) ([]byte, error) {
+					return []byte(`{"temp":21.5}`), nil
+				}},
+				{ContentTypeApplicationCbor, func(request *Message) ([]byte, error) {
+					return []byte{0xA1, 0x64, 't', 'e', 'm', 'p', 0xF9, 0x4D, 0x60}, nil
+				}},
+			},
+			OnPUT: func(request *Message) (*Message, error) {
+				return responseWithCode(request, Changed), nil
+			},
+			ETag: func() []byte {
+				return []byte{0x01}
+			},
+		})
+		request := func(code *CodeType, cType ContentType) messageTokenBuilder {
+			return newProxyRequest(code).Option(UriPath, OptionValueType("temp")).Option(Accept, uintOptionValue(uint64(cType)))
+		}
+		json := server.routeRequest(request(GET, ContentTypeApplicationJson).Build())
+		cbor := server.routeRequest(request(GET, ContentTypeApplicationCbor).Build())
+
+		c.Convey("Then the representations carry different ETags", func() {
+			c.So(json.Options.Get(ETag), c.ShouldHaveLength, 1)
+			c.So(cbor.Options.Get(ETag), c.ShouldHaveLength, 1)
+			c.So(json.Options.Get(ETag)[0], c.ShouldNotResemble, cbor.Options.Get(ETag)[0])
+		})
+
+		c.Convey("When CBOR is requested with the ETag of the JSON representation", func() {
+			resp := server.routeRequest(request(GET, ContentTypeApplicationCbor).Option(ETag, json.Options.Get(ETag)[0]).Build())
+
+			c.Convey("Then the CBOR representation is returned", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So(resp.Options.Get(ContentFormat)[0], c.ShouldResemble, uintOptionValue(ContentTypeApplicationCbor))
+			})
+		})
+
+		c.Convey("When CBOR is requested with its own ETag", func() {
+			resp := server.routeRequest(request(GET, ContentTypeApplicationCbor).Option(ETag, cbor.Options.Get(ETag)[0]).Build())
+
+			c.Convey("Then 'Valid' is returned", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Valid)
+				c.So(resp.Options.Get(ETag)[0], c.ShouldResemble, cbor.Options.Get(ETag)[0])
+			})
+		})
+
+		c.Convey("When an unavailable content format is requested with a matching ETag", func() {
+			resp := server.routeRequest(request(GET, ContentTypeApplicationXml).Option(ETag, json.Options.Get(ETag)[0]).Build())
+
+			c.Convey("Then 'Not Acceptable' is returned", func() {
+				c.So(*resp.Code, c.ShouldResemble, *NotAcceptable)
+			})
+		})
+
+		c.Convey("When the resource is updated with If-Match of either representation", func() {
+			fromJSON := server.routeRequest(newProxyRequest(PUT).Option(UriPath, OptionValueType("temp")).Option(IfMatch, json.Options.Get(ETag)[0]).Build())
+			fromCBOR := server.routeRequest(newProxyRequest(PUT).Option(UriPath, OptionValueType("temp")).Option(IfMatch, cbor.Options.Get(ETag)[0]).Build())
+
+			c.Convey("Then the precondition holds", func() {
+				c.So(*fromJSON.Code, c.ShouldResemble, *Changed)
+				c.So(*fromCBOR.Code, c.ShouldResemble, *Changed)
+			})
+		})
+	})
+}
@@ -0,0 +1,53 @@
+package coap
+
+// NoResponseType is the bitmask of response classes, which a client is not interested in (RFC 7967).
+type NoResponseType uint8
+
+const (
+	SuppressSuccess     NoResponseType = 2
+	SuppressClientError NoResponseType = 8
+	SuppressServerError NoResponseType = 16
+	SuppressAll                        = SuppressSuccess | SuppressClientError | SuppressServerError
+)
+
+// SuppressedResponses returns the response classes suppressed by the No-Response option of the request.
+func (m *Message) SuppressedResponses() NoResponseType {
+	if value, ok := m.GetUint(NoResponse); ok {
+		return NoResponseType(value)
+	}
+	return 0
+}
+
+// Suppresses checks whether a response with given code is suppressed.
+func (t NoResponseType) Suppresses(code *CodeType) bool {
+	switch code.CodeClass {
+	case 2:
+		return t&SuppressSuccess != 0
+	case 4:
+		return t&SuppressClientError != 0
+	case 5:
+		return t&SuppressServerError != 0
+	default:
+		return false
+	}
+}
+
+// NoResponse builder method asks the server to suppress responses of given classes.
+func (m messageTokenBuilder) NoResponse(t NoResponseType) messageTokenBuilder {
+	if t == 0 {
+		return m.Option(NoResponse, OptionValueType{})
+	}
+	return m.Option(NoResponse, OptionValueType{byte(t)})
+}
+
+// Applies the No-Response option of the request to the response: suppressed responses to
+// confirmable requests are replaced by an empty acknowledgement, others are dropped.
+func suppressResponse(request *Message, response *Message) *Message {
+	if !request.SuppressedResponses().Suppresses(response.Code) {
+		return response
+	}
+	if request.Type != Confirmable {
+		return nil
+	}
+	return NewAcknowledgementMessageBuilder().Code(EmptyMessage).MessageId(request.MessageID).Token(&TokenType{}).Build()
+}
@@ -0,0 +1,121 @@
+package coap
+
+import (
+	"net"
+	"testing"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNoResponseType_Suppresses(t *testing.T) {
+	c.Convey("Given a request suppressing success and server error responses", t, func() {
+		req := NewNonConfirmableMessageBuilder().
+			Code(POST).
+			WithRandomMessageId().
+			WithRandomToken().
+			NoResponse(SuppressSuccess | SuppressServerError).
+			Build()
+		suppressed := req.SuppressedResponses()
+
+		c.Convey("Then success and server error responses are suppressed", func() {
+			c.So(suppressed.Suppresses(Changed), c.ShouldBeTrue)
+			c.So(suppressed.Suppresses(InternalServerError), c.ShouldBeTrue)
+		})
+
+		c.Convey("And client error responses are not", func() {
+			c.So(suppressed.Suppresses(NotFound), c.ShouldBeFalse)
+		})
+	})
+}
+
+func TestServer_SuppressResponses(t *testing.T) {
+	c.Convey("Given a server with a telemetry resource", t, func() {
+		server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{
+			Path: "/rd",
+			OnPOST: func(request *Message) (*Message, error) {
+				return responseWithCode(request, Changed), nil
+			},
+		})
+		peer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4711}
+		request := func(mType MessageType, path string) *Message {
+			return NewMessageBuilderOfType(mType).
+				Code(POST).
+				WithRandomMessageId().
+				WithRandomToken().
+				Option(UriPath, []byte(path)).
+				NoResponse(SuppressSuccess).
+				Build()
+		}
+
+		c.Convey("When a non-confirmable request suppresses the response", func() {
+			resp := server.handleMessage(request(NonConfirmable, "rd").ToBytes(), peer, nil)
+
+			c.Convey("Then nothing is sent", func() {
+				c.So(resp, c.ShouldBeNil)
+			})
+		})
+
+		c.Convey("When a confirmable request suppresses the response", func() {
+			req := request(Confirmable, "rd")
+			resp, _ := NewMessageFromBytes(server.handleMessage(req.ToBytes(), peer, nil))
+
+			c.Convey("Then an empty acknowledgement is sent", func() {
+				c.So(resp.Type, c.ShouldEqual, Acknowledgement)
+				c.So(*resp.Code, c.ShouldResemble, *EmptyMessage)
+				c.So(resp.MessageID, c.ShouldEqual, req.MessageID)
+			})
+		})
+
+		c.Convey("When the response is of a class not suppressed", func() {
+			resp, _ := NewMessageFromBytes(server.handleMessage(request(NonConfirmable, "unknown").ToBytes(), peer, nil))
+
+			c.Convey("Then it is sent", func() {
+				c.So(*resp.Code, c.ShouldResemble, *NotFound)
+			})
+		})
+	})
+}
+
+func TestClient_DoWithNoResponse(t *testing.T) {
+	c.Convey("Given a peer counting received messages", t, func() {
+		conn, received := startTestPeer(func(msg *Message) []*Message {
+			if msg.Type == Confirmable {
+				return []*Message{NewAcknowledgementMessageBuilder().Code(EmptyMessage).MessageId(msg.MessageID).Token(&TokenType{}).Build()}
+			}
+			return nil
+		})
+		defer conn.Close()
+
+		client, _ := NewClientWithParameters(conn.LocalAddr().String(), testTransmissionParameters())
+		defer client.Close()
+
+		c.Convey("When a non-confirmable request suppresses all responses", func() {
+			resp, err := client.Do(NewNonConfirmableMessageBuilder().
+				Code(POST).
+				WithRandomMessageId().
+				WithRandomToken().
+				NoResponse(SuppressAll).
+				Build())
+
+			c.Convey("Then the client returns without waiting for a response", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(resp, c.ShouldBeNil)
+			})
+		})
+
+		c.Convey("When a confirmable request suppresses all responses", func() {
+			resp, err := client.Do(NewConfirmableMessageBuilder().
+				Code(POST).
+				WithRandomMessageId().
+				WithRandomToken().
+				NoResponse(SuppressAll).
+				Build())
+
+			c.Convey("Then the client returns after the acknowledgement", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(resp, c.ShouldBeNil)
+				c.So(received(), c.ShouldEqual, 1)
+			})
+		})
+	})
+}
@@ -60,3 +60,22 @@ func ToLittleEndianNumber(b []byte) (interface{}, error) {
 		return binary.LittleEndian.Uint64(b[0:8]), nil
 	}
 }
+
+// Encodes an unsigned integer option value using as few bytes as possible (zero has no bytes).
+func uintOptionValue(v uint64) OptionValueType {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
+
+// Decodes an unsigned integer option value, empty values are zero.
+func optionUint(v OptionValueType) uint64 {
+	var n uint64
+	for _, b := range v {
+		n = n<<8 | uint64(b)
+	}
+	return n
+}
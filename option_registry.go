@@ -0,0 +1,140 @@
+package coap
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"unicode/utf8"
+)
+
+var (
+	InvalidOptionDefinition = errors.New("invalid option definition")
+	ReservedOptionNumber    = errors.New("option number is reserved")
+	OptionValueTooShort     = errors.New("option value is too short")
+	OptionValueTooLong      = errors.New("option value is too long")
+	OptionValueNotMinimal   = errors.New("uint option value has leading zeros")
+	OptionValueNotUTF8      = errors.New("string option value is not valid utf-8")
+	OptionNotRepeatable     = errors.New("option is not repeatable")
+)
+
+// OptionError reports an option, which is unknown or violates its definition.
+// Servers answer requests with such critical options by 4.02 Bad Option.
+type OptionError struct {
+	Number OptionNumberType
+	Reason error
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("option %v: %v", e.Number, e.Reason)
+}
+
+func (e *OptionError) Unwrap() error {
+	return e.Reason
+}
+
+// OptionRegistry holds the definitions of the options known to a server or client.
+// Options of unknown numbers are ignored on decoding if elective, and rejected if critical.
+type OptionRegistry struct {
+	lock        sync.RWMutex
+	definitions map[OptionNumberType]OptionDefinition
+}
+
+// DefaultOptionRegistry holds the options defined by the OptionLookupTable. It is used for messages
+// decoded outside of a server or client, e.g. by NewMessageFromBytes.
+var DefaultOptionRegistry = &OptionRegistry{definitions: OptionLookupTable}
+
+// NewOptionRegistry creates a registry holding a copy of the predefined options.
+func NewOptionRegistry() *OptionRegistry {
+	r := &OptionRegistry{definitions: make(map[OptionNumberType]OptionDefinition)}
+	DefaultOptionRegistry.lock.RLock()
+	defer DefaultOptionRegistry.lock.RUnlock()
+	for number, definition := range DefaultOptionRegistry.definitions {
+		r.definitions[number] = definition
+	}
+	return r
+}
+
+// Define defines the option of given number, replacing any previous definition.
+// The C, U and N flags are derived from the number (RFC 7252, section 5.4.6).
+func (r *OptionRegistry) Define(number OptionNumberType, definition OptionDefinition) error {
+	switch number {
+	// Spec: 0 and the numbers once used by Block drafts are reserved.
+	case 0, 128, 132, 136, 140:
+		return ReservedOptionNumber
+	}
+	if definition.Name == "" || definition.MinLength < 0 || definition.MinLength > definition.MaxLength ||
+		(definition.Format == Empty && definition.MaxLength > 0) {
+		return InvalidOptionDefinition
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.definitions[number] = number.derive(definition)
+	return nil
+}
+
+// Lookup returns the definition of the option of given number.
+func (r *OptionRegistry) Lookup(number OptionNumberType) (OptionDefinition, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	definition, ok := r.definitions[number]
+	return definition, ok
+}
+
+// Validate checks the options against their definitions (RFC 7252, section 5.4), as required for
+// sending them: values must be within length bounds, uints minimal and strings valid UTF-8,
+// and only repeatable options may be repeated. Options unknown to the registry are not checked.
+func (r *OptionRegistry) Validate(options *OptionsType) error {
+	preceding := make(map[OptionNumberType]int)
+	for _, o := range *options {
+		if err := r.validate(o.Number, o.Value, preceding[o.Number], true); err != nil && err != InvalidOptionNumber {
+			return &OptionError{Number: o.Number, Reason: err}
+		}
+		preceding[o.Number]++
+	}
+	return nil
+}
+
+// Validates an option value, given the number of preceding values of the option.
+// Received uint values may have leading zeros, sent ones must be minimal.
+func (r *OptionRegistry) validate(number OptionNumberType, value OptionValueType, preceding int, sending bool) error {
+	definition, ok := r.Lookup(number)
+	switch {
+	case !ok:
+		return InvalidOptionNumber
+	case preceding > 0 && !definition.R:
+		return OptionNotRepeatable
+	case len(value) < definition.MinLength:
+		return OptionValueTooShort
+	case len(value) > definition.MaxLength:
+		return OptionValueTooLong
+	// Spec: a recipient MUST be prepared to process uint values with leading zero bytes.
+	case definition.Format == Uint && sending && len(value) > 0 && value[0] == 0:
+		return OptionValueNotMinimal
+	case definition.Format == String && !utf8.Valid(value):
+		return OptionValueNotUTF8
+	}
+	return nil
+}
+
+// IsCritical checks whether the option must be understood by the recipient.
+func (t OptionNumberType) IsCritical() bool {
+	return t&1 != 0
+}
+
+// IsUnsafe checks whether the option must be understood by a proxy forwarding the message.
+func (t OptionNumberType) IsUnsafe() bool {
+	return t&2 != 0
+}
+
+// IsNoCacheKey checks whether the option is not part of the cache key.
+func (t OptionNumberType) IsNoCacheKey() bool {
+	return t&0x1e == 0x1c
+}
+
+// Sets the C, U and N flags of the definition according to the option number.
+func (t OptionNumberType) derive(definition OptionDefinition) OptionDefinition {
+	definition.C = t.IsCritical()
+	definition.U = t.IsUnsafe()
+	definition.N = t.IsNoCacheKey()
+	return definition
+}
@@ -0,0 +1,202 @@
+package coap
+
+import (
+	"errors"
+	"testing"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOptionRegistry_Define(t *testing.T) {
+	c.Convey("Given a new option registry", t, func() {
+		registry := NewOptionRegistry()
+
+		c.Convey("When a vendor option is defined", func() {
+			err := registry.Define(65001, OptionDefinition{Name: "Vendor", Format: Opaque, MaxLength: 8})
+			definition, ok := registry.Lookup(65001)
+
+			c.Convey("Then its flags are derived from the number", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(ok, c.ShouldBeTrue)
+				c.So(definition.C, c.ShouldBeTrue)
+				c.So(definition.U, c.ShouldBeFalse)
+				c.So(definition.N, c.ShouldBeFalse)
+			})
+
+			c.Convey("And other registries don't know it", func() {
+				_, ok := DefaultOptionRegistry.Lookup(65001)
+				c.So(ok, c.ShouldBeFalse)
+			})
+		})
+
+		c.Convey("When invalid options are defined", func() {
+			c.Convey("Then they are rejected", func() {
+				c.So(registry.Define(128, OptionDefinition{Name: "Reserved"}), c.ShouldEqual, ReservedOptionNumber)
+				c.So(registry.Define(65002, OptionDefinition{Format: Uint}), c.ShouldEqual, InvalidOptionDefinition)
+				c.So(registry.Define(65002, OptionDefinition{Name: "X", MinLength: 2, MaxLength: 1}), c.ShouldEqual, InvalidOptionDefinition)
+			})
+		})
+	})
+
+	c.Convey("Given the predefined options", t, func() {
+		c.Convey("Then their flags match RFC 7252, table 4", func() {
+			c.So(OptionLookupTable[UriHost].C && OptionLookupTable[UriHost].U, c.ShouldBeTrue)
+			c.So(OptionLookupTable[Size1].N, c.ShouldBeTrue)
+			c.So(OptionLookupTable[Size2].N, c.ShouldBeTrue)
+			c.So(OptionLookupTable[Block2].C && OptionLookupTable[Block2].U, c.ShouldBeTrue)
+			c.So(OptionLookupTable[Observe].C, c.ShouldBeFalse)
+			c.So(OptionLookupTable[Observe].U, c.ShouldBeTrue)
+			c.So(OptionLookupTable[MaxAge].Default, c.ShouldEqual, 60)
+		})
+	})
+}
+
+func TestServer_DefineOption(t *testing.T) {
+	c.Convey("Given a request with a vendor option", t, func() {
+		packet := NewConfirmableMessageBuilder().
+			Code(GET).
+			MessageId(1).
+			Token(&TokenType{1}).
+			Option(UriPath, OptionValueType("temp")).
+			Option(65001, OptionValueType{0x2a}).
+			Option(65000, OptionValueType{0x01}).
+			Build().
+			ToBytes()
+
+		c.Convey("When it is decoded without the option defined", func() {
+			_, err := NewMessageFromBytes(packet)
+
+			c.Convey("Then the critical option is rejected", func() {
+				c.So(errors.Is(err, InvalidOptionNumber), c.ShouldBeTrue)
+			})
+		})
+
+		c.Convey("When it is decoded by a server defining the option", func() {
+			server, _ := NewInsecureCoapServerWithDefaultParameters()
+			c.So(server.DefineOption(65001, OptionDefinition{Name: "Vendor", Format: Opaque, MaxLength: 8}), c.ShouldBeNil)
+			msg, err := decode(packet, nil, server.options)
+
+			c.Convey("Then the option and the unknown elective option are kept", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(msg.Options.Get(65001), c.ShouldResemble, []OptionValueType{{0x2a}})
+				c.So(msg.Options.Get(65000), c.ShouldResemble, []OptionValueType{{0x01}})
+			})
+		})
+	})
+}
+
+func TestOptionRegistry_Validate(t *testing.T) {
+	c.Convey("Given options violating their definitions", t, func() {
+		invalid := map[error]OptionsType{
+			OptionValueTooShort:   {{UriHost, OptionValueType{}}},
+			OptionValueTooLong:    {{ETag, make(OptionValueType, 9)}},
+			OptionValueNotMinimal: {{MaxAge, OptionValueType{0, 60}}},
+			OptionValueNotUTF8:    {{UriPath, OptionValueType{0xff, 0xfe}}},
+			OptionNotRepeatable:   {{ContentFormat, OptionValueType{}}, {ContentFormat, OptionValueType{60}}},
+		}
+
+		c.Convey("Then validation reports the reason", func() {
+			for reason, options := range invalid {
+				err := DefaultOptionRegistry.Validate(&options)
+				c.So(errors.Is(err, reason), c.ShouldBeTrue)
+			}
+		})
+	})
+
+	c.Convey("Given valid options", t, func() {
+		options := OptionsType{{UriPath, OptionValueType("a")}, {UriPath, OptionValueType("b")}, {MaxAge, uintOptionValue(60)}, {IfNoneMatch, OptionValueType{}}}
+
+		c.Convey("Then they validate", func() {
+			c.So(DefaultOptionRegistry.Validate(&options), c.ShouldBeNil)
+		})
+	})
+}
+
+func TestDecodeOptions_Validation(t *testing.T) {
+	c.Convey("Given options received", t, func() {
+		decodeOptionsOf := func(options OptionsType) (OptionsType, error) {
+			decoded := OptionsType{}
+			_, err := decodeOptions(&decoded, encodeOptions(&options), DefaultOptionRegistry)
+			return decoded, err
+		}
+
+		c.Convey("When a critical option is repeated, but not repeatable", func() {
+			_, err := decodeOptionsOf(OptionsType{{UriHost, OptionValueType("a")}, {UriHost, OptionValueType("b")}})
+
+			c.Convey("Then an option error is returned", func() {
+				var optionError *OptionError
+				c.So(errors.As(err, &optionError), c.ShouldBeTrue)
+				c.So(optionError.Number, c.ShouldEqual, UriHost)
+				c.So(optionError.Reason, c.ShouldEqual, OptionNotRepeatable)
+			})
+		})
+
+		c.Convey("When an elective option is repeated, but not repeatable", func() {
+			decoded, err := decodeOptionsOf(OptionsType{{MaxAge, OptionValueType{10}}, {MaxAge, OptionValueType{20}}})
+
+			c.Convey("Then the supernumerary value is ignored", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(decoded.Get(MaxAge), c.ShouldResemble, []OptionValueType{{10}})
+			})
+		})
+
+		c.Convey("When an uint option has leading zeros", func() {
+			decoded, err := decodeOptionsOf(OptionsType{{UriPort, OptionValueType{0, 80}}})
+
+			c.Convey("Then it is accepted", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(optionUint(decoded.Get(UriPort)[0]), c.ShouldEqual, 80)
+			})
+		})
+	})
+}
+
+func TestServer_BadOption(t *testing.T) {
+	c.Convey("Given a server", t, func() {
+		server, _ := NewInsecureCoapServerWithDefaultParameters()
+		request := func(builder messageBuilder) []byte {
+			return builder.Code(GET).MessageId(7).Token(&TokenType{1}).Option(UriPath, OptionValueType{0xff}).Build().ToBytes()
+		}
+
+		c.Convey("When a confirmable request with invalid critical option is received", func() {
+			resp, _ := NewMessageFromBytes(server.handleMessage(request(NewConfirmableMessageBuilder()), nil, nil))
+
+			c.Convey("Then 'Bad Option' is returned", func() {
+				c.So(resp.Type, c.ShouldEqual, Acknowledgement)
+				c.So(*resp.Code, c.ShouldResemble, *BadOption)
+				c.So(resp.MessageID, c.ShouldEqual, 7)
+			})
+		})
+
+		c.Convey("When a non-confirmable request with invalid critical option is received", func() {
+			resp, _ := NewMessageFromBytes(server.handleMessage(request(NewNonConfirmableMessageBuilder()), nil, nil))
+
+			c.Convey("Then it is rejected by reset", func() {
+				c.So(resp.Type, c.ShouldEqual, Reset)
+				c.So(resp.MessageID, c.ShouldEqual, 7)
+			})
+		})
+
+		response := func(builder messageBuilder) []byte {
+			return builder.Code(Content).MessageId(7).Token(&TokenType{1}).Option(UriPath, OptionValueType{0xff}).Build().ToBytes()
+		}
+
+		c.Convey("When a confirmable response with invalid critical option is received", func() {
+			resp, _ := NewMessageFromBytes(server.handleMessage(response(NewConfirmableMessageBuilder()), nil, nil))
+
+			c.Convey("Then it is rejected by reset", func() {
+				c.So(resp.Type, c.ShouldEqual, Reset)
+				c.So(*resp.Code, c.ShouldResemble, *EmptyMessage)
+				c.So(resp.MessageID, c.ShouldEqual, 7)
+			})
+		})
+
+		c.Convey("When a non-confirmable response with invalid critical option is received", func() {
+			resp := server.handleMessage(response(NewNonConfirmableMessageBuilder()), nil, nil)
+
+			c.Convey("Then it is ignored", func() {
+				c.So(resp, c.ShouldBeNil)
+			})
+		})
+	})
+}
@@ -12,199 +12,298 @@ import (
 // Option value consists of arbitrary bytes.
 type OptionValueType []byte
 
-// Options are build as map:
-// OptionNumber : [ OptionValue1, OptionValue2, ...OptionValueN ]
-type OptionsType map[OptionNumberType][]OptionValueType
+// Option is a single option of a message.
+type Option struct {
+	Number OptionNumberType
+	Value  OptionValueType
+}
+
+// Options are kept sorted by option number, as they appear on the wire.
+// Repeated options keep the order they were added in:
+// [ {Number1, Value1}, {Number1, Value2}, {Number2, Value3}, ... ]
+// Nil options are read and deleted from as empty options.
+type OptionsType []Option
+
+var EmptyOptions = OptionsType{}
+
+// Get returns all values of the option, in order.
+func (opt *OptionsType) Get(number OptionNumberType) []OptionValueType {
+	var values []OptionValueType
+	if opt == nil {
+		return values
+	}
+	for _, o := range *opt {
+		if o.Number == number {
+			values = append(values, o.Value)
+		}
+	}
+	return values
+}
+
+// Returns the first value of the option, without allocating.
+func (opt *OptionsType) first(number OptionNumberType) (OptionValueType, bool) {
+	if opt == nil {
+		return nil, false
+	}
+	for _, o := range *opt {
+		if o.Number == number {
+			return o.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Has checks whether the option is present.
+func (opt *OptionsType) Has(number OptionNumberType) bool {
+	if opt == nil {
+		return false
+	}
+	for _, o := range *opt {
+		if o.Number == number {
+			return true
+		}
+	}
+	return false
+}
+
+// Add adds the values of the option after its present values, keeping options sorted by number.
+func (opt *OptionsType) Add(number OptionNumberType, values ...OptionValueType) {
+	i := len(*opt)
+	for i > 0 && (*opt)[i-1].Number > number {
+		i--
+	}
+	options := make(OptionsType, 0, len(*opt)+len(values))
+	options = append(options, (*opt)[:i]...)
+	for _, v := range values {
+		options = append(options, Option{Number: number, Value: v})
+	}
+	*opt = append(options, (*opt)[i:]...)
+}
+
+// Set replaces all values of the option by the given values.
+func (opt *OptionsType) Set(number OptionNumberType, values ...OptionValueType) {
+	opt.Del(number)
+	opt.Add(number, values...)
+}
+
+// Del removes all values of the option.
+func (opt *OptionsType) Del(number OptionNumberType) {
+	if opt == nil {
+		return
+	}
+	options := (*opt)[:0]
+	for _, o := range *opt {
+		if o.Number != number {
+			options = append(options, o)
+		}
+	}
+	*opt = options
+}
 
-var EmptyOptions = make(OptionsType)
+// Checks whether the options are sorted by number, which holds unless given as unsorted literal.
+func (opt OptionsType) isSorted() bool {
+	for i := 1; i < len(opt); i++ {
+		if opt[i].Number < opt[i-1].Number {
+			return false
+		}
+	}
+	return true
+}
 
-// decode option from message buffer and return the next position in the buffer
-func decodeOptions(options *OptionsType, buffer []byte) (int, error) {
+// decode option from message buffer and return the next position in the buffer.
+// Option numbers are checked against the given registry, unless it is nil.
+func decodeOptions(options *OptionsType, buffer []byte, registry *OptionRegistry) (int, error) {
 	var (
 		optionDelta  int
 		optionLength int
 		optionValue  []byte
+		// number and count of the option values preceding in the buffer
+		previous  OptionNumberType
+		preceding int
 	)
 	i := 0
 	for len(buffer) > i {
 		od := buffer[i] >> 4
 		ol := buffer[i] & 0xF
 
-		// figure out Option Delta
-		switch od {
-		case 13:
-			i++
-			if len(buffer) < i+1 {
-				return i, PacketIsTooShort
-			}
-			optionDelta = int(buffer[i]) + 13
-			i++
-
-		case 14:
-			i++
-			if len(buffer) < i+2 {
-				return i, PacketIsTooShort
-			}
-			optionDelta = int(binary.BigEndian.Uint16(buffer[i:i+2]) + 269)
-			i += 2
-
-		case 15:
+		if od == 15 {
 			// whole byte should be 0xFF, otherwise error
 			if ol != 0xF {
 				return i, MessageFormatError
-			} else {
-				// end of options detected
-				// Spec: "The presence of a marker followed by a zero-length payload MUST be processed as a
-				// message format error."
-				if len(buffer) == i+1 {
-					return i, MessageFormatError
-				}
-				return i, nil
-			}
-		default:
-			optionDelta += int(od)
-		}
-
-		// figure out Option Length
-		switch ol {
-
-		case 13:
-			i++
-			if len(buffer) < i+1 {
-				return i, PacketIsTooShort
 			}
-			optionLength = int(buffer[i]) + 13
-			i++
-
-		case 14:
-			i++
-			if len(buffer) < i+2 {
-				return i, PacketIsTooShort
+			// end of options detected
+			// Spec: "The presence of a marker followed by a zero-length payload MUST be processed as a
+			// message format error."
+			if len(buffer) == i+1 {
+				return i, MessageFormatError
 			}
-			optionLength = int(binary.BigEndian.Uint16(buffer[i:i+2]) + 269)
-			i += 2
+			return i, nil
+		}
+		i++
 
-		case 15:
-			// invalid value
-			return i, MessageFormatError
+		// figure out Option Delta, extended deltas are relative to the previous option as well
+		delta, n, err := decodeExtendedNum(od, buffer[i:])
+		if err != nil {
+			return i, err
+		}
+		optionDelta += delta
+		i += n
 
-		default:
-			optionLength = int(ol)
-			i++
+		// figure out Option Length
+		optionLength, n, err = decodeExtendedNum(ol, buffer[i:])
+		if err != nil {
+			return i, err
 		}
+		i += n
 
 		if len(buffer) < i+optionLength {
 			return i, PacketIsTooShort
 		}
-		optionValue = make([]byte, optionLength)
-		copy(optionValue, buffer[i:i+optionLength])
+		optionValue = buffer[i : i+optionLength : i+optionLength]
 		i += optionLength
 
-		// check if option is valid
-		// TODO: apply CUNR validation here.
 		optKey := OptionNumberType(optionDelta)
+		if optionDelta > 0xFFFF {
+			return i, MessageFormatError
+		}
 
-		// key exists?
-		if _, ok := OptionLookupTable[optKey]; ok {
-
-			// options map already contains the option?
-			if v, ok := (*options)[optKey]; ok {
-				(*options)[optKey] = append(v, optionValue)
-			} else {
-				(*options)[optKey] = []OptionValueType{optionValue}
+		if registry != nil {
+			if optKey != previous {
+				previous, preceding = optKey, 0
+			}
+			switch err := registry.validate(optKey, optionValue, preceding, false); {
+			case err == nil:
+			case optKey.IsCritical():
+				// Spec: unrecognized or invalid options of class "critical" cause the message to be rejected.
+				return i, &OptionError{Number: optKey, Reason: err}
+			case err == InvalidOptionNumber:
+				// Spec: unrecognized elective options are ignored by the recipient. They are kept,
+				// so that proxies forward them if safe to forward (RFC 7252, section 5.7.1).
+			default:
+				// Spec: elective options violating their definition MUST be silently ignored.
+				continue
 			}
-		} else {
-			return i, InvalidOptionNumber
 		}
+		*options = append(*options, Option{Number: optKey, Value: optionValue})
+		preceding++
 	}
 	return i, nil
 }
 
-func encodeNum(num uint16) (byte, []byte) {
+// Decodes option delta or length from its nibble and extended bytes,
+// returning the number of extended bytes consumed.
+func decodeExtendedNum(nibble byte, ext []byte) (int, int, error) {
+	switch nibble {
+	case 13:
+		if len(ext) < 1 {
+			return 0, 0, PacketIsTooShort
+		}
+		return int(ext[0]) + 13, 1, nil
+	case 14:
+		if len(ext) < 2 {
+			return 0, 0, PacketIsTooShort
+		}
+		return int(binary.BigEndian.Uint16(ext[0:2])) + 269, 2, nil
+	case 15:
+		// reserved for payload marker
+		return 0, 0, MessageFormatError
+	default:
+		return int(nibble), 0, nil
+	}
+}
+
+// Appends the option header byte and extended delta and length to dst.
+func appendOptionHeader(dst []byte, delta int, length int) []byte {
+	dn, dext := encodeNum(delta)
+	ln, lext := encodeNum(length)
+	dst = append(dst, dn<<4|ln)
+	dst = appendExtendedNum(dst, dn, dext)
+	return appendExtendedNum(dst, ln, lext)
+}
+
+// Encodes option delta or length into its nibble and the value of its extended bytes.
+func encodeNum(num int) (byte, int) {
 	switch {
 	case num < 13:
-		return byte(num), []byte{}
+		return byte(num), 0
 	case num < 269:
-		return 13, []byte{byte(num - 13)}
+		return 13, num - 13
 	default:
-		b := make([]byte, 2)
-		binary.BigEndian.PutUint16(b, uint16(num-269))
-		return 14, b
+		return 14, num - 269
 	}
 }
 
-func getOptionLengthBytes(opt OptionValueType) (byte, []byte) {
-	return encodeNum(uint16(len(opt)))
+func appendExtendedNum(dst []byte, nibble byte, ext int) []byte {
+	switch nibble {
+	case 13:
+		return append(dst, byte(ext))
+	case 14:
+		return append(dst, byte(ext>>8), byte(ext))
+	}
+	return dst
 }
 
-func getOptionDeltaBytes(opt OptionNumberType, prev OptionNumberType) (byte, []byte) {
-	optDelta := opt - prev
-	return encodeNum(uint16(optDelta))
+// Appends the encoded options to dst. Options are encoded in order, unless not sorted by number.
+func appendOptions(dst []byte, options *OptionsType) []byte {
+	opts := *options
+	if !opts.isSorted() {
+		opts = append(OptionsType(nil), opts...)
+		sort.SliceStable(opts, func(i, j int) bool { return opts[i].Number < opts[j].Number })
+	}
+	prev := 0
+	for _, o := range opts {
+		dst = appendOptionHeader(dst, int(o.Number)-prev, len(o.Value))
+		dst = append(dst, o.Value...)
+		prev = int(o.Number)
+	}
+	return dst
 }
 
 func encodeOptions(options *OptionsType) []byte {
-	var b bytes.Buffer
-
-	if len(*options) > 0 {
-
-		// to build proper delta, we sort the option numbers (map keys)
-		var optionNumbers []int
-		for k := range *options {
-			optionNumbers = append(optionNumbers, int(k))
-		}
-		sort.Ints(optionNumbers)
-
-		var optDeltaPrev OptionNumberType = 0
-		for _, i := range optionNumbers {
-			for _, j := range (*options)[OptionNumberType(i)] {
-				optDeltaByte, optDeltaBytes := getOptionDeltaBytes(OptionNumberType(i), optDeltaPrev)
-				optDeltaPrev = OptionNumberType(i)
-				optLenByte, optLenBytes := getOptionLengthBytes(j)
-				b.WriteByte((optDeltaByte << 4) + optLenByte)
-				if len(optDeltaBytes) > 0 {
-					b.Write(optDeltaBytes)
-				}
-				if len(optLenBytes) > 0 {
-					b.Write(optLenBytes)
-				}
-				b.Write(j)
-			}
-		}
-	}
-	return b.Bytes()
+	return appendOptions(nil, options)
 }
 
 func (t OptionNumberType) String() string {
-	return OptionLookupTable[t].Name
+	if definition, ok := DefaultOptionRegistry.Lookup(t); ok {
+		return definition.Name
+	}
+	return fmt.Sprintf("%d", uint16(t))
 }
 
 func (opt *OptionsType) String() string {
 	var b bytes.Buffer
-	for k, v := range *opt {
-		b.WriteString(fmt.Sprintf("'%v'=[", k))
-		for _, i := range v {
-			switch OptionLookupTable[k].Format {
-
-			case Empty:
-				b.WriteString("{},")
-
-			case Opaque:
-				b.WriteString("[")
-				b.WriteString(HexContent(i))
-				b.WriteString("],")
-
-			case String:
-				b.WriteString("\"")
-				b.WriteString(string(i))
-				b.WriteString("\",")
-
-			case Uint:
-				if n, err := ToBigEndianNumber(i); err == nil {
-					b.WriteString(fmt.Sprintf("%d", n))
-					b.WriteString(",")
-				}
+	for i, o := range *opt {
+		if i == 0 || (*opt)[i-1].Number != o.Number {
+			if i > 0 {
+				b.WriteString("] ")
 			}
+			b.WriteString(fmt.Sprintf("'%v'=[", o.Number))
 		}
+		definition, ok := DefaultOptionRegistry.Lookup(o.Number)
+		if !ok {
+			definition.Format = Opaque
+		}
+		switch definition.Format {
+
+		case Empty:
+			b.WriteString("{},")
+
+		case Opaque:
+			b.WriteString("[")
+			b.WriteString(HexContent(o.Value))
+			b.WriteString("],")
+
+		case String:
+			b.WriteString("\"")
+			b.WriteString(string(o.Value))
+			b.WriteString("\",")
+
+		case Uint:
+			b.WriteString(fmt.Sprintf("%d", optionUint(o.Value)))
+			b.WriteString(",")
+		}
+	}
+	if len(*opt) > 0 {
 		b.WriteString("] ")
 	}
 	return b.String()
@@ -220,6 +319,8 @@ const (
 	String
 )
 
+// OptionDefinition describes an option. The C, U and N flags are derived from the option number,
+// when the option is defined with an OptionRegistry.
 type OptionDefinition struct {
 	C       bool
 	U       bool
@@ -228,151 +329,78 @@ type OptionDefinition struct {
 	Name    string
 	Format  OptionFormat
 	Default interface{}
+	// MinLength and MaxLength bound the length of option values in bytes.
+	MinLength int
+	MaxLength int
 }
 
+// Option numbers of the predefined options.
 const (
 	IfMatch       OptionNumberType = iota + 1
 	UriHost                        = 3
 	ETag                           = 4
 	IfNoneMatch                    = 5
+	Observe                        = 6
 	UriPort                        = 7
 	LocationPath                   = 8
+	OSCORE                         = 9
 	UriPath                        = 11
 	ContentFormat                  = 12
 	MaxAge                         = 14
 	UriQuery                       = 15
+	HopLimit                       = 16
 	Accept                         = 17
+	QBlock1                        = 19
 	LocationQuery                  = 20
+	EDHOC                          = 21
+	Block2                         = 23
+	Block1                         = 27
+	Size2                          = 28
+	QBlock2                        = 31
 	ProxyUri                       = 35
 	ProxyScheme                    = 39
 	Size1                          = 60
+	Echo                           = 252
+	NoResponse                     = 258
+	RequestTag                     = 292
 )
 
-// Lookup table for possible options.
+// Lookup table for possible options, it backs the DefaultOptionRegistry.
 var OptionLookupTable = map[OptionNumberType]OptionDefinition{
-	IfMatch: {
-		C:      true,
-		U:      false,
-		N:      false,
-		R:      true,
-		Name:   "If-Match",
-		Format: Opaque,
-	},
-	UriHost: {
-		C:      true,
-		U:      true,
-		N:      false,
-		R:      false,
-		Name:   "Uri-Host",
-		Format: String,
-	},
-	ETag: {
-		C:      false,
-		U:      false,
-		N:      false,
-		R:      true,
-		Name:   "ETag",
-		Format: Opaque,
-	},
-	IfNoneMatch: {
-		C:      true,
-		U:      false,
-		N:      false,
-		R:      false,
-		Name:   "If-None-Match",
-		Format: Empty,
-	},
-	UriPort: {
-		C:      true,
-		U:      true,
-		N:      false,
-		R:      false,
-		Name:   "Uri-Port",
-		Format: Uint,
-	},
-	LocationPath: {
-		C:      false,
-		U:      false,
-		N:      false,
-		R:      true,
-		Name:   "Location-Path",
-		Format: String,
-	},
-	UriPath: {
-		C:      true,
-		U:      true,
-		N:      false,
-		R:      true,
-		Name:   "Uri-Path",
-		Format: String,
-	},
-	ContentFormat: {
-		C:      false,
-		U:      false,
-		N:      false,
-		R:      false,
-		Name:   "Content-Format",
-		Format: Uint,
-	},
-	MaxAge: {
-		C:       false,
-		U:       true,
-		N:       false,
-		R:       false,
-		Name:    "Max-Age",
-		Format:  Uint,
-		Default: 60,
-	},
-	UriQuery: {
-		C:      true,
-		U:      true,
-		N:      false,
-		R:      true,
-		Name:   "Uri-Query",
-		Format: String,
-	},
-	Accept: {
-		C:      true,
-		U:      false,
-		N:      false,
-		R:      false,
-		Name:   "Accept",
-		Format: Uint,
-	},
-	LocationQuery: {
-		C:      false,
-		U:      false,
-		N:      false,
-		R:      true,
-		Name:   "Location-Query",
-		Format: Uint,
-	},
-	ProxyUri: {
-		C:      true,
-		U:      true,
-		N:      false,
-		R:      false,
-		Name:   "Proxy-Uri",
-		Format: String,
-	},
-
-	ProxyScheme: {
-		C:      true,
-		U:      true,
-		N:      false,
-		R:      false,
-		Name:   "Proxy-Scheme",
-		Format: Uint,
-	},
-
-	Size1: {
-		C:      false,
-		U:      false,
-		N:      true,
-		R:      false,
-		Name:   "Size1",
-		Format: Uint,
-	},
+	IfMatch:       {R: true, Name: "If-Match", Format: Opaque, MaxLength: 8},
+	UriHost:       {Name: "Uri-Host", Format: String, MinLength: 1, MaxLength: 255},
+	ETag:          {R: true, Name: "ETag", Format: Opaque, MinLength: 1, MaxLength: 8},
+	IfNoneMatch:   {Name: "If-None-Match", Format: Empty},
+	Observe:       {Name: "Observe", Format: Uint, MaxLength: 3},
+	UriPort:       {Name: "Uri-Port", Format: Uint, MaxLength: 2},
+	LocationPath:  {R: true, Name: "Location-Path", Format: String, MaxLength: 255},
+	OSCORE:        {Name: "OSCORE", Format: Opaque, MaxLength: 255},
+	UriPath:       {R: true, Name: "Uri-Path", Format: String, MaxLength: 255},
+	ContentFormat: {Name: "Content-Format", Format: Uint, MaxLength: 2},
+	MaxAge:        {Name: "Max-Age", Format: Uint, Default: 60, MaxLength: 4},
+	UriQuery:      {R: true, Name: "Uri-Query", Format: String, MaxLength: 255},
+	HopLimit:      {Name: "Hop-Limit", Format: Uint, Default: 16, MinLength: 1, MaxLength: 1},
+	Accept:        {Name: "Accept", Format: Uint, MaxLength: 2},
+	QBlock1:       {Name: "Q-Block1", Format: Uint, MaxLength: 3},
+	LocationQuery: {R: true, Name: "Location-Query", Format: String, MaxLength: 255},
+	EDHOC:         {Name: "EDHOC", Format: Empty},
+	Block2:        {Name: "Block2", Format: Uint, MaxLength: 3},
+	Block1:        {Name: "Block1", Format: Uint, MaxLength: 3},
+	Size2:         {Name: "Size2", Format: Uint, MaxLength: 4},
+	QBlock2:       {R: true, Name: "Q-Block2", Format: Uint, MaxLength: 3},
+	ProxyUri:      {Name: "Proxy-Uri", Format: String, MinLength: 1, MaxLength: 1034},
+	ProxyScheme:   {Name: "Proxy-Scheme", Format: String, MinLength: 1, MaxLength: 255},
+	Size1:         {Name: "Size1", Format: Uint, MaxLength: 4},
+	Echo:          {Name: "Echo", Format: Opaque, MinLength: 1, MaxLength: 40},
+	NoResponse:    {Name: "No-Response", Format: Uint, MaxLength: 1},
+	RequestTag:    {R: true, Name: "Request-Tag", Format: Opaque, MaxLength: 8},
+}
+
+// Derives the C, U and N flags of the predefined options.
+func init() {
+	for number, definition := range OptionLookupTable {
+		OptionLookupTable[number] = number.derive(definition)
+	}
 }
 
 // option number is in uint16 range
@@ -25,3 +25,186 @@ func TestUriPathOptionToString(t *testing.T) {
 		})
 	})
 }
+
+func TestDecodeOptionsWithExtendedDeltaAndLength(t *testing.T) {
+	c.Convey("Given Echo and Request-Tag options with extended delta and length", t, func() {
+		options := OptionsType{
+			{UriPath, []byte("rd")},
+			{Echo, []byte("0123456789abcdef")},
+			{RequestTag, []byte{0x01}},
+			{RequestTag, []byte{}},
+		}
+
+		c.Convey("When encoded and decoded", func() {
+			decoded := OptionsType{}
+			_, err := decodeOptions(&decoded, encodeOptions(&options), DefaultOptionRegistry)
+
+			c.Convey("Then the options are preserved", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(decoded, c.ShouldResemble, options)
+			})
+		})
+	})
+}
+
+func TestOptionsType_AddSetDel(t *testing.T) {
+	c.Convey("Given options", t, func() {
+		options := OptionsType{}
+		options.Add(UriQuery, OptionValueType("a=1"))
+		options.Add(UriPath, OptionValueType("sensors"), OptionValueType("temp"))
+		options.Add(UriHost, OptionValueType("localhost"))
+		options.Add(UriQuery, OptionValueType("b=2"))
+
+		c.Convey("Then they are sorted by number, keeping the order of repeated options", func() {
+			c.So(options, c.ShouldResemble, OptionsType{
+				{UriHost, OptionValueType("localhost")},
+				{UriPath, OptionValueType("sensors")},
+				{UriPath, OptionValueType("temp")},
+				{UriQuery, OptionValueType("a=1")},
+				{UriQuery, OptionValueType("b=2")},
+			})
+			c.So(options.Get(UriQuery), c.ShouldResemble, []OptionValueType{OptionValueType("a=1"), OptionValueType("b=2")})
+			c.So(options.Has(UriPort), c.ShouldBeFalse)
+		})
+
+		c.Convey("When an option is set", func() {
+			options.Set(UriPath, OptionValueType("rd"))
+
+			c.Convey("Then its previous values are replaced", func() {
+				c.So(options.Get(UriPath), c.ShouldResemble, []OptionValueType{OptionValueType("rd")})
+				c.So(options[1], c.ShouldResemble, Option{UriPath, OptionValueType("rd")})
+			})
+		})
+
+		c.Convey("When an option is deleted", func() {
+			options.Del(UriQuery)
+
+			c.Convey("Then all its values are removed", func() {
+				c.So(options.Has(UriQuery), c.ShouldBeFalse)
+				c.So(len(options), c.ShouldEqual, 3)
+			})
+		})
+	})
+}
+
+func TestEncodeOptions_RoundTrip(t *testing.T) {
+	c.Convey("Given options received with repeated values and large option numbers", t, func() {
+		encoded := []byte{
+			0x33, 'f', 'o', 'o', // Uri-Host (3)
+			0x81, 'b', // Uri-Path (11)
+			0x01, 'a', // Uri-Path (11)
+			0xE0, 0xFE, 0xE7, // option 65535, the highest option number
+		}
+
+		c.Convey("When decoded and encoded again", func() {
+			decoded := OptionsType{}
+			_, err := decodeOptions(&decoded, encoded, nil)
+
+			c.Convey("Then the encoding is byte-exact", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(decoded.Get(UriPath), c.ShouldResemble, []OptionValueType{OptionValueType("b"), OptionValueType("a")})
+				c.So(decoded[3].Number, c.ShouldEqual, 65535)
+				c.So(encodeOptions(&decoded), c.ShouldResemble, encoded)
+			})
+		})
+	})
+
+	c.Convey("Given options not sorted by number", t, func() {
+		options := OptionsType{{UriPath, OptionValueType("a")}, {UriHost, OptionValueType("foo")}, {UriPath, OptionValueType("b")}}
+
+		c.Convey("When encoded", func() {
+			encoded := encodeOptions(&options)
+
+			c.Convey("Then they are encoded sorted, keeping the order of repeated options", func() {
+				c.So(encoded, c.ShouldResemble, []byte{0x33, 'f', 'o', 'o', 0x81, 'a', 0x01, 'b'})
+				c.So(options[0].Number, c.ShouldEqual, UriPath)
+			})
+		})
+	})
+}
+
+func BenchmarkEncodeOptions(b *testing.B) {
+	options := OptionsType{}
+	options.Add(UriHost, OptionValueType("localhost"))
+	options.Add(UriPath, NewLocationPathOption("/sensors/temp")...)
+	options.Add(ContentFormat, uintOptionValue(uint64(ContentTypeApplicationJson)))
+	options.Add(UriQuery, OptionValueType("a=1"), OptionValueType("b=2"))
+	buf := make([]byte, 0, 64)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf = appendOptions(buf[:0], &options)
+	}
+}
+
+func TestDecodeOptions_Malformed(t *testing.T) {
+	c.Convey("Given malformed options", t, func() {
+		malformed := map[string][]byte{
+			"payload marker without payload":            {0xB2, 'r', 'd', 0xFF},
+			"delta nibble 15 without length nibble 15":  {0xF0},
+			"length nibble 15":                          {0xBF, 'r'},
+			"option number beyond 65535":                {0xE0, 0xFE, 0xF4, 0x10},
+			"option number overflowing with two deltas": {0xE0, 0xFE, 0xE7, 0xD0, 0x00},
+			"extended delta cut off":                    {0xE0, 0xFE},
+			"value cut off":                             {0xB3, 'r', 'd'},
+		}
+
+		c.Convey("Then decoding fails", func() {
+			for _, b := range malformed {
+				_, err := decodeOptions(&OptionsType{}, b, nil)
+				c.So(err, c.ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestDecodeOptions_PayloadMarkerAfterExtendedDelta(t *testing.T) {
+	c.Convey("Given an option with extended delta followed by the payload marker", t, func() {
+		b := []byte{0xD0, 0x00, 0xFF, 0x01}
+
+		c.Convey("When decoded", func() {
+			options := OptionsType{}
+			pos, err := decodeOptions(&options, b, nil)
+
+			c.Convey("Then the options end at the payload marker", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(pos, c.ShouldEqual, 2)
+				c.So(options, c.ShouldResemble, OptionsType{{13, OptionValueType{}}})
+			})
+		})
+	})
+}
+
+func TestDecodeOptions_ExtendedDeltaNear65535(t *testing.T) {
+	c.Convey("Given options with two byte extended delta", t, func() {
+
+		c.Convey("When the delta yields option number 65535", func() {
+			options := OptionsType{}
+			_, err := decodeOptions(&options, []byte{0xE0, 0xFE, 0xF2}, nil)
+
+			c.Convey("Then the option is decoded", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(options, c.ShouldResemble, OptionsType{{65535, OptionValueType{}}})
+			})
+		})
+
+		c.Convey("When the delta exceeds 65535 without wrapping around", func() {
+			_, err := decodeOptions(&OptionsType{}, []byte{0xE0, 0xFF, 0xFF}, nil)
+
+			c.Convey("Then 'Message Format Error' is indicated by error", func() {
+				c.So(err, c.ShouldEqual, MessageFormatError)
+			})
+		})
+
+		c.Convey("When the extended delta follows another option", func() {
+			options := OptionsType{}
+			_, err := decodeOptions(&options, []byte{0xB0, 0xE0, 0xFE, 0xE7}, nil)
+
+			c.Convey("Then it is relative to that option", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(options, c.ShouldResemble, OptionsType{{UriPath, OptionValueType{}}, {65535, OptionValueType{}}})
+			})
+		})
+	})
+}
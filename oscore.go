@@ -0,0 +1,536 @@
+package coap
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pion/dtls/v2/pkg/crypto/ccm"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Parameters of AES-CCM-16-64-128 (COSE algorithm 10), the mandatory OSCORE AEAD algorithm.
+const (
+	oscoreAlgorithm   = 10
+	oscoreKeyLength   = 16
+	oscoreNonceLength = 13
+	oscoreTagLength   = 8
+)
+
+const (
+	// Spec: the sender sequence number MUST NOT exceed 2^40 - 1.
+	oscoreMaxSequenceNumber = 1<<40 - 1
+	oscoreMaxPIVLength      = 5
+	oscoreMaxIDLength       = oscoreNonceLength - 6
+	oscoreReplayWindowSize  = 32
+)
+
+// Number of sender sequence numbers, which are reserved at once, when persisted.
+const SequenceNumberPersistInterval = 64
+
+/* ERRORS */
+var (
+	InvalidOSCOREOption     = errors.New("invalid OSCORE option")
+	SecurityContextNotFound = errors.New("OSCORE security context not found")
+	ReplayDetected          = errors.New("OSCORE replay detected")
+	DecryptionFailed        = errors.New("OSCORE decryption failed")
+	UnprotectedResponse     = errors.New("OSCORE response is not protected")
+	SequenceNumberExhausted = errors.New("OSCORE sender sequence number exhausted")
+)
+
+// Options, which are not encrypted but kept in the outer message (class U).
+// Proxy-Uri is kept as a whole, so forward proxies should rather be addressed using
+// Proxy-Scheme, in order to have Uri-Path and Uri-Query encrypted.
+var oscoreOuterOptions = map[OptionNumberType]bool{
+	UriHost:     true,
+	UriPort:     true,
+	OSCORE:      true,
+	ProxyUri:    true,
+	ProxyScheme: true,
+}
+
+// OSCOREConfig holds the input parameters of an OSCORE security context (RFC 8613, section 3.2).
+type OSCOREConfig struct {
+	MasterSecret []byte
+	// MasterSalt is optional.
+	MasterSalt  []byte
+	SenderID    []byte
+	RecipientID []byte
+	// IDContext is optional, if set, it is sent as 'kid context' along with requests.
+	IDContext []byte
+
+	// LoadSequenceNumber optionally restores the persisted sender sequence number
+	// when the context is created.
+	LoadSequenceNumber func() (uint64, error)
+	// SaveSequenceNumber optionally persists the sender sequence number. It is called with
+	// the number below which all sequence numbers may have been used, before they are used.
+	SaveSequenceNumber func(uint64) error
+}
+
+// OSCOREContext is a security context shared with one peer.
+type OSCOREContext struct {
+	senderID     []byte
+	recipientID  []byte
+	idContext    []byte
+	senderKey    []byte
+	recipientKey []byte
+	commonIV     []byte
+	save         func(uint64) error
+
+	lock           sync.Mutex
+	sequenceNumber uint64
+	reserved       uint64
+	replay         replayWindow
+}
+
+// Sliding window over the sequence numbers received from the peer.
+type replayWindow struct {
+	initialized bool
+	highest     uint64
+	// bit n is set, if sequence number (highest - n) has been received
+	received uint32
+}
+
+// Value of the OSCORE option (RFC 8613, section 6.1).
+type oscoreOption struct {
+	piv           []byte
+	kid           []byte
+	hasKid        bool
+	kidContext    []byte
+	hasKidContext bool
+}
+
+// Request parameters, which are needed to protect and verify the response.
+type oscoreExchange struct {
+	kid   []byte
+	piv   []byte
+	nonce []byte
+}
+
+// NewOSCOREContext derives a new security context from the given configuration.
+func NewOSCOREContext(config OSCOREConfig) (*OSCOREContext, error) {
+	if len(config.MasterSecret) == 0 {
+		return nil, errors.New("master secret may not be empty")
+	}
+	if len(config.SenderID) > oscoreMaxIDLength || len(config.RecipientID) > oscoreMaxIDLength {
+		return nil, fmt.Errorf("sender and recipient ID may not be longer than %d bytes", oscoreMaxIDLength)
+	}
+	if bytes.Equal(config.SenderID, config.RecipientID) {
+		return nil, errors.New("sender and recipient ID must be different")
+	}
+
+	var err error
+	ctx := &OSCOREContext{
+		senderID:    append([]byte{}, config.SenderID...),
+		recipientID: append([]byte{}, config.RecipientID...),
+		idContext:   config.IDContext,
+		save:        config.SaveSequenceNumber,
+	}
+
+	if ctx.senderKey, err = deriveOSCORE(config, config.SenderID, "Key", oscoreKeyLength); err != nil {
+		return nil, err
+	}
+	if ctx.recipientKey, err = deriveOSCORE(config, config.RecipientID, "Key", oscoreKeyLength); err != nil {
+		return nil, err
+	}
+	if ctx.commonIV, err = deriveOSCORE(config, []byte{}, "IV", oscoreNonceLength); err != nil {
+		return nil, err
+	}
+
+	if config.LoadSequenceNumber != nil {
+		if ctx.sequenceNumber, err = config.LoadSequenceNumber(); err != nil {
+			return nil, err
+		}
+		ctx.reserved = ctx.sequenceNumber
+	}
+	return ctx, nil
+}
+
+// Derives a key or the common IV using HKDF-SHA-256 (RFC 8613, section 3.2.1).
+func deriveOSCORE(config OSCOREConfig, id []byte, kind string, length int) ([]byte, error) {
+	var idContext interface{}
+	if config.IDContext != nil {
+		idContext = cbor.ByteString(config.IDContext)
+	}
+	info, err := MarshalCBORDeterministic([]interface{}{cbor.ByteString(id), idContext, oscoreAlgorithm, kind, length})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, config.MasterSecret, config.MasterSalt, info), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Get string representation of the context, without revealing any keys
+func (ctx *OSCOREContext) String() string {
+	return fmt.Sprintf("OSCOREContext{ sender=%v, recipient=%v, idContext=%v }",
+		HexContent(ctx.senderID), HexContent(ctx.recipientID), HexContent(ctx.idContext))
+}
+
+// Allocates the next sender sequence number, persisting it beforehand if needed.
+func (ctx *OSCOREContext) nextSequenceNumber() (uint64, error) {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	if ctx.sequenceNumber > oscoreMaxSequenceNumber {
+		return 0, SequenceNumberExhausted
+	}
+	if ctx.save != nil && ctx.sequenceNumber >= ctx.reserved {
+		reserved := ctx.sequenceNumber + SequenceNumberPersistInterval
+		if err := ctx.save(reserved); err != nil {
+			return 0, err
+		}
+		ctx.reserved = reserved
+	}
+	seq := ctx.sequenceNumber
+	ctx.sequenceNumber++
+	return seq, nil
+}
+
+// Computes the AEAD nonce from the ID of the endpoint, which generated the partial IV.
+func (ctx *OSCOREContext) nonce(idPIV []byte, piv []byte) []byte {
+	nonce := make([]byte, oscoreNonceLength)
+	nonce[0] = byte(len(idPIV))
+	copy(nonce[oscoreNonceLength-oscoreMaxPIVLength-len(idPIV):], idPIV)
+	copy(nonce[oscoreNonceLength-len(piv):], piv)
+	for i := range nonce {
+		nonce[i] ^= ctx.commonIV[i]
+	}
+	return nonce
+}
+
+// Protects a request, returning the parameters needed to verify its response.
+func (ctx *OSCOREContext) protectRequest(req *Message) (*Message, *oscoreExchange, error) {
+	seq, err := ctx.nextSequenceNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	piv := encodePIV(seq)
+	exchange := &oscoreExchange{kid: ctx.senderID, piv: piv, nonce: ctx.nonce(ctx.senderID, piv)}
+
+	option := oscoreOption{
+		piv:           piv,
+		kid:           ctx.senderID,
+		hasKid:        true,
+		kidContext:    ctx.idContext,
+		hasKidContext: ctx.idContext != nil,
+	}
+	protected, err := protectOSCORE(req, POST, ctx.senderKey, option, exchange.nonce, oscoreAAD(exchange))
+	return protected, exchange, err
+}
+
+// Verifies and decrypts the response to a request protected before.
+// Unprotected responses are returned along with an error, as these may be error
+// responses of the peer's OSCORE layer, but are not authenticated.
+func (ctx *OSCOREContext) unprotectResponse(resp *Message, exchange *oscoreExchange, registry *OptionRegistry) (*Message, error) {
+	value := resp.Options.Get(OSCORE)
+	if len(value) == 0 {
+		return resp, UnprotectedResponse
+	}
+	option, err := decodeOSCOREOption(value[0])
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := exchange.nonce
+	if len(option.piv) > 0 {
+		nonce = ctx.nonce(ctx.recipientID, option.piv)
+	}
+	return unprotectOSCORE(resp, ctx.recipientKey, nonce, oscoreAAD(exchange), registry)
+}
+
+// Verifies and decrypts a request, which was addressed to this context by its kid.
+func (ctx *OSCOREContext) unprotectRequest(req *Message, option oscoreOption, registry *OptionRegistry) (*Message, *oscoreExchange, error) {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	seq := decodePIV(option.piv)
+	if ctx.replay.isReplay(seq) {
+		return nil, nil, ReplayDetected
+	}
+
+	exchange := &oscoreExchange{kid: option.kid, piv: option.piv, nonce: ctx.nonce(option.kid, option.piv)}
+	inner, err := unprotectOSCORE(req, ctx.recipientKey, exchange.nonce, oscoreAAD(exchange), registry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Spec: the replay window is only updated after successful verification.
+	ctx.replay.accept(seq)
+	return inner, exchange, nil
+}
+
+// Protects the response, reusing the nonce of the request.
+func (ctx *OSCOREContext) protectResponse(resp *Message, exchange *oscoreExchange) (*Message, error) {
+	return protectOSCORE(resp, Changed, ctx.senderKey, oscoreOption{}, exchange.nonce, oscoreAAD(exchange))
+}
+
+// Encrypts code, class E options and payload of the message into the payload of an outer message.
+func protectOSCORE(msg *Message, outerCode *CodeType, key []byte, option oscoreOption, nonce, aad []byte) (*Message, error) {
+	inner := OptionsType{}
+	outer := OptionsType{}
+	for _, o := range *msg.Options {
+		if oscoreOuterOptions[o.Number] {
+			outer = append(outer, o)
+		} else {
+			inner = append(inner, o)
+		}
+	}
+
+	plaintext := []byte{byte(msg.Code.CodeClass<<5) + byte(msg.Code.CodeDetail)}
+	plaintext = (&Message{Options: &inner, Payload: msg.Payload}).appendOptionsAndPayload(plaintext)
+
+	aead, err := newOSCOREAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	outer.Set(OSCORE, option.encode())
+
+	return &Message{
+		Type:      msg.Type,
+		Code:      outerCode,
+		MessageID: msg.MessageID,
+		Token:     msg.Token,
+		Source:    msg.Source,
+		Options:   &outer,
+		Payload:   &PayloadType{Content: aead.Seal(nil, nonce, plaintext, aad)},
+	}, nil
+}
+
+// Decrypts the payload of a protected message and restores the inner message.
+// The inner options are decoded against the given registry.
+func unprotectOSCORE(msg *Message, key []byte, nonce, aad []byte, registry *OptionRegistry) (*Message, error) {
+	if msg.Payload == nil {
+		return nil, DecryptionFailed
+	}
+
+	aead, err := newOSCOREAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, msg.Payload.Content, aad)
+	if err != nil || len(plaintext) == 0 {
+		return nil, DecryptionFailed
+	}
+
+	opts, payload, err := decodeOptionsAndPayload(plaintext[1:], registry)
+	if err != nil {
+		return nil, err
+	}
+	// only class U options are taken from the outer message, nested OSCORE is not supported
+	opts.Del(OSCORE)
+	for _, o := range *msg.Options {
+		if oscoreOuterOptions[o.Number] && o.Number != OSCORE {
+			opts.Add(o.Number, o.Value)
+		}
+	}
+
+	return &Message{
+		Type: msg.Type,
+		Code: &CodeType{
+			CodeClass:  CodeClassType(plaintext[0] >> 5),
+			CodeDetail: CodeDetailType(plaintext[0] & 31),
+		},
+		MessageID: msg.MessageID,
+		Token:     msg.Token,
+		Source:    msg.Source,
+		Identity:  msg.Identity,
+		Options:   opts,
+		Payload:   payload,
+	}, nil
+}
+
+func newOSCOREAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return ccm.NewCCM(block, oscoreTagLength, oscoreNonceLength)
+}
+
+// Additional authenticated data, the COSE Enc_structure (RFC 8613, section 5.4).
+func oscoreAAD(exchange *oscoreExchange) []byte {
+	externalAAD := mustMarshalCBOR([]interface{}{
+		1,
+		[]interface{}{oscoreAlgorithm},
+		cbor.ByteString(exchange.kid),
+		cbor.ByteString(exchange.piv),
+		cbor.ByteString(""),
+	})
+	return mustMarshalCBOR([]interface{}{"Encrypt0", cbor.ByteString(""), cbor.ByteString(externalAAD)})
+}
+
+// Spec: the partial IV is the sequence number in network byte order, using the least number of bytes.
+func encodePIV(seq uint64) []byte {
+	piv := []byte{byte(seq)}
+	for seq >>= 8; seq > 0; seq >>= 8 {
+		piv = append([]byte{byte(seq)}, piv...)
+	}
+	return piv
+}
+
+func decodePIV(piv []byte) uint64 {
+	var seq uint64
+	for _, b := range piv {
+		seq = seq<<8 | uint64(b)
+	}
+	return seq
+}
+
+func (o oscoreOption) encode() []byte {
+	if len(o.piv) == 0 && !o.hasKid && !o.hasKidContext {
+		return []byte{}
+	}
+	flags := byte(len(o.piv))
+	if o.hasKid {
+		flags |= 0x08
+	}
+	if o.hasKidContext {
+		flags |= 0x10
+	}
+
+	b := append([]byte{flags}, o.piv...)
+	if o.hasKidContext {
+		b = append(b, byte(len(o.kidContext)))
+		b = append(b, o.kidContext...)
+	}
+	if o.hasKid {
+		b = append(b, o.kid...)
+	}
+	return b
+}
+
+func decodeOSCOREOption(value []byte) (oscoreOption, error) {
+	var o oscoreOption
+	if len(value) == 0 {
+		return o, nil
+	}
+
+	flags := value[0]
+	n := int(flags & 0x07)
+	// reserved bits must be zero, partial IV length of 6 and 7 is reserved
+	if flags&0xE0 != 0 || n > oscoreMaxPIVLength {
+		return o, InvalidOSCOREOption
+	}
+
+	pos := 1
+	if len(value) < pos+n {
+		return o, InvalidOSCOREOption
+	}
+	o.piv = value[pos : pos+n]
+	pos += n
+
+	if flags&0x10 != 0 {
+		if len(value) < pos+1 || len(value) < pos+1+int(value[pos]) {
+			return o, InvalidOSCOREOption
+		}
+		s := int(value[pos])
+		o.kidContext = value[pos+1 : pos+1+s]
+		o.hasKidContext = true
+		pos += 1 + s
+	}
+
+	if flags&0x08 != 0 {
+		o.kid = value[pos:]
+		o.hasKid = true
+	} else if pos != len(value) {
+		return o, InvalidOSCOREOption
+	}
+	return o, nil
+}
+
+func (w *replayWindow) isReplay(seq uint64) bool {
+	if !w.initialized || seq > w.highest {
+		return false
+	}
+	diff := w.highest - seq
+	if diff >= oscoreReplayWindowSize {
+		// too old to tell, so it is rejected
+		return true
+	}
+	return w.received&(1<<diff) != 0
+}
+
+func (w *replayWindow) accept(seq uint64) {
+	switch {
+	case !w.initialized:
+		w.initialized = true
+		w.highest = seq
+		w.received = 1
+	case seq > w.highest:
+		if shift := seq - w.highest; shift < oscoreReplayWindowSize {
+			w.received = w.received<<shift | 1
+		} else {
+			w.received = 1
+		}
+		w.highest = seq
+	default:
+		w.received |= 1 << (w.highest - seq)
+	}
+}
+
+// AddOSCOREContext lets the server accept requests protected with the given context.
+// Contexts are looked up by the 'kid' of a request, which is the recipient ID of the context.
+func (s *Server) AddOSCOREContext(ctx *OSCOREContext) {
+	s.oscoreLock.Lock()
+	defer s.oscoreLock.Unlock()
+	s.oscore[string(ctx.recipientID)] = ctx
+}
+
+// RemoveOSCOREContext removes a security context added before.
+func (s *Server) RemoveOSCOREContext(ctx *OSCOREContext) {
+	s.oscoreLock.Lock()
+	defer s.oscoreLock.Unlock()
+	delete(s.oscore, string(ctx.recipientID))
+}
+
+// Verifies a protected request, routes the inner request and protects its response.
+func (s *Server) routeProtectedRequest(msg *Message) *Message {
+	option, err := decodeOSCOREOption(msg.Options.Get(OSCORE)[0])
+	if err != nil || !option.hasKid || len(option.piv) == 0 {
+		return NewBadOptionResponseMessage(msg)
+	}
+
+	s.oscoreLock.RLock()
+	ctx, ok := s.oscore[string(option.kid)]
+	s.oscoreLock.RUnlock()
+	if !ok {
+		logger.Debugf("%v: kid=%v", SecurityContextNotFound, HexContent(option.kid))
+		return NewUnauthorizedResponseMessage(msg)
+	}
+
+	inner, exchange, err := ctx.unprotectRequest(msg, option, s.options)
+	switch err {
+	case nil:
+	case ReplayDetected:
+		logger.Debugf("%v: piv=%v", err, HexContent(option.piv))
+		return NewUnauthorizedResponseMessage(msg)
+	default:
+		logger.Debug(err)
+		return NewBadRequestResponseMessage(msg)
+	}
+	logger.Debugf("protected request: %v", inner)
+
+	var resp *Message
+	if res := inner.Validate(); res != Ok {
+		resp = responseWithCode(inner, res)
+	} else {
+		resp = s.routeRequest(inner)
+	}
+
+	protected, err := ctx.protectResponse(resp, exchange)
+	if err != nil {
+		logger.Debug(err)
+		return NewInternalServerErrorResponseMessage(msg)
+	}
+	return protected
+}
@@ -0,0 +1,324 @@
+package coap
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+func fromHex(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+// Test vectors of RFC 8613, appendix C.1
+var (
+	testOSCOREClientConfig = OSCOREConfig{
+		MasterSecret: fromHex("0102030405060708090a0b0c0d0e0f10"),
+		MasterSalt:   fromHex("9e7ca92223786340"),
+		SenderID:     []byte{},
+		RecipientID:  []byte{0x01},
+	}
+	testOSCOREServerConfig = OSCOREConfig{
+		MasterSecret: fromHex("0102030405060708090a0b0c0d0e0f10"),
+		MasterSalt:   fromHex("9e7ca92223786340"),
+		SenderID:     []byte{0x01},
+		RecipientID:  []byte{},
+	}
+)
+
+func TestOSCOREContextDerivation(t *testing.T) {
+	c.Convey("Given the client configuration of RFC 8613, C.1.1", t, func() {
+		c.Convey("When the security context is derived", func() {
+			ctx, err := NewOSCOREContext(testOSCOREClientConfig)
+
+			c.Convey("Then keys and common IV equal the test vector", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(ctx.senderKey, c.ShouldResemble, fromHex("f0910ed7295e6ad4b54fc793154302ff"))
+				c.So(ctx.recipientKey, c.ShouldResemble, fromHex("ffb14e093c94c9cac9471648b4f98710"))
+				c.So(ctx.commonIV, c.ShouldResemble, fromHex("4622d4dd6d944168eefb54987c"))
+			})
+
+			c.Convey("And the nonces equal the test vector", func() {
+				c.So(ctx.nonce(ctx.senderID, []byte{0x00}), c.ShouldResemble, fromHex("4622d4dd6d944168eefb54987c"))
+				c.So(ctx.nonce(ctx.recipientID, []byte{0x00}), c.ShouldResemble, fromHex("4722d4dd6d944169eefb54987c"))
+			})
+		})
+	})
+}
+
+func TestOSCOREContextWithEqualIDs(t *testing.T) {
+	c.Convey("Given a configuration with equal sender and recipient ID", t, func() {
+		config := testOSCOREClientConfig
+		config.RecipientID = []byte{}
+
+		c.Convey("When the security context is derived", func() {
+			_, err := NewOSCOREContext(config)
+
+			c.Convey("Then an error is returned", func() {
+				c.So(err, c.ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestOSCOREProtectRequest(t *testing.T) {
+	c.Convey("Given the client context and request of RFC 8613, C.4", t, func() {
+		ctx, _ := NewOSCOREContext(testOSCOREClientConfig)
+		ctx.sequenceNumber = 20
+		req, _ := NewMessageFromBytes(fromHex("44015d1f00003974396c6f63616c686f737483747631"))
+
+		c.Convey("When the request is protected", func() {
+			protected, exchange, err := ctx.protectRequest(req)
+
+			c.Convey("Then the protected request equals the test vector", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(exchange.piv, c.ShouldResemble, []byte{0x14})
+				c.So(exchange.nonce, c.ShouldResemble, fromHex("4622d4dd6d944168eefb549868"))
+				c.So(oscoreAAD(exchange), c.ShouldResemble, fromHex("8368456e63727970743040488501810a40411440"))
+				c.So(protected.ToBytes(), c.ShouldResemble,
+					fromHex("44025d1f00003974396c6f63616c686f7374620914ff612f1092f1776f1c1668b3825e"))
+			})
+		})
+	})
+}
+
+func TestOSCOREServerProcessing(t *testing.T) {
+	c.Convey("Given a server with the server context of RFC 8613, C.1.2", t, func() {
+		server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{
+			Path: "/tv1",
+			OnGET: func(request *Message) (*Message, error) {
+				return &Message{
+					Type:      Acknowledgement,
+					Code:      Content,
+					MessageID: request.MessageID,
+					Token:     request.Token,
+					Options:   &OptionsType{},
+					Payload:   &PayloadType{Content: []byte("Hello World!")},
+				}, nil
+			},
+		})
+		ctx, _ := NewOSCOREContext(testOSCOREServerConfig)
+		server.AddOSCOREContext(ctx)
+
+		req, _ := NewMessageFromBytes(fromHex("44025d1f00003974396c6f63616c686f7374620914ff612f1092f1776f1c1668b3825e"))
+
+		c.Convey("When the protected request of C.4 is routed", func() {
+			resp := server.routeRequest(req)
+
+			c.Convey("Then the protected response equals the test vector of C.7", func() {
+				c.So(resp.ToBytes(), c.ShouldResemble,
+					fromHex("64445d1f0000397490ffdbaad1e9a7e7b2a813d3c31524378303cdafae119106"))
+			})
+
+			c.Convey("And a replay of the request is rejected", func() {
+				replay, _ := NewMessageFromBytes(fromHex("44025d1f00003974396c6f63616c686f7374620914ff612f1092f1776f1c1668b3825e"))
+				c.So(*server.routeRequest(replay).Code, c.ShouldResemble, *Unauthorized)
+			})
+		})
+
+		c.Convey("When a request with manipulated ciphertext is routed", func() {
+			req.Payload.Content[0] ^= 0xFF
+			resp := server.routeRequest(req)
+
+			c.Convey("Then it is rejected as bad request", func() {
+				c.So(*resp.Code, c.ShouldResemble, *BadRequest)
+			})
+		})
+
+		c.Convey("When a request with unknown kid is routed", func() {
+			req.Options.Set(OSCORE, OptionValueType{0x09, 0x14, 0x42})
+			resp := server.routeRequest(req)
+
+			c.Convey("Then it is rejected as unauthorized", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Unauthorized)
+			})
+		})
+	})
+}
+
+func TestOSCOREClientUnprotectResponse(t *testing.T) {
+	c.Convey("Given the client context after sending the request of RFC 8613, C.4", t, func() {
+		ctx, _ := NewOSCOREContext(testOSCOREClientConfig)
+		ctx.sequenceNumber = 20
+		req, _ := NewMessageFromBytes(fromHex("44015d1f00003974396c6f63616c686f737483747631"))
+		_, exchange, _ := ctx.protectRequest(req)
+
+		c.Convey("When the protected response of C.7 is received", func() {
+			resp, _ := NewMessageFromBytes(fromHex("64445d1f0000397490ffdbaad1e9a7e7b2a813d3c31524378303cdafae119106"))
+			inner, err := ctx.unprotectResponse(resp, exchange, DefaultOptionRegistry)
+
+			c.Convey("Then the inner response is restored", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(*inner.Code, c.ShouldResemble, *Content)
+				c.So(inner.Payload.Content, c.ShouldResemble, []byte("Hello World!"))
+			})
+		})
+
+		c.Convey("When an unprotected response is received", func() {
+			resp := NewUnauthorizedResponseMessage(req)
+			_, err := ctx.unprotectResponse(resp, exchange, DefaultOptionRegistry)
+
+			c.Convey("Then 'Unprotected Response' is indicated by error", func() {
+				c.So(err, c.ShouldEqual, UnprotectedResponse)
+			})
+		})
+	})
+}
+
+func TestOSCOREOptionEncoding(t *testing.T) {
+	c.Convey("Given an OSCORE option with partial IV, kid context and kid", t, func() {
+		option := oscoreOption{
+			piv:           []byte{0x05},
+			kid:           []byte{0x42},
+			hasKid:        true,
+			kidContext:    []byte{0xCA, 0xFE},
+			hasKidContext: true,
+		}
+
+		c.Convey("When encoded and decoded", func() {
+			b := option.encode()
+			decoded, err := decodeOSCOREOption(b)
+
+			c.Convey("Then the option is preserved", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(b, c.ShouldResemble, []byte{0x19, 0x05, 0x02, 0xCA, 0xFE, 0x42})
+				c.So(decoded, c.ShouldResemble, option)
+			})
+		})
+
+		c.Convey("When reserved flag bits are set", func() {
+			_, err := decodeOSCOREOption([]byte{0x80})
+
+			c.Convey("Then 'Invalid OSCORE Option' is indicated by error", func() {
+				c.So(err, c.ShouldEqual, InvalidOSCOREOption)
+			})
+		})
+	})
+}
+
+func TestOSCOREReplayWindow(t *testing.T) {
+	c.Convey("Given a replay window with some received sequence numbers", t, func() {
+		var w replayWindow
+		for _, seq := range []uint64{1, 3, 40} {
+			w.accept(seq)
+		}
+
+		c.Convey("Then received numbers are replays", func() {
+			c.So(w.isReplay(40), c.ShouldBeTrue)
+		})
+		c.Convey("And numbers within the window not yet received are fresh", func() {
+			c.So(w.isReplay(39), c.ShouldBeFalse)
+			c.So(w.isReplay(41), c.ShouldBeFalse)
+		})
+		c.Convey("And numbers below the window are rejected", func() {
+			c.So(w.isReplay(3), c.ShouldBeTrue)
+			c.So(w.isReplay(2), c.ShouldBeTrue)
+		})
+	})
+}
+
+func TestOSCORESequenceNumberPersistence(t *testing.T) {
+	c.Convey("Given a context with persisted sequence number", t, func() {
+		var saved []uint64
+		config := testOSCOREClientConfig
+		config.LoadSequenceNumber = func() (uint64, error) { return 100, nil }
+		config.SaveSequenceNumber = func(seq uint64) error {
+			saved = append(saved, seq)
+			return nil
+		}
+		ctx, _ := NewOSCOREContext(config)
+
+		c.Convey("When sequence numbers are allocated", func() {
+			first, _ := ctx.nextSequenceNumber()
+			for i := 0; i < SequenceNumberPersistInterval; i++ {
+				ctx.nextSequenceNumber()
+			}
+
+			c.Convey("Then numbering continues at the persisted number", func() {
+				c.So(first, c.ShouldEqual, 100)
+			})
+			c.Convey("And the reserved bound is saved before use", func() {
+				c.So(saved, c.ShouldResemble, []uint64{100 + SequenceNumberPersistInterval, 100 + 2*SequenceNumberPersistInterval})
+			})
+		})
+	})
+}
+
+func TestClientWithOSCORE(t *testing.T) {
+	c.Convey("Given a server accepting OSCORE and a client using it", t, func() {
+		server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{
+			Path: "/tv1",
+			OnGET: func(request *Message) (*Message, error) {
+				return NewAcknowledgementMessageBuilder().
+					Code(Content).
+					MessageId(request.MessageID).
+					Token(request.Token).
+					WithPayload(ContentTypeTextPlain, []byte("Hello World!")).
+					Build(), nil
+			},
+		})
+		serverCtx, _ := NewOSCOREContext(testOSCOREServerConfig)
+		server.AddOSCOREContext(serverCtx)
+
+		conn, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		go server.Serve(conn)
+		defer conn.Close()
+
+		client, _ := NewClient(conn.LocalAddr().String())
+		defer client.Close()
+		clientCtx, _ := NewOSCOREContext(testOSCOREClientConfig)
+		client.UseOSCORE(clientCtx)
+
+		c.Convey("When a request is sent", func() {
+			resp, err := client.Do(NewConfirmableMessageBuilder().
+				Code(GET).
+				WithRandomMessageId().
+				WithRandomToken().
+				Option(UriPath, []byte("tv1")).
+				Build())
+
+			c.Convey("Then the decrypted response is returned", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So(resp.Payload.Content, c.ShouldResemble, []byte("Hello World!"))
+			})
+		})
+	})
+}
+
+func TestOSCOREServerDefinedInnerOptions(t *testing.T) {
+	c.Convey("Given a server accepting OSCORE, which defines a critical option", t, func() {
+		server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{
+			Path: "/tv1",
+			OnGET: func(request *Message) (*Message, error) {
+				vendor, _ := request.GetOpaque(65001)
+				return request.Respond(Content).WithPayload(ContentTypeTextPlain, vendor).message(), nil
+			},
+		})
+		c.So(server.DefineOption(65001, OptionDefinition{Name: "Vendor", Format: Opaque, MaxLength: 8}), c.ShouldBeNil)
+		serverCtx, _ := NewOSCOREContext(testOSCOREServerConfig)
+		server.AddOSCOREContext(serverCtx)
+		clientCtx, _ := NewOSCOREContext(testOSCOREClientConfig)
+
+		c.Convey("When a request protecting the option is routed", func() {
+			protected, exchange, err := clientCtx.protectRequest(NewConfirmableMessageBuilder().
+				Code(GET).
+				WithRandomMessageId().
+				WithRandomToken().
+				Option(UriPath, []byte("tv1")).
+				Option(65001, []byte("acme")).
+				Build())
+			c.So(err, c.ShouldBeNil)
+			resp, err := clientCtx.unprotectResponse(server.routeRequest(protected), exchange, DefaultOptionRegistry)
+
+			c.Convey("Then the inner option is decoded against the options of the server", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So(resp.Payload.Content, c.ShouldResemble, []byte("acme"))
+			})
+		})
+	})
+}
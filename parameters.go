@@ -2,6 +2,7 @@ package coap
 
 import (
 	"fmt"
+	"math/rand"
 	"time"
 )
 
@@ -28,6 +29,17 @@ func DefaultTransmissionParameters() TransmissionParameters {
 	return *p
 }
 
+// MaxTransmitWait is the maximum time from the first transmission of a confirmable message
+// to the time when the sender gives up on receiving an acknowledgement or reset.
+func (p TransmissionParameters) MaxTransmitWait() time.Duration {
+	return time.Duration(float64(p.AckTimeout) * float64(int(1)<<uint(p.MaxRetransmit+1)-1) * p.AckRandomFactor)
+}
+
+// Initial timeout of a confirmable message, randomized between AckTimeout and AckTimeout * AckRandomFactor.
+func (p TransmissionParameters) initialTimeout() time.Duration {
+	return time.Duration(float64(p.AckTimeout) * (1 + rand.Float64()*(p.AckRandomFactor-1)))
+}
+
 //func ValidateParameters(params *TransmissionParameters) {
 //	logger := slf4go.GetLogger("transmission")
 //	logger.Debugf("parameters: %v", params)
@@ -0,0 +1,189 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ProxyFunc forwards a request to the target URI and returns the response to the request.
+// The context is done, when the client has stopped waiting for the response.
+type ProxyFunc func(ctx context.Context, request *Message, target *url.URL) (*Message, error)
+
+// AddProxy enables proxying of requests carrying Proxy-Uri or Proxy-Scheme with the given scheme.
+// Requests for schemes not enabled are answered with 5.05 Proxying Not Supported.
+func (s *Server) AddProxy(scheme string, proxy ProxyFunc) {
+	s.proxies[strings.ToLower(scheme)] = proxy
+}
+
+// RemoveProxy disables proxying of requests with the given scheme.
+func (s *Server) RemoveProxy(scheme string) {
+	delete(s.proxies, strings.ToLower(scheme))
+}
+
+// Origin server, requests below a path prefix are forwarded to by a reverse proxy.
+type reverseProxy struct {
+	origin *url.URL
+	proxy  ProxyFunc
+}
+
+// AddReverseProxy forwards requests for paths below the prefix to the origin URI using the proxy,
+// e.g. with prefix "/sensors" and origin "coap://device/s", "/sensors/temp" is served
+// from "coap://device/s/temp". Resources of the server take precedence.
+func (s *Server) AddReverseProxy(prefix string, origin string, proxy ProxyFunc) error {
+	if prefix == "" || prefix[0:1] != "/" {
+		return errors.New("prefix may not be empty and must start with slash")
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if !u.IsAbs() || u.Host == "" {
+		return errors.New("origin must be an absolute URI")
+	}
+	s.reverseProxies[strings.TrimSuffix(prefix, "/")] = reverseProxy{origin: u, proxy: proxy}
+	return nil
+}
+
+// RemoveReverseProxy stops forwarding requests for paths below the prefix.
+func (s *Server) RemoveReverseProxy(prefix string) {
+	delete(s.reverseProxies, strings.TrimSuffix(prefix, "/"))
+}
+
+// Forwards the request to the origin of the longest prefix matching the path,
+// returns nil if there is none.
+func (s *Server) routeReverseProxyRequest(msg *Message, path string) *Message {
+	prefix, reverse, found := s.reverseProxyOf(path)
+	if !found {
+		return nil
+	}
+
+	// the segments following the prefix are escaped, so that slashes within segments are kept
+	segments := msg.Options.Get(UriPath)
+	n := strings.Count(prefix, "/")
+	if n > len(segments) {
+		n = len(segments)
+	}
+	rawPath := strings.TrimSuffix(reverse.origin.EscapedPath(), "/")
+	for _, segment := range segments[n:] {
+		rawPath += "/" + escapeURIComponent(string(segment), ":@")
+	}
+
+	target := *reverse.origin
+	target.Path, _ = url.PathUnescape(rawPath)
+	target.RawPath = rawPath
+	target.RawQuery = uriQuery(msg)
+	return s.forwardRequest(msg, &target, reverse.proxy)
+}
+
+// Finds the reverse proxy with the longest prefix matching the path.
+func (s *Server) reverseProxyOf(path string) (prefix string, reverse reverseProxy, found bool) {
+	for p, r := range s.reverseProxies {
+		if (path == p || strings.HasPrefix(path, p+"/")) && (!found || len(p) > len(prefix)) {
+			prefix, reverse, found = p, r, true
+		}
+	}
+	return
+}
+
+// Checks whether the request is forwarded by a proxy or reverse proxy, instead of being served locally.
+func (s *Server) isProxyRequest(msg *Message) bool {
+	if msg.HasOption(OSCORE) {
+		return false
+	}
+	if msg.HasOption(ProxyUri) || msg.HasOption(ProxyScheme) {
+		return true
+	}
+	pathOption := msg.Options.Get(UriPath)
+	if len(pathOption) == 0 {
+		return false
+	}
+	path := UriPathOptionToString(pathOption)
+	if _, ok := s.resources[path]; ok {
+		return false
+	}
+	_, _, found := s.reverseProxyOf(path)
+	return found
+}
+
+func (s *Server) routeProxyRequest(msg *Message) *Message {
+	target, err := proxyTargetURI(msg)
+	if err != nil {
+		logger.Debugf("invalid proxy request: %v", err)
+		return NewBadOptionResponseMessage(msg)
+	}
+
+	proxy, ok := s.proxies[target.Scheme]
+	if !ok {
+		return msg.Respond(ProxyingNotSupported).message()
+	}
+	return s.forwardRequest(msg, target, proxy)
+}
+
+// Spec: unrecognized options, which are unsafe to forward, MUST cause the proxy to answer 5.02 Bad Gateway.
+// Unrecognized options, which are safe to forward, are forwarded (RFC 7252, section 5.7.1).
+func (s *Server) hasUnsafeUnrecognizedOption(msg *Message) bool {
+	for _, o := range *msg.Options {
+		if _, ok := s.options.Lookup(o.Number); !ok && o.Number.IsUnsafe() {
+			return true
+		}
+	}
+	return false
+}
+
+// Forwards the request using the proxy. The upstream request is bounded by the time
+// the client waits for the response, before it gives up (MAX_TRANSMIT_WAIT).
+func (s *Server) forwardRequest(msg *Message, target *url.URL, proxy ProxyFunc) *Message {
+	if s.hasUnsafeUnrecognizedOption(msg) {
+		return msg.Respond(BadGateway).message()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.parameters.MaxTransmitWait())
+	defer cancel()
+
+	resp, err := proxy(ctx, msg.Clone(), target)
+	if err != nil {
+		logger.Debugf("proxying to %v failed: %v", target, err)
+		if errors.Is(err, InvalidURI) {
+			return msg.Respond(BadRequest).message()
+		}
+		var ne net.Error
+		if errors.Is(err, RequestTimedOut) || errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+			return msg.Respond(GatewayTimeout).message()
+		}
+		return msg.Respond(BadGateway).message()
+	}
+	return resp
+}
+
+// Determines the target URI of a proxy request from Proxy-Uri, or from Proxy-Scheme
+// combined with the Uri-* options (RFC 7252, section 6.5).
+func proxyTargetURI(msg *Message) (*url.URL, error) {
+	if values := msg.Options.Get(ProxyUri); len(values) > 0 {
+		target, err := url.Parse(string(values[0]))
+		if err != nil {
+			return nil, err
+		}
+		if !target.IsAbs() || target.Host == "" {
+			return nil, errors.New("Proxy-Uri must be an absolute URI")
+		}
+		target.Scheme = strings.ToLower(target.Scheme)
+		return target, nil
+	}
+
+	if !msg.HasOption(UriHost) {
+		return nil, errors.New("Proxy-Scheme requires Uri-Host")
+	}
+	return url.Parse(msg.URI())
+}
+
+// Composes the query of an URI from the Uri-Query options of the message, escaped like by Message.URI.
+func uriQuery(msg *Message) string {
+	var query []string
+	for _, q := range msg.Options.Get(UriQuery) {
+		query = append(query, escapeURIComponent(string(q), ":@/?"))
+	}
+	return strings.Join(query, "&")
+}
@@ -0,0 +1,248 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options of the request, which address the proxy or are replaced by the target URI.
+var proxyRequestOptions = map[OptionNumberType]bool{
+	UriHost:     true,
+	UriPort:     true,
+	UriPath:     true,
+	UriQuery:    true,
+	ProxyUri:    true,
+	ProxyScheme: true,
+	Echo:        true,
+}
+
+// CoapProxy forwards requests to CoAP origin servers and caches their responses
+// according to Max-Age, revalidating stale responses using ETag.
+// Identical concurrent GET requests are collapsed into a single request to the origin.
+type CoapProxy struct {
+	parameters TransmissionParameters
+	cache      *ResponseCache
+
+	lock     sync.Mutex
+	clients  map[string]*Client
+	inflight map[string]*proxyCall
+}
+
+// A request to the origin, other identical requests wait for.
+type proxyCall struct {
+	done     chan struct{}
+	response *Message
+	err      error
+}
+
+// NewCoapProxy creates a proxy towards CoAP origin servers using given transmission parameters.
+// Use Forward as ProxyFunc of scheme "coap", either with AddProxy or AddReverseProxy.
+func NewCoapProxy(parameters TransmissionParameters) *CoapProxy {
+	return &CoapProxy{
+		parameters: parameters,
+		cache:      NewResponseCache(DefaultCacheSize),
+		clients:    make(map[string]*Client),
+		inflight:   make(map[string]*proxyCall),
+	}
+}
+
+// Get string representation of the proxy
+func (p *CoapProxy) String() string {
+	return fmt.Sprintf("CoapProxy{ parameters=%v }", p.parameters)
+}
+
+// CacheStats returns the statistics of the response cache of the proxy.
+func (p *CoapProxy) CacheStats() CacheStats {
+	return p.cache.Stats()
+}
+
+// Close releases the connections to the origin servers.
+func (p *CoapProxy) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for endpoint, client := range p.clients {
+		client.Close()
+		delete(p.clients, endpoint)
+	}
+}
+
+// Forward sends the request to the target URI, answering from cache if possible.
+// Requests waiting for an identical request to the origin give up, when the context is done.
+func (p *CoapProxy) Forward(ctx context.Context, request *Message, target *url.URL) (*Message, error) {
+	if target.Scheme != "coap" {
+		return responseWithCode(request, ProxyingNotSupported), nil
+	}
+	endpoint := net.JoinHostPort(target.Hostname(), portOf(target))
+	upstream, err := newUpstreamRequest(request, target)
+	if err != nil {
+		return nil, err
+	}
+
+	if *request.Code != *GET {
+		response, err := p.exchange(endpoint, upstream)
+		if err != nil {
+			return nil, err
+		}
+		if invalidatesCache(upstream, response) {
+			p.cache.invalidate(resourceKey(endpoint, upstream))
+		}
+		return responseTo(request, response), nil
+	}
+
+	// validation of the client is answered from cache, the proxy validates its own entries
+	upstream.Options.Del(ETag)
+	key := cacheKey(endpoint, upstream)
+	now := time.Now()
+	entry, ok := p.cache.lookup(key, now)
+	if !ok || !entry.isFresh(now) {
+		response, err := p.fetch(ctx, key, endpoint, upstream, entry)
+		if err != nil {
+			return nil, err
+		}
+		if !isCacheable(response) && *response.Code != *Valid {
+			return responseTo(request, response), nil
+		}
+		now = time.Now()
+		if entry, ok = p.cache.get(key); !ok {
+			return responseTo(request, response), nil
+		}
+	}
+
+	// Spec: requests validating a cached response are answered with 2.03 Valid.
+	if etag, ok := entry.etag(); ok && hasETag(request, etag) {
+		valid := entry.responseTo(request, now)
+		valid.Code = Valid
+		valid.Payload = nil
+		valid.Options.Del(ContentFormat)
+		return valid, nil
+	}
+	return entry.responseTo(request, now), nil
+}
+
+// Fetches the response from the origin and updates the cache, collapsing identical
+// concurrent requests into one. Stale entries with ETag are revalidated.
+func (p *CoapProxy) fetch(ctx context.Context, key string, endpoint string, upstream *Message, stale *cacheEntry) (*Message, error) {
+	p.lock.Lock()
+	if call, ok := p.inflight[key]; ok {
+		p.lock.Unlock()
+		select {
+		case <-call.done:
+			return call.response, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &proxyCall{done: make(chan struct{})}
+	p.inflight[key] = call
+	p.lock.Unlock()
+
+	defer func() {
+		p.lock.Lock()
+		delete(p.inflight, key)
+		p.lock.Unlock()
+		close(call.done)
+	}()
+
+	if stale != nil {
+		if etag, ok := stale.etag(); ok {
+			upstream.Options.Set(ETag, etag)
+		}
+	}
+	call.response, call.err = p.exchange(endpoint, upstream)
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	now := time.Now()
+	if *call.response.Code == *Valid {
+		p.cache.refresh(key, call.response, now)
+	} else if isCacheable(call.response) {
+		p.cache.put(key, resourceKey(endpoint, upstream), call.response, now)
+	}
+	return call.response, nil
+}
+
+func (p *CoapProxy) exchange(endpoint string, upstream *Message) (*Message, error) {
+	p.lock.Lock()
+	client, ok := p.clients[endpoint]
+	if !ok {
+		var err error
+		if client, err = NewClientWithParameters(endpoint, p.parameters); err != nil {
+			p.lock.Unlock()
+			return nil, err
+		}
+		p.clients[endpoint] = client
+	}
+	p.lock.Unlock()
+
+	response, err := client.Do(upstream)
+	if err == nil && response == nil {
+		return nil, errors.New("response was suppressed by request")
+	}
+	return response, err
+}
+
+// Creates the request to the origin server addressed by the target URI.
+// Path segments and query arguments are unescaped like by NewRequest.
+func newUpstreamRequest(request *Message, target *url.URL) (*Message, error) {
+	options := OptionsType{}
+	for _, o := range *request.Options {
+		if !proxyRequestOptions[o.Number] {
+			options = append(options, o)
+		}
+	}
+	if net.ParseIP(target.Hostname()) == nil {
+		options.Set(UriHost, OptionValueType(target.Hostname()))
+	}
+	if path := target.EscapedPath(); path != "" && path != "/" {
+		for _, segment := range strings.Split(path[1:], "/") {
+			value, err := url.PathUnescape(segment)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", InvalidURI, err)
+			}
+			options.Add(UriPath, OptionValueType(value))
+		}
+	}
+	if target.RawQuery != "" {
+		for _, argument := range strings.Split(target.RawQuery, "&") {
+			value, err := url.PathUnescape(argument)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", InvalidURI, err)
+			}
+			options.Add(UriQuery, OptionValueType(value))
+		}
+	}
+
+	return &Message{
+		Type:      Confirmable,
+		Code:      request.Code,
+		MessageID: NewMessageId(),
+		Token:     NewToken(),
+		Options:   &options,
+		Payload:   request.Payload,
+	}, nil
+}
+
+// Creates the response to the request from the response of the origin server.
+func responseTo(request *Message, response *Message) *Message {
+	reply := request.Respond(response.Code).message()
+	reply.Options = copyOptions(response.Options)
+	reply.Payload = response.Payload
+	return reply
+}
+
+func hasETag(request *Message, etag OptionValueType) bool {
+	for _, v := range request.Options.Get(ETag) {
+		if bytes.Equal(v, etag) {
+			return true
+		}
+	}
+	return false
+}
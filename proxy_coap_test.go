@@ -0,0 +1,335 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+// Starts an origin server on loopback, counting the requests received per path.
+func startTestOrigin() (*net.UDPConn, map[string]*int32) {
+	counts := map[string]*int32{"/temp": new(int32), "/stale": new(int32), "/slow": new(int32), "/busy": new(int32)}
+	etag := OptionValueType{0xCA, 0xFE}
+
+	respond := func(maxAge uint64) ResourceHandlerFunc {
+		return func(request *Message) (*Message, error) {
+			atomic.AddInt32(counts[UriPathOptionToString(request.Options.Get(UriPath))], 1)
+			if values := request.Options.Get(ETag); len(values) > 0 && bytes.Equal(values[0], etag) {
+				return NewAcknowledgementMessageBuilder().
+					Code(Valid).
+					MessageId(request.MessageID).
+					Token(request.Token).
+					Option(ETag, etag).
+					Option(MaxAge, uintOptionValue(maxAge)).
+					Build(), nil
+			}
+			if path := UriPathOptionToString(request.Options.Get(UriPath)); path == "/slow" || path == "/busy" {
+				time.Sleep(100 * time.Millisecond)
+			}
+			return NewAcknowledgementMessageBuilder().
+				Code(Content).
+				MessageId(request.MessageID).
+				Token(request.Token).
+				Option(ETag, etag).
+				Option(MaxAge, uintOptionValue(maxAge)).
+				WithPayload(ContentTypeTextPlain, []byte("21.5")).
+				Build(), nil
+		}
+	}
+
+	origin, _ := NewInsecureCoapServerWithDefaultParameters(
+		&Resource{Path: "/temp", OnGET: respond(60), OnPUT: func(request *Message) (*Message, error) {
+			return NewAcknowledgementMessageBuilder().
+				Code(Changed).
+				MessageId(request.MessageID).
+				Token(request.Token).
+				Build(), nil
+		}},
+		&Resource{Path: "/stale", OnGET: respond(0)},
+		&Resource{Path: "/slow", OnGET: respond(60)},
+		&Resource{Path: "/busy", OnGET: respond(0)},
+	)
+	conn, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	go origin.Serve(conn)
+	return conn, counts
+}
+
+func TestCoapProxy_Forward(t *testing.T) {
+	c.Convey("Given a proxy server in front of an origin server", t, func() {
+		conn, counts := startTestOrigin()
+		defer conn.Close()
+
+		proxy := NewCoapProxy(DefaultTransmissionParameters())
+		defer proxy.Close()
+		server, _ := NewInsecureCoapServerWithDefaultParameters()
+		server.AddProxy("coap", proxy.Forward)
+		origin := "coap://" + conn.LocalAddr().String()
+
+		get := func(path string, etags ...OptionValueType) *Message {
+			builder := newProxyRequest(GET).Option(ProxyUri, OptionValueType(origin+path))
+			if len(etags) > 0 {
+				builder = builder.Option(ETag, etags...)
+			}
+			return server.routeRequest(builder.Build())
+		}
+
+		c.Convey("When a fresh resource is requested twice", func() {
+			first := get("/temp")
+			second := get("/temp")
+
+			c.Convey("Then the second response is served from cache", func() {
+				c.So(*first.Code, c.ShouldResemble, *Content)
+				c.So(*second.Code, c.ShouldResemble, *Content)
+				c.So(second.Payload.Content, c.ShouldResemble, []byte("21.5"))
+				c.So(atomic.LoadInt32(counts["/temp"]), c.ShouldEqual, 1)
+			})
+
+			c.Convey("And Max-Age tells the remaining freshness", func() {
+				c.So(optionUint(second.Options.Get(MaxAge)[0]), c.ShouldBeBetweenOrEqual, 58, 60)
+			})
+		})
+
+		c.Convey("When a fresh resource is requested non-confirmably twice", func() {
+			request := func() *Message {
+				return NewNonConfirmableMessageBuilder().Code(GET).WithRandomMessageId().WithRandomToken().
+					Option(ProxyUri, OptionValueType(origin+"/temp")).Build()
+			}
+			first := request()
+			second := request()
+			fromOrigin := server.routeRequest(first)
+			fromCache := server.routeRequest(second)
+
+			c.Convey("Then both responses are non-confirmable and carry the token of their request", func() {
+				c.So(fromOrigin.Type, c.ShouldEqual, NonConfirmable)
+				c.So(fromOrigin.Token, c.ShouldResemble, first.Token)
+				c.So(fromCache.Type, c.ShouldEqual, NonConfirmable)
+				c.So(fromCache.Token, c.ShouldResemble, second.Token)
+				c.So(atomic.LoadInt32(counts["/temp"]), c.ShouldEqual, 1)
+			})
+		})
+
+		c.Convey("When a stale resource is requested again", func() {
+			get("/stale")
+			resp := get("/stale")
+
+			c.Convey("Then it is revalidated and served from cache", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So(resp.Payload.Content, c.ShouldResemble, []byte("21.5"))
+				c.So(atomic.LoadInt32(counts["/stale"]), c.ShouldEqual, 2)
+			})
+		})
+
+		c.Convey("When the client validates its cached response", func() {
+			get("/temp")
+			resp := get("/temp", OptionValueType{0xCA, 0xFE})
+
+			c.Convey("Then 'Valid' is returned without payload", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Valid)
+				c.So(resp.Payload, c.ShouldBeNil)
+			})
+		})
+
+		c.Convey("When identical requests are sent concurrently", func() {
+			var wg sync.WaitGroup
+			responses := make([]*Message, 5)
+			for i := range responses {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					target, _ := url.Parse(origin + "/slow")
+					responses[i], _ = proxy.Forward(context.Background(), newProxyRequest(GET).Build(), target)
+				}(i)
+			}
+			wg.Wait()
+
+			c.Convey("Then the origin sees only one request", func() {
+				c.So(atomic.LoadInt32(counts["/slow"]), c.ShouldEqual, 1)
+				for _, resp := range responses {
+					c.So(*resp.Code, c.ShouldResemble, *Content)
+				}
+			})
+		})
+
+		c.Convey("When a request carries unrecognized elective options", func() {
+			safe := server.routeRequest(newProxyRequest(GET).
+				Option(ProxyUri, OptionValueType(origin+"/temp")).
+				Option(2048, OptionValueType{0x78}).
+				Build())
+			unsafe := server.routeRequest(newProxyRequest(GET).
+				Option(ProxyUri, OptionValueType(origin+"/temp")).
+				Option(2050, OptionValueType{0x78}).
+				Build())
+
+			c.Convey("Then safe to forward ones are forwarded, unsafe ones answered with 'Bad Gateway'", func() {
+				c.So(*safe.Code, c.ShouldResemble, *Content)
+				c.So(*unsafe.Code, c.ShouldResemble, *BadGateway)
+			})
+
+			c.Convey("And safe to forward ones survive decoding and are sent upstream", func() {
+				packet := newProxyRequest(GET).Option(ProxyUri, OptionValueType(origin+"/temp")).Option(2048, OptionValueType{0x78}).Build().ToBytes()
+				msg, err := decode(packet, nil, server.options)
+				c.So(err, c.ShouldBeNil)
+				target, _ := proxyTargetURI(msg)
+				upstream, err := newUpstreamRequest(msg, target)
+				c.So(err, c.ShouldBeNil)
+				c.So(upstream.Options.Get(2048), c.ShouldResemble, []OptionValueType{{0x78}})
+			})
+		})
+
+		c.Convey("When a cached resource is changed through the proxy", func() {
+			get("/temp")
+			changed := server.routeRequest(newProxyRequest(PUT).
+				Option(ProxyUri, OptionValueType(origin+"/temp")).
+				WithPayload(ContentTypeTextPlain, []byte("22.0")).
+				Build())
+			get("/temp")
+
+			c.Convey("Then the cached response is invalidated", func() {
+				c.So(*changed.Code, c.ShouldResemble, *Changed)
+				c.So(atomic.LoadInt32(counts["/temp"]), c.ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func TestCoapProxy_CollapsingThroughServer(t *testing.T) {
+	c.Convey("Given a proxy server on loopback in front of an origin server", t, func() {
+		origin, counts := startTestOrigin()
+		defer origin.Close()
+
+		proxy := NewCoapProxy(DefaultTransmissionParameters())
+		defer proxy.Close()
+		server, _ := NewInsecureCoapServerWithDefaultParameters()
+		server.AddProxy("coap", proxy.Forward)
+		conn, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		go server.Serve(conn)
+		defer conn.Close()
+
+		c.Convey("When clients request the same slow resource concurrently, which is never fresh", func() {
+			var wg sync.WaitGroup
+			responses := make([]*Message, 5)
+			for i := range responses {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					client, _ := NewClient(conn.LocalAddr().String())
+					defer client.Close()
+					responses[i], _ = client.Do(newProxyRequest(GET).
+						Option(ProxyUri, OptionValueType("coap://"+origin.LocalAddr().String()+"/busy")).
+						Build())
+				}(i)
+			}
+			wg.Wait()
+
+			c.Convey("Then the origin sees only one request", func() {
+				c.So(atomic.LoadInt32(counts["/busy"]), c.ShouldEqual, 1)
+				for _, resp := range responses {
+					c.So(resp, c.ShouldNotBeNil)
+					c.So(*resp.Code, c.ShouldResemble, *Content)
+				}
+			})
+		})
+	})
+}
+
+func TestServer_ReverseProxy(t *testing.T) {
+	c.Convey("Given a server with a reverse proxy for an origin server", t, func() {
+		conn, counts := startTestOrigin()
+		defer conn.Close()
+
+		proxy := NewCoapProxy(DefaultTransmissionParameters())
+		defer proxy.Close()
+		server, _ := NewInsecureCoapServerWithDefaultParameters()
+		err := server.AddReverseProxy("/device", "coap://"+conn.LocalAddr().String(), proxy.Forward)
+		c.So(err, c.ShouldBeNil)
+
+		c.Convey("When a path below the prefix is requested", func() {
+			resp := server.routeRequest(newProxyRequest(GET).Option(UriPath, OptionValueType("device"), OptionValueType("temp")).Build())
+
+			c.Convey("Then it is served by the origin", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So(atomic.LoadInt32(counts["/temp"]), c.ShouldEqual, 1)
+			})
+		})
+
+		c.Convey("When a path outside the prefix is requested", func() {
+			resp := server.routeRequest(newProxyRequest(GET).Option(UriPath, OptionValueType("devices"), OptionValueType("temp")).Build())
+
+			c.Convey("Then 'Not Found' is returned", func() {
+				c.So(*resp.Code, c.ShouldResemble, *NotFound)
+			})
+		})
+	})
+}
+
+func TestNewUpstreamRequest(t *testing.T) {
+	c.Convey("Given a target URI with escaped slash, plus sign and non-ASCII characters", t, func() {
+		target, _ := url.Parse("coap://device/a%2Fb/c?x=1+2&unit=%C2%B0C")
+
+		c.Convey("When the upstream request is created", func() {
+			upstream, err := newUpstreamRequest(newProxyRequest(GET).Build(), target)
+
+			c.Convey("Then segments and arguments are unescaped like by NewRequest", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(upstream.Options.Get(UriPath), c.ShouldResemble, []OptionValueType{OptionValueType("a/b"), OptionValueType("c")})
+				c.So(upstream.Options.Get(UriQuery), c.ShouldResemble, []OptionValueType{OptionValueType("x=1+2"), OptionValueType("unit=°C")})
+			})
+		})
+	})
+
+	c.Convey("Given a target URI with an invalid escape in the query", t, func() {
+		target, _ := url.Parse("coap://device/temp?x=%zz")
+
+		c.Convey("Then creating the upstream request fails", func() {
+			_, err := newUpstreamRequest(newProxyRequest(GET).Build(), target)
+			c.So(errors.Is(err, InvalidURI), c.ShouldBeTrue)
+		})
+
+		c.Convey("And the proxy answers 'Bad Request'", func() {
+			proxy := NewCoapProxy(DefaultTransmissionParameters())
+			defer proxy.Close()
+			server, _ := NewInsecureCoapServerWithDefaultParameters()
+			server.AddProxy("coap", proxy.Forward)
+			resp := server.routeRequest(newProxyRequest(GET).Option(ProxyUri, OptionValueType(target.String())).Build())
+			c.So(*resp.Code, c.ShouldResemble, *BadRequest)
+		})
+	})
+}
+
+func TestServer_ReverseProxyTargetURI(t *testing.T) {
+	c.Convey("Given a server with a reverse proxy", t, func() {
+		targets := make(chan *url.URL, 1)
+		server, _ := NewInsecureCoapServerWithDefaultParameters()
+		server.AddReverseProxy("/device", "coap://device/s", func(ctx context.Context, request *Message, target *url.URL) (*Message, error) {
+			targets <- target
+			return NewContentResponseMessage(request), nil
+		})
+
+		c.Convey("When a segment with a slash and an argument with plus sign and space are forwarded", func() {
+			server.routeRequest(newProxyRequest(GET).
+				Option(UriPath, OptionValueType("device"), OptionValueType("a/b")).
+				Option(UriQuery, OptionValueType("x=1+2 3")).
+				Build())
+			target := <-targets
+
+			c.Convey("Then they are escaped in the target URI", func() {
+				c.So(target.String(), c.ShouldEqual, "coap://device/s/a%2Fb?x=1+2%203")
+			})
+
+			c.Convey("And the upstream request carries them unchanged", func() {
+				upstream, err := newUpstreamRequest(newProxyRequest(GET).Build(), target)
+				c.So(err, c.ShouldBeNil)
+				c.So(upstream.Options.Get(UriPath), c.ShouldResemble, []OptionValueType{OptionValueType("s"), OptionValueType("a/b")})
+				c.So(upstream.Options.Get(UriQuery), c.ShouldResemble, []OptionValueType{OptionValueType("x=1+2 3")})
+			})
+		})
+	})
+}
@@ -0,0 +1,174 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Spec: ETags are 1 to 8 bytes long.
+const maxETagLength = 8
+
+var coapMethods = map[CodeType]string{
+	*GET:    http.MethodGet,
+	*POST:   http.MethodPost,
+	*PUT:    http.MethodPut,
+	*DELETE: http.MethodDelete,
+}
+
+// Mapping of HTTP status codes to CoAP response codes (RFC 7252, section 10.2).
+// Successful responses are mapped depending on the request method in coapCode.
+var coapCodes = map[int]*CodeType{
+	http.StatusCreated:     Created,
+	http.StatusNotModified: Valid,
+
+	http.StatusBadRequest:            BadRequest,
+	http.StatusUnauthorized:          Unauthorized,
+	http.StatusForbidden:             Forbidden,
+	http.StatusNotFound:              NotFound,
+	http.StatusMethodNotAllowed:      MethodNotAllowed,
+	http.StatusNotAcceptable:         NotAcceptable,
+	http.StatusPreconditionFailed:    PreconditionFailed,
+	http.StatusRequestEntityTooLarge: RequestEntityTooLarge,
+	http.StatusUnsupportedMediaType:  UnsupportedContentFormat,
+
+	http.StatusInternalServerError: InternalServerError,
+	http.StatusNotImplemented:      NotImplemented,
+	http.StatusBadGateway:          BadGateway,
+	http.StatusServiceUnavailable:  ServiceUnavailable,
+	http.StatusGatewayTimeout:      GatewayTimeout,
+}
+
+// NewHTTPProxy creates a proxy, which performs requests to "http" and "https" targets using the
+// given HTTP client and translates the responses back. Requests are bounded by the context, so that
+// a client without timeout, like http.DefaultClient, results in 5.04 Gateway Timeout as well.
+func NewHTTPProxy(client *http.Client) ProxyFunc {
+	return func(ctx context.Context, request *Message, target *url.URL) (*Message, error) {
+		if target.Scheme != "http" && target.Scheme != "https" {
+			return responseWithCode(request, ProxyingNotSupported), nil
+		}
+		method, ok := coapMethods[*request.Code]
+		if !ok {
+			return NewMethodNotAllowedResponseMessage(request), nil
+		}
+
+		var body io.Reader
+		if request.Payload != nil {
+			body = bytes.NewReader(request.Payload.Content)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+		if err != nil {
+			return nil, err
+		}
+		if format, ok := request.GetUint(ContentFormat); ok && request.Payload != nil {
+			cType := ContentType(format)
+			if mediaType, ok := cType.MediaType(); ok {
+				req.Header.Set("Content-Type", mediaType)
+				if coding := cType.ContentCoding(); coding != "" {
+					req.Header.Set("Content-Encoding", coding)
+				}
+			} else {
+				return responseWithCode(request, UnsupportedContentFormat), nil
+			}
+		}
+		if accept, ok := request.GetUint(Accept); ok {
+			if mediaType, ok := ContentType(accept).MediaType(); ok {
+				req.Header.Set("Accept", mediaType)
+			}
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		content, err := io.ReadAll(io.LimitReader(resp.Body, MaxPacketSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(content) > MaxPacketSize {
+			return nil, errors.New("response body exceeds maximum packet size")
+		}
+		return coapResponse(request, resp, content), nil
+	}
+}
+
+// Translates the HTTP response into the CoAP response to the request.
+func coapResponse(request *Message, resp *http.Response, content []byte) *Message {
+	builder := NewAcknowledgementMessageBuilder().
+		Code(coapCode(request, resp.StatusCode)).
+		MessageId(request.MessageID).
+		Token(request.Token)
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		builder = builder.Option(ETag, coapETag(etag))
+	}
+	if maxAge, ok := cacheControlMaxAge(resp.Header.Get("Cache-Control")); ok {
+		builder = builder.Option(MaxAge, uintOptionValue(maxAge))
+	}
+
+	if len(content) == 0 {
+		return builder.Build()
+	}
+	cType := ContentType(ContentTypeApplicationOctetStream)
+	if mediaType := resp.Header.Get("Content-Type"); mediaType != "" {
+		if c, ok := ContentTypeOf(mediaType, resp.Header.Get("Content-Encoding")); ok {
+			cType = c
+		}
+	}
+	return builder.WithPayload(cType, content).Build()
+}
+
+func coapCode(request *Message, status int) *CodeType {
+	if status >= 200 && status < 300 && status != http.StatusCreated {
+		switch *request.Code {
+		case *GET:
+			return Content
+		case *DELETE:
+			return Deleted
+		default:
+			return Changed
+		}
+	}
+	if code, ok := coapCodes[status]; ok {
+		return code
+	}
+	if status >= 400 && status < 500 {
+		return BadRequest
+	}
+	return BadGateway
+}
+
+// Derives the CoAP ETag from the HTTP entity tag: short tags are taken as they are,
+// longer tags are shortened by hashing.
+func coapETag(etag string) OptionValueType {
+	etag = strings.TrimPrefix(etag, "W/")
+	etag = strings.Trim(etag, "\"")
+	if len(etag) > 0 && len(etag) <= maxETagLength {
+		return OptionValueType(etag)
+	}
+	sum := sha256.Sum256([]byte(etag))
+	return sum[0:maxETagLength]
+}
+
+func cacheControlMaxAge(header string) (uint64, bool) {
+	for _, directive := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if strings.EqualFold(name, "no-cache") || strings.EqualFold(name, "no-store") {
+			return 0, true
+		}
+		if strings.EqualFold(name, "max-age") {
+			if maxAge, err := strconv.ParseUint(value, 10, 32); err == nil {
+				return maxAge, true
+			}
+		}
+	}
+	return 0, false
+}
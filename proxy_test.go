@@ -0,0 +1,193 @@
+package coap
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+func newProxyRequest(code *CodeType) messageTokenBuilder {
+	return NewConfirmableMessageBuilder().Code(code).WithRandomMessageId().WithRandomToken()
+}
+
+func TestProxyTargetURI(t *testing.T) {
+	c.Convey("Given a request with Proxy-Scheme and Uri options", t, func() {
+		req := newProxyRequest(GET).
+			Option(ProxyScheme, OptionValueType("HTTP")).
+			Option(UriHost, OptionValueType("example.com")).
+			Option(UriPort, uintOptionValue(8080)).
+			Option(UriPath, OptionValueType("a"), OptionValueType("b")).
+			Option(UriQuery, OptionValueType("x=1"), OptionValueType("y=a&b")).
+			Build()
+
+		c.Convey("When the target URI is determined", func() {
+			target, err := proxyTargetURI(req)
+
+			c.Convey("Then it is composed of the options", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(target.String(), c.ShouldEqual, "http://example.com:8080/a/b?x=1&y=a%26b")
+			})
+		})
+	})
+
+	c.Convey("Given a request with relative Proxy-Uri", t, func() {
+		req := newProxyRequest(GET).Option(ProxyUri, OptionValueType("/a/b")).Build()
+
+		c.Convey("Then determining the target URI fails", func() {
+			_, err := proxyTargetURI(req)
+			c.So(err, c.ShouldNotBeNil)
+		})
+	})
+}
+
+func TestServer_HTTPProxy(t *testing.T) {
+	c.Convey("Given a server proxying to an HTTP upstream", t, func() {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/slow":
+				time.Sleep(200 * time.Millisecond)
+			case r.Method == http.MethodPost:
+				w.WriteHeader(http.StatusCreated)
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("ETag", "\"v1\"")
+				w.Header().Set("Cache-Control", "max-age=30")
+				w.Write([]byte(`{"temp":21.5}`))
+			}
+		}))
+		defer upstream.Close()
+
+		server, _ := NewInsecureCoapServerWithDefaultParameters()
+		server.AddProxy("http", NewHTTPProxy(&http.Client{Timeout: 50 * time.Millisecond}))
+
+		c.Convey("When a GET with Proxy-Uri is routed", func() {
+			resp := server.routeRequest(newProxyRequest(GET).Option(ProxyUri, OptionValueType(upstream.URL+"/temp")).Build())
+
+			c.Convey("Then the HTTP response is translated", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So(string(resp.Payload.Content), c.ShouldEqual, `{"temp":21.5}`)
+				c.So(resp.Options.Get(ContentFormat)[0], c.ShouldResemble, uintOptionValue(ContentTypeApplicationJson))
+				c.So(resp.Options.Get(ETag)[0], c.ShouldResemble, OptionValueType("v1"))
+				c.So(resp.Options.Get(MaxAge)[0], c.ShouldResemble, uintOptionValue(30))
+			})
+		})
+
+		c.Convey("When a POST with Proxy-Scheme is routed", func() {
+			u, _ := url.Parse(upstream.URL)
+			port, _ := strconv.Atoi(u.Port())
+			resp := server.routeRequest(newProxyRequest(POST).
+				Option(ProxyScheme, OptionValueType("http")).
+				Option(UriHost, OptionValueType(u.Hostname())).
+				Option(UriPort, uintOptionValue(uint64(port))).
+				Build())
+
+			c.Convey("Then 'Created' is returned", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Created)
+			})
+		})
+
+		c.Convey("When the upstream times out", func() {
+			resp := server.routeRequest(newProxyRequest(GET).Option(ProxyUri, OptionValueType(upstream.URL+"/slow")).Build())
+
+			c.Convey("Then 'Gateway Timeout' is returned", func() {
+				c.So(*resp.Code, c.ShouldResemble, *GatewayTimeout)
+			})
+		})
+
+		c.Convey("When the upstream is unreachable", func() {
+			l, _ := net.Listen("tcp", "127.0.0.1:0")
+			addr := l.Addr().String()
+			l.Close()
+			resp := server.routeRequest(newProxyRequest(GET).Option(ProxyUri, OptionValueType("http://"+addr+"/")).Build())
+
+			c.Convey("Then 'Bad Gateway' is returned", func() {
+				c.So(*resp.Code, c.ShouldResemble, *BadGateway)
+			})
+		})
+
+		c.Convey("When a request for a scheme not enabled is routed", func() {
+			resp := server.routeRequest(newProxyRequest(GET).Option(ProxyUri, OptionValueType("coap://example.com/temp")).Build())
+
+			c.Convey("Then 'Proxying Not Supported' is returned", func() {
+				c.So(*resp.Code, c.ShouldResemble, *ProxyingNotSupported)
+			})
+		})
+
+		c.Convey("When a non-confirmable request to an unreachable upstream is routed", func() {
+			l, _ := net.Listen("tcp", "127.0.0.1:0")
+			addr := l.Addr().String()
+			l.Close()
+			resp := server.routeRequest(NewNonConfirmableMessageBuilder().Code(GET).WithRandomMessageId().WithRandomToken().
+				Option(ProxyUri, OptionValueType("http://"+addr+"/")).Build())
+
+			c.Convey("Then 'Bad Gateway' is returned non-confirmably", func() {
+				c.So(*resp.Code, c.ShouldResemble, *BadGateway)
+				c.So(resp.Type, c.ShouldEqual, NonConfirmable)
+			})
+		})
+	})
+}
+
+func TestServer_ProxyRespondsSeparately(t *testing.T) {
+	c.Convey("Given a server on loopback proxying to an HTTP upstream, which never replies", t, func() {
+		stop := make(chan struct{})
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-stop:
+			}
+		}))
+		defer upstream.Close()
+		defer close(stop)
+
+		server, _ := NewInsecureCoapServer(testTransmissionParameters(), &Resource{
+			Path: "/rd",
+			OnGET: func(request *Message) (*Message, error) {
+				return NewContentResponseMessage(request), nil
+			},
+		})
+		server.AddProxy("http", NewHTTPProxy(http.DefaultClient))
+		conn, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		go server.Serve(conn)
+		defer conn.Close()
+
+		c.Convey("When a confirmable request is proxied", func() {
+			proxied, _ := NewClient(conn.LocalAddr().String())
+			defer proxied.Close()
+			responses := make(chan *Message, 1)
+			go func() {
+				resp, _ := proxied.Do(newProxyRequest(GET).Option(ProxyUri, OptionValueType(upstream.URL+"/never")).Build())
+				responses <- resp
+			}()
+			time.Sleep(20 * time.Millisecond)
+
+			c.Convey("Then requests of other clients are answered meanwhile", func() {
+				client, _ := NewClientWithParameters(conn.LocalAddr().String(), testTransmissionParameters())
+				defer client.Close()
+				start := time.Now()
+				resp, err := client.Do(newTestRequest(Confirmable))
+
+				c.So(err, c.ShouldBeNil)
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So(time.Since(start), c.ShouldBeLessThan, 100*time.Millisecond)
+			})
+
+			c.Convey("Then 'Gateway Timeout' is sent in a confirmable separate response", func() {
+				var resp *Message
+				select {
+				case resp = <-responses:
+				case <-time.After(2 * time.Second):
+				}
+				c.So(resp, c.ShouldNotBeNil)
+				c.So(resp.Type, c.ShouldEqual, Confirmable)
+				c.So(*resp.Code, c.ShouldResemble, *GatewayTimeout)
+			})
+		})
+	})
+}
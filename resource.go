@@ -11,6 +11,23 @@ type Resource struct {
 	OnPUT    ResourceHandlerFunc
 	OnPOST   ResourceHandlerFunc
 	OnDELETE ResourceHandlerFunc
+
+	// RequireFreshness demands a fresh Echo value (RFC 9175) on every request to the resource,
+	// which protects actuators against delayed requests. Requests without it are answered
+	// with 4.01 Unauthorized carrying an Echo value to repeat the request with.
+	RequireFreshness bool
+
+	// ETag returns the current entity tag of the resource, or nil if it has no representation.
+	// If set, conditional requests are evaluated by the server: PUT and DELETE failing If-Match
+	// or If-None-Match are answered with 4.12 Precondition Failed, GET with a matching ETag
+	// is answered with 2.03 Valid. With Representations, each content format gets its own ETag
+	// derived from it, and GET is validated against the ETag of the negotiated representation.
+	ETag func() []byte
+
+	// Representations serve GET in different content formats, instead of OnGET.
+	// The representation is selected by the Accept option of the request, the first one
+	// is the default. Requests accepting none of them are answered with 4.06 Not Acceptable.
+	Representations []Representation
 }
 
 func (r *Resource) String() string {
@@ -0,0 +1,98 @@
+package coap
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+type responseBuilder struct {
+	response *Message
+	err      error
+}
+
+// Respond creates a builder for the response to the request with the given code. The token of the request is copied.
+// Spec: confirmable requests are answered by a response piggybacked on the acknowledgement, carrying the message ID
+// of the request. Other requests are answered by a non-confirmable response with a new message ID.
+// Use Separate to answer by a separate response instead.
+func (m *Message) Respond(code *CodeType) responseBuilder {
+	response := &Message{
+		Type:      NonConfirmable,
+		Code:      code,
+		MessageID: NewMessageId(),
+		Token:     &TokenType{},
+		Options:   &OptionsType{},
+	}
+	if m.Type == Confirmable {
+		response.Type = Acknowledgement
+		response.MessageID = m.MessageID
+	}
+	if m.Token != nil {
+		response.Token = m.Token.Copy()
+	}
+	return responseBuilder{response: response}
+}
+
+// Separate makes the response a separate response (RFC 7252, section 5.2.2): a confirmable message
+// with a new message ID. The server acknowledges the request by an empty acknowledgement and
+// retransmits the response until the client acknowledges it.
+// Separate only changes how the response is sent: the handler has returned before the empty
+// acknowledgement is sent, so slow handlers still delay it and may cause retransmissions of the request.
+func (b responseBuilder) Separate() responseBuilder {
+	b.response.Type = Confirmable
+	b.response.MessageID = NewMessageId()
+	return b
+}
+
+// Option adds an option with one or many values to the response.
+func (b responseBuilder) Option(opt OptionNumberType, values ...OptionValueType) responseBuilder {
+	b.response.Options.Add(opt, values...)
+	return b
+}
+
+// WithPayload sets the payload of given content type, replacing a previous one.
+func (b responseBuilder) WithPayload(cType ContentType, payload []byte) responseBuilder {
+	b.response.Options.Set(ContentFormat, uintOptionValue(uint64(cType)))
+	b.response.Payload = &PayloadType{
+		Type:    &cType,
+		Content: payload,
+	}
+	return b
+}
+
+// WithJSON sets the JSON encoding of v as payload. Encoding errors are returned by Build.
+func (b responseBuilder) WithJSON(v interface{}) responseBuilder {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	return b.WithPayload(ContentTypeApplicationJson, payload)
+}
+
+// WithMaxAge sets the time the response stays fresh, in whole seconds.
+func (b responseBuilder) WithMaxAge(maxAge time.Duration) responseBuilder {
+	seconds := uint64(0)
+	if maxAge > 0 {
+		seconds = uint64(maxAge / time.Second)
+	}
+	if seconds > math.MaxUint32 {
+		seconds = math.MaxUint32
+	}
+	b.response.Options.Set(MaxAge, uintOptionValue(seconds))
+	return b
+}
+
+// Build returns the response, or the error of a previous builder method.
+// It fits the result of resource handlers.
+func (b responseBuilder) Build() (*Message, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.response, nil
+}
+
+// Returns the response of a builder, which cannot fail, as it does not encode JSON.
+func (b responseBuilder) message() *Message {
+	return b.response
+}
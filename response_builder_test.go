@@ -0,0 +1,114 @@
+package coap
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMessage_Respond(t *testing.T) {
+	c.Convey("Given a confirmable request", t, func() {
+		request := newTestRequest(Confirmable)
+
+		c.Convey("When a response is built with options and payload in any order", func() {
+			resp, err := request.Respond(Content).
+				WithMaxAge(90*time.Second).
+				WithJSON(map[string]int{"temp": 21}).
+				Option(ETag, OptionValueType{0xCA, 0xFE}).
+				Build()
+
+			c.Convey("Then it is piggybacked on the acknowledgement", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(resp.Type, c.ShouldEqual, Acknowledgement)
+				c.So(resp.MessageID, c.ShouldEqual, request.MessageID)
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+			})
+			c.Convey("And it carries token, options and payload", func() {
+				c.So(resp.Token, c.ShouldResemble, request.Token)
+				maxAge, _ := resp.GetUint(MaxAge)
+				c.So(maxAge, c.ShouldEqual, 90)
+				c.So(resp.Options.Get(ETag), c.ShouldResemble, []OptionValueType{{0xCA, 0xFE}})
+				c.So(*resp.Payload.Type, c.ShouldEqual, ContentTypeApplicationJson)
+				c.So(string(resp.Payload.Content), c.ShouldEqual, `{"temp":21}`)
+				c.So(resp.Validate(), c.ShouldEqual, Ok)
+			})
+			c.Convey("And its token does not share memory with the request", func() {
+				(*resp.Token)[0] ^= 0xFF
+				c.So(resp.Token, c.ShouldNotResemble, request.Token)
+			})
+		})
+
+		c.Convey("When a separate response is built", func() {
+			resp, _ := request.Respond(Content).Separate().WithPayload(ContentTypeTextPlain, []byte("21.5")).Build()
+
+			c.Convey("Then it is confirmable with a new message ID", func() {
+				c.So(resp.Type, c.ShouldEqual, Confirmable)
+				c.So(resp.MessageID, c.ShouldNotEqual, request.MessageID)
+				c.So(resp.Token, c.ShouldResemble, request.Token)
+			})
+		})
+
+		c.Convey("When a response is built with a value not encodable as JSON", func() {
+			_, err := request.Respond(Content).WithJSON(math.Inf(1)).Build()
+
+			c.Convey("Then the error is returned", func() {
+				c.So(err, c.ShouldNotBeNil)
+			})
+		})
+	})
+
+	c.Convey("Given a non-confirmable request", t, func() {
+		request := newTestRequest(NonConfirmable)
+
+		c.Convey("When a response is built", func() {
+			resp, _ := request.Respond(Changed).WithPayload(ContentTypeTextPlain, []byte("ok")).Build()
+
+			c.Convey("Then it is a non-confirmable response", func() {
+				c.So(resp.Type, c.ShouldEqual, NonConfirmable)
+				c.So(resp.Token, c.ShouldResemble, request.Token)
+				c.So(resp.ToBytes(), c.ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestServer_SeparateResponse(t *testing.T) {
+	c.Convey("Given a server on loopback with a resource answering by separate response", t, func() {
+		server, _ := NewInsecureCoapServer(testTransmissionParameters(), &Resource{
+			Path: "/rd",
+			OnGET: func(request *Message) (*Message, error) {
+				return request.Respond(Content).Separate().WithPayload(ContentTypeTextPlain, []byte("21.5")).Build()
+			},
+		})
+		conn, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		go server.Serve(conn)
+		defer conn.Close()
+
+		client, _ := NewClientWithParameters(conn.LocalAddr().String(), testTransmissionParameters())
+		defer client.Close()
+
+		c.Convey("When a confirmable request is sent", func() {
+			req := newTestRequest(Confirmable)
+			resp, err := client.Do(req)
+
+			c.Convey("Then the confirmable response follows the empty acknowledgement", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(resp.Type, c.ShouldEqual, Confirmable)
+				c.So(resp.MessageID, c.ShouldNotEqual, req.MessageID)
+				c.So(*resp.Token, c.ShouldResemble, *req.Token)
+				c.So(resp.Payload.Content, c.ShouldResemble, []byte("21.5"))
+			})
+
+			c.Convey("And it is not retransmitted once acknowledged", func() {
+				time.Sleep(100 * time.Millisecond)
+				server.pendingLock.Lock()
+				pending := len(server.pending)
+				server.pendingLock.Unlock()
+				c.So(pending, c.ShouldEqual, 0)
+			})
+		})
+	})
+}
@@ -0,0 +1,157 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pion/dtls/v2"
+	"github.com/pion/dtls/v2/pkg/protocol"
+	"github.com/pion/dtls/v2/pkg/protocol/recordlayer"
+	"github.com/pion/transport/v2/udp"
+)
+
+// Idle time after which a DTLS session of a silent peer is closed.
+const SecureSessionIdleTimeout = 5 * time.Minute
+
+// Time a peer has to complete the DTLS handshake, before it is abandoned.
+const SecureHandshakeTimeout = 10 * time.Second
+
+var UnknownPSKIdentity = errors.New("unknown PSK identity")
+
+// PSKStoreFunc looks up the pre-shared key for the PSK identity presented by a peer.
+type PSKStoreFunc func(identity []byte) ([]byte, error)
+
+// SecurityConfig configures DTLS 1.2 on the secure port.
+// Currently only the PreSharedKey mode (RFC 7252, section 9.1.3.1) using
+// TLS_PSK_WITH_AES_128_CCM_8 is supported. The Certificate mode will be configured
+// by additional fields, leaving PSK optional then.
+type SecurityConfig struct {
+	// PSK resolves a PSK identity to its key, required.
+	PSK PSKStoreFunc
+
+	// IdentityHint is optionally sent to clients to help selecting an identity.
+	IdentityHint []byte
+}
+
+// PeerIdentity holds the authenticated identity of the peer a message was received from.
+// In Certificate mode, the verified peer certificates will be provided here as well.
+type PeerIdentity struct {
+	// PSK identity the peer has authenticated with.
+	PSK []byte
+}
+
+func (p *PeerIdentity) String() string {
+	return fmt.Sprintf("PeerIdentity{PSK: '%s'}", p.PSK)
+}
+
+// StaticPSKStore creates a PSKStoreFunc from a map of identities to keys.
+func StaticPSKStore(keys map[string][]byte) PSKStoreFunc {
+	return func(identity []byte) ([]byte, error) {
+		if key, ok := keys[string(identity)]; ok {
+			return key, nil
+		}
+		return nil, UnknownPSKIdentity
+	}
+}
+
+func (c *SecurityConfig) validate() error {
+	if c.PSK == nil {
+		return errors.New("security config requires a PSK store")
+	}
+	return nil
+}
+
+func (c *SecurityConfig) dtlsConfig() *dtls.Config {
+	return &dtls.Config{
+		PSK: func(identity []byte) ([]byte, error) {
+			return c.PSK(identity)
+		},
+		PSKIdentityHint:      c.IdentityHint,
+		CipherSuites:         []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_CCM_8},
+		ExtendedMasterSecret: dtls.RequestExtendedMasterSecret,
+		ConnectContextMaker: func() (context.Context, func()) {
+			return context.WithTimeout(context.Background(), SecureHandshakeTimeout)
+		},
+	}
+}
+
+// Listens for peers starting a DTLS handshake on the given address.
+// The handshake is left to the accepting side, so that peers do not wait for each other.
+func listenDTLS(addr *net.UDPAddr) (net.Listener, error) {
+	config := udp.ListenConfig{
+		AcceptFilter: func(packet []byte) bool {
+			records, err := recordlayer.UnpackDatagram(packet)
+			if err != nil || len(records) < 1 {
+				return false
+			}
+			header := &recordlayer.Header{}
+			if err := header.Unmarshal(records[0]); err != nil {
+				return false
+			}
+			return header.ContentType == protocol.ContentTypeHandshake
+		},
+	}
+	return config.Listen("udp", addr)
+}
+
+// Listen for DTLS sessions on the server address
+func (server *Server) listenSecure() error {
+	listener, err := listenDTLS(server.addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	logger.Infof("Server is listening securely on %v", listener.Addr())
+	return server.serveSecure(listener)
+}
+
+// Accepts peers from the given listener, each one performing the DTLS handshake on its own.
+func (server *Server) serveSecure(listener net.Listener) error {
+	config := server.security.dtlsConfig()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, udp.ErrClosedListener) {
+				return nil
+			}
+			logger.Debug(err)
+			continue
+		}
+		go server.serveSecureConn(conn, config)
+	}
+}
+
+func (server *Server) serveSecureConn(raw net.Conn, config *dtls.Config) {
+	conn, err := dtls.Server(raw, config)
+	if err != nil {
+		// handshake failed or timed out
+		logger.Debugf("DTLS handshake with %v failed: %v", raw.RemoteAddr(), err)
+		raw.Close()
+		return
+	}
+	defer conn.Close()
+
+	peer, _ := conn.RemoteAddr().(*net.UDPAddr)
+	identity := &PeerIdentity{PSK: conn.ConnectionState().IdentityHint}
+	logger.Debugf("DTLS session established with %v using %v", peer, identity)
+
+	buffer := make([]byte, MaxPacketSize)
+	out := packetBuffers.Get().(*[]byte)
+	defer packetBuffers.Put(out)
+	for {
+		conn.SetReadDeadline(time.Now().Add(SecureSessionIdleTimeout))
+		n, err := conn.Read(buffer)
+		if err != nil {
+			logger.Debug(err)
+			return
+		}
+
+		if resp := server.appendResponse((*out)[:0], buffer[0:n], peer, identity, conn); resp != nil {
+			conn.Write(resp)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package coap
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/dtls/v2"
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+var testSecurityConfig = SecurityConfig{
+	PSK: StaticPSKStore(map[string][]byte{
+		"device-1": {0xCA, 0xFE, 0xBA, 0xBE},
+	}),
+}
+
+func dialSecure(addr net.Addr, identity string, key []byte) (*dtls.Conn, error) {
+	return dtls.Dial("udp", addr.(*net.UDPAddr), &dtls.Config{
+		PSK: func([]byte) ([]byte, error) {
+			return key, nil
+		},
+		PSKIdentityHint: []byte(identity),
+		CipherSuites:    []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_CCM_8},
+		ConnectContextMaker: func() (context.Context, func()) {
+			return context.WithTimeout(context.Background(), 5*time.Second)
+		},
+	})
+}
+
+// Connection, which drops all but the first datagram written.
+type stallingConn struct {
+	net.Conn
+	written bool
+}
+
+func (c *stallingConn) Write(b []byte) (int, error) {
+	if c.written {
+		return len(b), nil
+	}
+	c.written = true
+	return c.Conn.Write(b)
+}
+
+func TestStaticPSKStore(t *testing.T) {
+	c.Convey("Given a static PSK store", t, func() {
+		store := StaticPSKStore(map[string][]byte{"alice": []byte("secret")})
+
+		c.Convey("When a known identity is looked up", func() {
+			key, err := store([]byte("alice"))
+
+			c.Convey("Then its key is returned", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(key, c.ShouldResemble, []byte("secret"))
+			})
+		})
+
+		c.Convey("When an unknown identity is looked up", func() {
+			_, err := store([]byte("mallory"))
+
+			c.Convey("Then 'Unknown PSK Identity' is indicated by error", func() {
+				c.So(err, c.ShouldEqual, UnknownPSKIdentity)
+			})
+		})
+	})
+}
+
+func TestServer_ServeSecure(t *testing.T) {
+	c.Convey("Given a secure coap server listening with DTLS", t, func() {
+		identities := make(chan *PeerIdentity, 1)
+
+		server, _ := NewSecureCoapServerWithDefaultParameters(testSecurityConfig, &Resource{
+			Path: "/rd",
+			OnGET: func(request *Message) (*Message, error) {
+				identities <- request.Identity
+				return NewContentResponseMessage(request), nil
+			},
+		})
+
+		listener, err := listenDTLS(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		c.So(err, c.ShouldBeNil)
+		defer listener.Close()
+		go server.serveSecure(listener)
+
+		c.Convey("When a client with a known PSK identity sends a request", func() {
+			conn, err := dialSecure(listener.Addr(), "device-1", []byte{0xCA, 0xFE, 0xBA, 0xBE})
+			c.So(err, c.ShouldBeNil)
+			defer conn.Close()
+
+			req := NewConfirmableMessageBuilder().
+				Code(GET).
+				WithRandomMessageId().
+				WithRandomToken().
+				Option(UriPath, []byte("rd")).
+				Build()
+			conn.Write(req.ToBytes())
+
+			buf := make([]byte, MaxPacketSize)
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, err := conn.Read(buf)
+			c.So(err, c.ShouldBeNil)
+			resp, err := NewMessageFromBytes(buf[0:n])
+
+			c.Convey("Then the response is received over the secure session", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So(resp.MessageID, c.ShouldEqual, req.MessageID)
+			})
+
+			c.Convey("And the handler sees the authenticated identity", func() {
+				identity := <-identities
+				c.So(identity, c.ShouldNotBeNil)
+				c.So(string(identity.PSK), c.ShouldEqual, "device-1")
+			})
+		})
+
+		c.Convey("When a peer stalls in the handshake while another client connects", func() {
+			raw, err := net.DialUDP("udp", nil, listener.Addr().(*net.UDPAddr))
+			c.So(err, c.ShouldBeNil)
+			defer raw.Close()
+			// the peer sends its first ClientHello only
+			go dtls.Client(&stallingConn{Conn: raw}, &dtls.Config{
+				PSK:             func([]byte) ([]byte, error) { return []byte{0x13, 0x37}, nil },
+				PSKIdentityHint: []byte("stalling"),
+				CipherSuites:    []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_CCM_8},
+			})
+			time.Sleep(100 * time.Millisecond)
+
+			conn, err := dialSecure(listener.Addr(), "device-1", []byte{0xCA, 0xFE, 0xBA, 0xBE})
+
+			c.Convey("Then the handshake of the other client completes", func() {
+				c.So(err, c.ShouldBeNil)
+				conn.Close()
+			})
+		})
+
+		c.Convey("When a client with an unknown PSK identity connects", func() {
+			_, err := dialSecure(listener.Addr(), "mallory", []byte{0x13, 0x37})
+
+			c.Convey("Then the handshake fails", func() {
+				c.So(err, c.ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,143 @@
+package senml
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/aellwein/coap"
+)
+
+// Content formats of SenML and SenML-ETCH, JSON and CBOR encoded.
+const (
+	ContentTypeJSON       coap.ContentType = coap.ContentTypeApplicationSenmlJson
+	ContentTypeCBOR       coap.ContentType = coap.ContentTypeApplicationSenmlCbor
+	ContentTypeStreamJSON coap.ContentType = coap.ContentTypeApplicationSensmlJson
+	ContentTypeStreamCBOR coap.ContentType = coap.ContentTypeApplicationSensmlCbor
+	ContentTypeEtchJSON   coap.ContentType = coap.ContentTypeApplicationSenmlEtchJson
+	ContentTypeEtchCBOR   coap.ContentType = coap.ContentTypeApplicationSenmlEtchCbor
+)
+
+var (
+	UnsupportedContentType = errors.New("content type is not a senml format")
+	PayloadIsMissing       = errors.New("payload is missing")
+)
+
+// Record as encoded in JSON, with the data value in base64url.
+type jsonRecord struct {
+	record
+	DataValue string `json:"vd,omitempty"`
+}
+
+// Record without methods, to avoid recursion when encoding JSON.
+type record Record
+
+// MarshalJSON encodes the record as SenML JSON (RFC 8428, section 5).
+func (r Record) MarshalJSON() ([]byte, error) {
+	j := jsonRecord{record: record(r)}
+	if r.DataValue != nil {
+		j.DataValue = base64.RawURLEncoding.EncodeToString(r.DataValue)
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes the record from SenML JSON.
+func (r *Record) UnmarshalJSON(data []byte) error {
+	var j jsonRecord
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*r = Record(j.record)
+	if j.DataValue != "" {
+		// Spec: base64url without padding, padding is tolerated on decoding.
+		v, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(j.DataValue, "="))
+		if err != nil {
+			return err
+		}
+		r.DataValue = v
+	}
+	return nil
+}
+
+func isJSON(cType coap.ContentType) bool {
+	return cType == ContentTypeJSON || cType == ContentTypeStreamJSON || cType == ContentTypeEtchJSON
+}
+
+func isCBOR(cType coap.ContentType) bool {
+	return cType == ContentTypeCBOR || cType == ContentTypeStreamCBOR || cType == ContentTypeEtchCBOR
+}
+
+// Encode encodes the pack using the given SenML or SenML-ETCH content format.
+func Encode(p Pack, cType coap.ContentType) ([]byte, error) {
+	if p == nil {
+		p = Pack{}
+	}
+	switch {
+	case isJSON(cType):
+		return json.Marshal(p)
+	case isCBOR(cType):
+		return coap.MarshalCBOR(p)
+	}
+	return nil, UnsupportedContentType
+}
+
+// Decode decodes the pack from data of the given SenML or SenML-ETCH content format.
+// The pack is not resolved nor validated.
+func Decode(data []byte, cType coap.ContentType) (Pack, error) {
+	var p Pack
+	var err error
+	switch {
+	case isJSON(cType):
+		err = json.Unmarshal(data, &p)
+	case isCBOR(cType):
+		err = coap.UnmarshalCBOR(data, &p)
+	default:
+		return nil, UnsupportedContentType
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// FromMessage decodes the pack from the payload of the message, according to its content format.
+func FromMessage(m *coap.Message) (Pack, error) {
+	if m.Payload == nil || m.Payload.Type == nil {
+		return nil, PayloadIsMissing
+	}
+	return Decode(m.Payload.Content, *m.Payload.Type)
+}
+
+// NewContentResponseMessage creates a 2.05 Content response to the request with the pack
+// encoded using the given content format.
+func NewContentResponseMessage(request *coap.Message, p Pack, cType coap.ContentType) (*coap.Message, error) {
+	content, err := Encode(p, cType)
+	if err != nil {
+		return nil, err
+	}
+	return coap.NewAcknowledgementMessageBuilder().
+		Code(coap.Content).
+		MessageId(request.MessageID).
+		Token(request.Token).
+		WithPayload(cType, content).
+		Build(), nil
+}
+
+// Representations creates the SenML JSON and CBOR representations of a resource, rendering the pack
+// returned by the function. JSON is served to requests without Accept option.
+func Representations(pack func(request *coap.Message) (Pack, error)) []coap.Representation {
+	render := func(cType coap.ContentType) coap.RepresentationFunc {
+		return func(request *coap.Message) ([]byte, error) {
+			p, err := pack(request)
+			if err != nil {
+				return nil, err
+			}
+			return Encode(p, cType)
+		}
+	}
+	return []coap.Representation{
+		{ContentType: ContentTypeJSON, Render: render(ContentTypeJSON)},
+		{ContentType: ContentTypeCBOR, Render: render(ContentTypeCBOR)},
+	}
+}
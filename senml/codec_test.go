@@ -0,0 +1,84 @@
+package senml
+
+import (
+	"testing"
+
+	"github.com/aellwein/coap"
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPack_JSON(t *testing.T) {
+	c.Convey("Given a SenML JSON pack", t, func() {
+		data := []byte(`[{"bn":"urn:dev:ow:10e2073a01080063:","n":"voltage","u":"V","v":120.1},{"n":"raw","vd":"AQL_"}]`)
+
+		c.Convey("When it is decoded", func() {
+			pack, err := Decode(data, ContentTypeJSON)
+
+			c.Convey("Then the records are decoded", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(pack, c.ShouldHaveLength, 2)
+				c.So(pack[0].BaseName, c.ShouldEqual, "urn:dev:ow:10e2073a01080063:")
+				c.So(*pack[0].Value, c.ShouldEqual, 120.1)
+				c.So(pack[1].DataValue, c.ShouldResemble, []byte{1, 2, 0xff})
+			})
+
+			c.Convey("And encoding returns the same JSON", func() {
+				encoded, err := Encode(pack, ContentTypeJSON)
+				c.So(err, c.ShouldBeNil)
+				c.So(string(encoded), c.ShouldEqual, string(data))
+			})
+		})
+	})
+}
+
+func TestPack_CBOR(t *testing.T) {
+	c.Convey("Given a pack", t, func() {
+		pack := Pack{{BaseName: "dev:", Name: "temp", Unit: "Cel", Value: new(float64)}, Data("raw", []byte{1})}
+
+		c.Convey("When it is encoded as SenML CBOR", func() {
+			data, err := Encode(pack, ContentTypeCBOR)
+			c.So(err, c.ShouldBeNil)
+
+			c.Convey("Then labels are encoded as integers (RFC 8428, section 6)", func() {
+				var records []map[int]interface{}
+				c.So(coap.UnmarshalCBOR(data, &records), c.ShouldBeNil)
+				c.So(records[0][-2], c.ShouldEqual, "dev:")
+				c.So(records[0][0], c.ShouldEqual, "temp")
+				c.So(records[0][1], c.ShouldEqual, "Cel")
+				c.So(records[0], c.ShouldContainKey, 2)
+				c.So(records[1][8], c.ShouldResemble, []byte{1})
+			})
+
+			c.Convey("And it decodes to the same pack", func() {
+				decoded, err := Decode(data, ContentTypeCBOR)
+				c.So(err, c.ShouldBeNil)
+				c.So(decoded, c.ShouldResemble, pack)
+			})
+		})
+	})
+
+	c.Convey("Given a content format other than SenML", t, func() {
+		c.Convey("Then it is not supported", func() {
+			_, err := Encode(Pack{}, coap.ContentTypeApplicationJson)
+			c.So(err, c.ShouldEqual, UnsupportedContentType)
+		})
+	})
+}
+
+func TestNewContentResponseMessage(t *testing.T) {
+	c.Convey("Given a request", t, func() {
+		request := coap.NewConfirmableMessageBuilder().Code(coap.GET).WithRandomMessageId().WithRandomToken().Build()
+
+		c.Convey("When a SenML response is created", func() {
+			resp, err := NewContentResponseMessage(request, Pack{Float("temp", "Cel", 21.5)}, ContentTypeCBOR)
+
+			c.Convey("Then the pack can be read from it", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(*resp.Code, c.ShouldResemble, *coap.Content)
+				pack, err := FromMessage(resp)
+				c.So(err, c.ShouldBeNil)
+				c.So(*pack[0].Value, c.ShouldEqual, 21.5)
+			})
+		})
+	})
+}
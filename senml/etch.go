@@ -0,0 +1,67 @@
+package senml
+
+import "time"
+
+// Fetch selects the records of the pack named by the records of the FETCH pack (RFC 8790, section 4).
+// Both packs are resolved, the records selected are returned resolved and in order of the pack.
+func (p Pack) Fetch(fetch Pack, now time.Time) (Pack, error) {
+	resolved, err := p.Resolve(now)
+	if err != nil {
+		return nil, err
+	}
+	names, err := fetch.Resolve(now)
+	if err != nil {
+		return nil, err
+	}
+	selected := make(map[string]bool, len(names))
+	for _, r := range names {
+		selected[r.Name] = true
+	}
+
+	result := Pack{}
+	for _, r := range resolved {
+		if selected[r.Name] {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+// Patch applies the iPATCH pack to the pack (RFC 8790, section 5). Records of the pack are replaced by
+// patch records of the same name, patch records without value remove them. Other patch records are added.
+// Both packs are resolved, the patched pack is returned resolved.
+func (p Pack) Patch(patch Pack, now time.Time) (Pack, error) {
+	resolved, err := p.Resolve(now)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := patch.Resolve(now)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, change := range changes {
+		found := false
+		for i := 0; i < len(resolved); i++ {
+			if resolved[i].Name != change.Name {
+				continue
+			}
+			found = true
+			if change.HasValue() {
+				resolved[i] = change
+			} else {
+				resolved = append(resolved[:i], resolved[i+1:]...)
+				i--
+			}
+		}
+		if !found && change.HasValue() {
+			resolved = append(resolved, change)
+		}
+	}
+	return resolved, nil
+}
+
+// Remove creates a patch record, which removes the records of the given name.
+func Remove(name string) Record {
+	return Record{Name: name}
+}
@@ -0,0 +1,42 @@
+package senml
+
+import (
+	"testing"
+	"time"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPack_FetchAndPatch(t *testing.T) {
+	c.Convey("Given a pack of a device", t, func() {
+		now := time.Unix(1600000000, 0)
+		pack := Pack{
+			{BaseName: "dev:", Name: "temp", Value: new(float64)},
+			String("state", "on"),
+			Bool("open", false),
+		}
+
+		c.Convey("When records are fetched by name", func() {
+			selected, err := pack.Fetch(Pack{{BaseName: "dev:", Name: "state"}, {Name: "missing"}}, now)
+
+			c.Convey("Then only the named records are returned", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(selected, c.ShouldHaveLength, 1)
+				c.So(*selected[0].StringValue, c.ShouldEqual, "on")
+			})
+		})
+
+		c.Convey("When a patch is applied", func() {
+			patch := Pack{{BaseName: "dev:", Name: "state", StringValue: new(string)}, Remove("open"), Float("hum", "%RH", 40)}
+			patched, err := pack.Patch(patch, now)
+
+			c.Convey("Then records are replaced, removed and added", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(patched, c.ShouldHaveLength, 3)
+				c.So(patched[0].Name, c.ShouldEqual, "dev:temp")
+				c.So(*patched[1].StringValue, c.ShouldBeEmpty)
+				c.So(patched[2].Name, c.ShouldEqual, "dev:hum")
+			})
+		})
+	})
+}
@@ -0,0 +1,248 @@
+// Package senml implements Sensor Measurement Lists (SenML, RFC 8428) and the
+// SenML FETCH/PATCH formats (SenML-ETCH, RFC 8790) for use as CoAP payloads.
+package senml
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Version is the SenML version implemented, records declaring a higher base version are rejected.
+const Version = 10
+
+// Spec: times below 2**28 seconds are relative to the current time.
+const relativeTimeLimit = 1 << 28
+
+var (
+	EmptyName           = errors.New("name is empty")
+	InvalidName         = errors.New("name contains invalid characters")
+	MultipleValues      = errors.New("record contains more than one value")
+	MissingValue        = errors.New("record contains neither value nor sum")
+	InvalidNumber       = errors.New("number is not finite")
+	InconsistentVersion = errors.New("base version differs between records")
+	UnsupportedVersion  = errors.New("base version is not supported")
+)
+
+// Record is a SenML record. Base fields apply to the record and all following records of the pack,
+// until they are given again. Values are pointers, so that zero values can be told from absent ones.
+type Record struct {
+	BaseName    string  `json:"bn,omitempty" cbor:"-2,keyasint,omitempty"`
+	BaseTime    float64 `json:"bt,omitempty" cbor:"-3,keyasint,omitempty"`
+	BaseUnit    string  `json:"bu,omitempty" cbor:"-4,keyasint,omitempty"`
+	BaseValue   float64 `json:"bv,omitempty" cbor:"-5,keyasint,omitempty"`
+	BaseSum     float64 `json:"bs,omitempty" cbor:"-6,keyasint,omitempty"`
+	BaseVersion int     `json:"bver,omitempty" cbor:"-1,keyasint,omitempty"`
+
+	Name        string   `json:"n,omitempty" cbor:"0,keyasint,omitempty"`
+	Unit        string   `json:"u,omitempty" cbor:"1,keyasint,omitempty"`
+	Value       *float64 `json:"v,omitempty" cbor:"2,keyasint,omitempty"`
+	StringValue *string  `json:"vs,omitempty" cbor:"3,keyasint,omitempty"`
+	BoolValue   *bool    `json:"vb,omitempty" cbor:"4,keyasint,omitempty"`
+	// DataValue is encoded as base64url in JSON and as byte string in CBOR.
+	DataValue  []byte   `json:"-" cbor:"8,keyasint,omitempty"`
+	Sum        *float64 `json:"s,omitempty" cbor:"5,keyasint,omitempty"`
+	Time       float64  `json:"t,omitempty" cbor:"6,keyasint,omitempty"`
+	UpdateTime float64  `json:"ut,omitempty" cbor:"7,keyasint,omitempty"`
+}
+
+// Pack is a list of SenML records.
+type Pack []Record
+
+// Get string representation of the record
+func (r Record) String() string {
+	s := fmt.Sprintf("Record{ n=%v, t=%v", r.BaseName+r.Name, r.BaseTime+r.Time)
+	switch {
+	case r.Value != nil:
+		s += fmt.Sprintf(", v=%v", *r.Value)
+	case r.StringValue != nil:
+		s += fmt.Sprintf(", vs=%v", *r.StringValue)
+	case r.BoolValue != nil:
+		s += fmt.Sprintf(", vb=%v", *r.BoolValue)
+	case r.DataValue != nil:
+		s += fmt.Sprintf(", vd=%x", r.DataValue)
+	}
+	if r.Sum != nil {
+		s += fmt.Sprintf(", s=%v", *r.Sum)
+	}
+	if u := r.Unit; u != "" {
+		s += ", u=" + u
+	}
+	return s + " }"
+}
+
+// Float creates a record with a numeric value.
+func Float(name string, unit string, v float64) Record {
+	return Record{Name: name, Unit: unit, Value: &v}
+}
+
+// String creates a record with a string value.
+func String(name string, v string) Record {
+	return Record{Name: name, StringValue: &v}
+}
+
+// Bool creates a record with a boolean value.
+func Bool(name string, v bool) Record {
+	return Record{Name: name, BoolValue: &v}
+}
+
+// Data creates a record with a data value.
+func Data(name string, v []byte) Record {
+	return Record{Name: name, DataValue: v}
+}
+
+// Number of values the record contains, the sum is not counted.
+func (r *Record) values() int {
+	n := 0
+	for _, present := range []bool{r.Value != nil, r.StringValue != nil, r.BoolValue != nil, r.DataValue != nil} {
+		if present {
+			n++
+		}
+	}
+	return n
+}
+
+// HasValue checks whether the record contains a value or a sum.
+func (r *Record) HasValue() bool {
+	return r.values() > 0 || r.Sum != nil
+}
+
+// Resolve resolves the base fields of the pack (RFC 8428, section 4.6). Resolved records carry the
+// full name, unit, value and sum, and an absolute time; relative times are added to now.
+// Records are kept in order, an error is returned for records which are not well-formed.
+func (p Pack) Resolve(now time.Time) (Pack, error) {
+	var base Record
+	resolved := make(Pack, 0, len(p))
+	for i, r := range p {
+		if r.BaseName != "" {
+			base.BaseName = r.BaseName
+		}
+		if r.BaseTime != 0 {
+			base.BaseTime = r.BaseTime
+		}
+		if r.BaseUnit != "" {
+			base.BaseUnit = r.BaseUnit
+		}
+		if r.BaseValue != 0 {
+			base.BaseValue = r.BaseValue
+		}
+		if r.BaseSum != 0 {
+			base.BaseSum = r.BaseSum
+		}
+		if r.BaseVersion != 0 {
+			if base.BaseVersion != 0 && r.BaseVersion != base.BaseVersion {
+				return nil, fmt.Errorf("record %d: %w", i, InconsistentVersion)
+			}
+			if r.BaseVersion > Version {
+				return nil, fmt.Errorf("record %d: %w", i, UnsupportedVersion)
+			}
+			base.BaseVersion = r.BaseVersion
+		}
+
+		record, err := resolveRecord(&base, &r, now)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+		resolved = append(resolved, record)
+	}
+	return resolved, nil
+}
+
+func resolveRecord(base *Record, r *Record, now time.Time) (Record, error) {
+	for _, v := range []float64{r.BaseTime, r.BaseValue, r.BaseSum, r.Time, r.UpdateTime} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return Record{}, InvalidNumber
+		}
+	}
+	if r.values() > 1 {
+		return Record{}, MultipleValues
+	}
+
+	record := Record{
+		Name:        base.BaseName + r.Name,
+		Unit:        r.Unit,
+		StringValue: r.StringValue,
+		BoolValue:   r.BoolValue,
+		DataValue:   r.DataValue,
+		Time:        base.BaseTime + r.Time,
+		UpdateTime:  r.UpdateTime,
+	}
+	if err := validateName(record.Name); err != nil {
+		return Record{}, err
+	}
+	if base.BaseVersion != 0 && base.BaseVersion != Version {
+		record.BaseVersion = base.BaseVersion
+	}
+	if record.Unit == "" {
+		record.Unit = base.BaseUnit
+	}
+	if r.Value != nil {
+		v := base.BaseValue + *r.Value
+		record.Value = &v
+	} else if base.BaseValue != 0 && !r.HasValue() {
+		// Spec: a record without value takes the base value.
+		v := base.BaseValue
+		record.Value = &v
+	}
+	if r.Sum != nil || base.BaseSum != 0 {
+		s := base.BaseSum
+		if r.Sum != nil {
+			s += *r.Sum
+		}
+		record.Sum = &s
+	}
+	for _, v := range []*float64{record.Value, record.Sum} {
+		if v != nil && (math.IsNaN(*v) || math.IsInf(*v, 0)) {
+			return Record{}, InvalidNumber
+		}
+	}
+	if record.Time < relativeTimeLimit {
+		record.Time += float64(now.UnixNano()) / float64(time.Second)
+	}
+	return record, nil
+}
+
+// Spec: names start with a letter or digit and consist of letters, digits and "-:./_".
+func validateName(name string) error {
+	if name == "" {
+		return EmptyName
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case i > 0 && (c == '-' || c == ':' || c == '.' || c == '/' || c == '_'):
+		default:
+			return InvalidName
+		}
+	}
+	return nil
+}
+
+// Normalize resolves the pack and sorts its records chronologically, keeping the order of
+// records of the same time.
+func (p Pack) Normalize(now time.Time) (Pack, error) {
+	resolved, err := p.Resolve(now)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(resolved, func(i, j int) bool {
+		return resolved[i].Time < resolved[j].Time
+	})
+	return resolved, nil
+}
+
+// Validate checks that the pack is well-formed and every record contains a value or a sum.
+func (p Pack) Validate() error {
+	resolved, err := p.Resolve(time.Now())
+	if err != nil {
+		return err
+	}
+	for i := range resolved {
+		if !resolved[i].HasValue() {
+			return fmt.Errorf("record %d: %w", i, MissingValue)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package senml
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPack_Resolve(t *testing.T) {
+	c.Convey("Given a pack using base fields (RFC 8428, section 5.1.3)", t, func() {
+		v := func(f float64) *float64 { return &f }
+		pack := Pack{
+			{BaseName: "urn:dev:ow:10e2073a01080063:", BaseTime: 1.320067464e+09, BaseUnit: "%RH", Value: v(20)},
+			{Unit: "lon", Value: v(24.30621)},
+			{Time: 60, Value: v(20.3)},
+			{BaseValue: 10, Name: "x", Time: 120, Value: v(2)},
+		}
+
+		c.Convey("When it is resolved", func() {
+			resolved, err := pack.Resolve(time.Now())
+
+			c.Convey("Then base fields are applied to all following records", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(resolved, c.ShouldHaveLength, 4)
+				c.So(resolved[0].Name, c.ShouldEqual, "urn:dev:ow:10e2073a01080063:")
+				c.So(resolved[0].Unit, c.ShouldEqual, "%RH")
+				c.So(resolved[1].Unit, c.ShouldEqual, "lon")
+				c.So(resolved[2].Time, c.ShouldEqual, 1.320067524e+09)
+				c.So(resolved[2].Unit, c.ShouldEqual, "%RH")
+				c.So(resolved[3].Name, c.ShouldEqual, "urn:dev:ow:10e2073a01080063:x")
+				c.So(*resolved[3].Value, c.ShouldEqual, 12)
+			})
+
+			c.Convey("And no base fields remain", func() {
+				for _, r := range resolved {
+					c.So(r.BaseName+r.BaseUnit, c.ShouldBeEmpty)
+					c.So(r.BaseTime+r.BaseValue+r.BaseSum, c.ShouldEqual, 0)
+				}
+			})
+		})
+	})
+
+	c.Convey("Given a pack with relative times", t, func() {
+		pack := Pack{Float("temp", "Cel", 21.5), {Name: "temp", Time: -10, Value: new(float64)}}
+
+		c.Convey("When it is normalized", func() {
+			now := time.Unix(1600000000, 0)
+			normalized, err := pack.Normalize(now)
+
+			c.Convey("Then times are absolute and in chronological order", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(normalized[0].Time, c.ShouldEqual, 1599999990)
+				c.So(normalized[1].Time, c.ShouldEqual, 1600000000)
+			})
+		})
+	})
+}
+
+func TestPack_Validate(t *testing.T) {
+	c.Convey("Given packs which are not valid", t, func() {
+		v, s := 1.0, "on"
+		invalid := map[error]Pack{
+			EmptyName:           {{Value: &v}},
+			InvalidName:         {Float("-temp", "", 1)},
+			MultipleValues:      {{Name: "temp", Value: &v, StringValue: &s}},
+			MissingValue:        {{Name: "temp"}},
+			InconsistentVersion: {{BaseVersion: 5, Name: "a", Value: &v}, {BaseVersion: 6, Name: "b", Value: &v}},
+			UnsupportedVersion:  {{BaseVersion: 11, Name: "a", Value: &v}},
+		}
+
+		c.Convey("Then validation reports the reason", func() {
+			for reason, pack := range invalid {
+				c.So(errors.Is(pack.Validate(), reason), c.ShouldBeTrue)
+			}
+		})
+	})
+
+	c.Convey("Given a valid pack", t, func() {
+		pack := Pack{Float("temp", "Cel", 21.5), String("state", "on"), Bool("open", false), Data("raw", []byte{1})}
+
+		c.Convey("Then it validates", func() {
+			c.So(pack.Validate(), c.ShouldBeNil)
+		})
+	})
+}
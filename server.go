@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aellwein/slf4go"
 	// include adapter implementation
@@ -21,6 +23,14 @@ const (
 )
 const MaxPacketSize = 2048
 
+// Buffers for packets received and sent, shared by servers.
+var packetBuffers = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, MaxPacketSize)
+		return &buffer
+	},
+}
+
 type resourceMap map[string]*Resource
 
 type Server struct {
@@ -28,12 +38,34 @@ type Server struct {
 	conn       *net.UDPConn
 	parameters TransmissionParameters
 	resources  resourceMap
+	security   *SecurityConfig
+	oscore     map[string]*OSCOREContext
+	oscoreLock sync.RWMutex
+	proxies    map[string]ProxyFunc
+	options    *OptionRegistry
+	noCopy     bool
+
+	reverseProxies map[string]reverseProxy
+
+	echoKey             []byte
+	echoLock            sync.Mutex
+	amplificationFactor int
+	verifiedPeers       map[string]time.Time
+
+	// request bodies sent in blocks, by peer, options and Request-Tag
+	bodiesLock sync.Mutex
+	bodies     map[string]*blockBody
+
+	// separate responses waiting for acknowledgement, by peer and message ID
+	pendingLock sync.Mutex
+	pending     map[string]chan struct{}
 }
 
-var logger slf4go.Logger
+// Logger of the package, created once, as servers and clients log from concurrent goroutines.
+var logger = slf4go.GetLogger("coap")
 
 // Get string representation of the server
-func (server Server) String() string {
+func (server *Server) String() string {
 	return fmt.Sprintf("Server{ addr=%v, parameters=%v, conn=%v, resources=%v}",
 		server.addr, server.parameters, server.conn, server.resources)
 }
@@ -46,7 +78,7 @@ func (r resourceMap) String() string {
 		if (*v).OnPOST != nil {
 			m = append(m, "POST")
 		}
-		if (*v).OnGET != nil {
+		if (*v).OnGET != nil || len((*v).Representations) > 0 {
 			m = append(m, "GET")
 		}
 		if (*v).OnPUT != nil {
@@ -61,11 +93,10 @@ func (r resourceMap) String() string {
 	return s.String()
 }
 
-func newServer(port CoapPort, parameters TransmissionParameters, resources ...*Resource) (*Server, error) {
+func newServer(port CoapPort, parameters TransmissionParameters, security *SecurityConfig, resources ...*Resource) (*Server, error) {
 	var err error
 	server := &Server{}
 
-	logger = slf4go.GetLogger("server")
 	server.addr, err = net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", port))
 
 	if err != nil {
@@ -74,8 +105,24 @@ func newServer(port CoapPort, parameters TransmissionParameters, resources ...*R
 
 	//transmission.ValidateParameters(parameters)
 
+	if security != nil {
+		if err = security.validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	server.parameters = parameters
+	server.security = security
 	server.resources = make(map[string]*Resource)
+	server.oscore = make(map[string]*OSCOREContext)
+	server.proxies = make(map[string]ProxyFunc)
+	server.options = NewOptionRegistry()
+	server.reverseProxies = make(map[string]reverseProxy)
+	server.echoKey = newEchoKey()
+	server.verifiedPeers = make(map[string]time.Time)
+	server.amplificationFactor = DefaultAmplificationFactor
+	server.pending = make(map[string]chan struct{})
+	server.bodies = make(map[string]*blockBody)
 
 	for _, r := range resources {
 		if r.Path == "" || r.Path[0:1] != "/" {
@@ -87,102 +134,330 @@ func newServer(port CoapPort, parameters TransmissionParameters, resources ...*R
 	return server, nil
 }
 
-// Creates a default CoAP Server on secure port using default transmission parameters.
-func NewSecureCoapServerWithDefaultParameters(resources ...*Resource) (*Server, error) {
-	return newServer(SecurePort, DefaultTransmissionParameters(), resources...)
+// Creates a default CoAP Server speaking DTLS on secure port using default transmission parameters.
+func NewSecureCoapServerWithDefaultParameters(security SecurityConfig, resources ...*Resource) (*Server, error) {
+	return newServer(SecurePort, DefaultTransmissionParameters(), &security, resources...)
 }
 
 // Creates a default CoAP server on insecure port using default transmission parameters.
 func NewInsecureCoapServerWithDefaultParameters(resources ...*Resource) (*Server, error) {
-	return newServer(InsecurePort, DefaultTransmissionParameters(), resources...)
+	return newServer(InsecurePort, DefaultTransmissionParameters(), nil, resources...)
 }
 
-// Creates a new CoAP server on secure port using given transmission parameters.
-func NewSecureCoapServer(parameters TransmissionParameters, resources ...*Resource) (*Server, error) {
+// Creates a new CoAP server speaking DTLS on secure port using given transmission parameters.
+func NewSecureCoapServer(parameters TransmissionParameters, security SecurityConfig, resources ...*Resource) (*Server, error) {
 	params := parameters
-	return newServer(SecurePort, params, resources...)
+	return newServer(SecurePort, params, &security, resources...)
 }
 
 // Creates a default CoAP server on insecure port using given transmission parameters.
 func NewInsecureCoapServer(parameters TransmissionParameters, resources ...*Resource) (*Server, error) {
 	params := parameters
-	return newServer(InsecurePort, params, resources...)
+	return newServer(InsecurePort, params, nil, resources...)
 }
 
-// Listen on default (insecure) port
+// Listen on the port of the server, secure servers speak DTLS
 func (server *Server) Listen() error {
 	return server.ListenOn(InsecurePort)
 }
 
 func (s *Server) handlePacket(packet []byte, n int, peer *net.UDPAddr) {
-	logger.Debugf("received packet from %s: \n%s", peer, hex.Dump(packet[0:n]))
-	msg, err := NewMessageFromBytesAndPeer(packet[0:n], peer)
+	buffer := packetBuffers.Get().(*[]byte)
+	defer packetBuffers.Put(buffer)
+
+	if respBuf := s.appendResponse((*buffer)[:0], packet[0:n], peer, nil, nil); respBuf != nil {
+		s.conn.WriteToUDP(respBuf, peer)
+	}
+}
+
+// DecodeWithoutCopy lets received messages reference the buffer they were received in, instead of copying it.
+// Handlers get their own copy of requests either way, so it saves a copy for every message.
+func (s *Server) DecodeWithoutCopy(enabled bool) {
+	s.noCopy = enabled
+}
+
+// Decodes and handles a received message, returning the encoded response, if any.
+// Identity is set for messages received over a secure session.
+func (s *Server) handleMessage(packet []byte, peer *net.UDPAddr, identity *PeerIdentity) []byte {
+	return s.appendResponse(nil, packet, peer, identity, nil)
+}
+
+// Decodes and handles a received message, appending the encoded response to dst.
+// Nil is returned, if there is no response. Session is the DTLS connection of secure sessions,
+// messages without session are received on the connection of the server.
+func (s *Server) appendResponse(dst []byte, packet []byte, peer *net.UDPAddr, identity *PeerIdentity, session net.Conn) []byte {
+	if logger.IsDebugEnabled() {
+		logger.Debugf("received packet from %s: \n%s", peer, hex.Dump(packet))
+	}
+	msg, err := decodeMessage(packet, peer, s.options, s.noCopy)
 	if err != nil {
 		logger.Debugf("error decoding message: %v", err)
+		if msg != nil {
+			return rejectBadOption(dst, msg)
+		}
 		// message could not be decoded, ignore
-		return
+		return nil
+	}
+	msg.Identity = identity
+	if logger.IsDebugEnabled() {
+		logger.Debugf("message received: %v", msg)
+		logger.Debug("Go representation of the packet: ", DumpInGoFormat(packet))
 	}
-	logger.Debugf("message received: %v", msg)
-	logger.Debug("Go representation of the packet: ", DumpInGoFormat(packet[0:n]))
 
-	if msg.Type == NonConfirmable || msg.Type == Confirmable {
+	switch msg.Type {
+	case Acknowledgement, Reset:
+		s.acknowledge(peer, msg.MessageID)
+		return nil
+	case NonConfirmable, Confirmable:
+	default:
+		return nil
+	}
 
-		if res := msg.Validate(); res != Ok {
-			b := responseWithCode(msg, res).ToBytes()
-			s.conn.WriteToUDP(b, peer)
-			return
+	if res := msg.Validate(); res != Ok {
+		resp := suppressResponse(msg, responseWithCode(msg, res))
+		if resp == nil {
+			return nil
+		}
+		return resp.AppendTo(dst)
+	}
+
+	if identity == nil {
+		s.verifyPeer(msg, time.Now())
+	}
+
+	// Spec: requests, which can't be answered immediately, are answered by a separate response.
+	// Proxied requests wait for the upstream server, which must not stall the other peers.
+	if send := s.sender(peer, session); send != nil && s.isProxyRequest(msg) {
+		go s.respondSeparately(msg.Clone(), len(packet), send)
+		if msg.Type == Confirmable {
+			return NewAcknowledgementMessageBuilder().Code(EmptyMessage).MessageId(msg.MessageID).Token(&TokenType{}).Build().AppendTo(dst)
 		}
+		return nil
+	}
+
+	resp := s.respond(dst, msg, len(packet))
+	if resp == nil {
+		logger.Debug("response suppressed by request")
+		return nil
+	}
+	// handlers may answer by a separate response, which is sent after acknowledging the request;
+	// the handler has already run at this point
+	if resp.Type == Confirmable && msg.Type == Confirmable {
+		if send := s.sender(peer, session); send != nil {
+			go s.sendConfirmable(resp, peer, send)
+			return NewAcknowledgementMessageBuilder().Code(EmptyMessage).MessageId(msg.MessageID).Token(&TokenType{}).Build().AppendTo(dst)
+		}
+	}
+	logger.Debugf("will send message %v", resp)
+	return resp.AppendTo(dst)
+}
+
+// Routes the request and returns the checked response, or nil if the response is suppressed.
+// Requests sent in blocks are routed once their body is complete.
+// Dst is used to encode the response for the amplification limit.
+func (s *Server) respond(dst []byte, msg *Message, requestSize int) *Message {
+	request, resp := s.assembleBody(msg, time.Now())
+	if resp == nil {
+		resp = s.routeRequest(request)
+		// Spec: the response to the last block of a body echoes its Block1 option.
+		if block, ok := msg.first(Block1); ok {
+			if resp.Options == nil {
+				resp.Options = &OptionsType{}
+			}
+			resp.Options.Set(Block1, block)
+		}
+	}
+
+	err := resp.checkEncoding()
+	if err == nil && resp.Options != nil {
+		err = s.options.Validate(resp.Options)
+	}
+	if err != nil {
+		logger.Errorf("invalid response to %v: %v", msg, err)
+		resp = NewInternalServerErrorResponseMessage(msg)
+	}
+
+	// Spec: secure sessions verify the peer address during handshake already
+	if msg.Identity == nil && !s.mayAmplify(msg, requestSize, len(resp.AppendTo(dst))-len(dst), time.Now()) {
+		logger.Debugf("response to unverified peer %v exceeds amplification limit", msg.Source)
+		resp = s.echoChallenge(msg)
+	}
+
+	return suppressResponse(msg, resp)
+}
 
-		// route request and get response
-		resp := s.routeRequest(msg)
+// Returns the function sending packets to the peer for separate responses,
+// or nil if the message was not received by a transport of the server.
+func (s *Server) sender(peer *net.UDPAddr, session net.Conn) func(packet []byte) {
+	switch {
+	case session != nil:
+		return func(packet []byte) { session.Write(packet) }
+	case s.conn != nil && peer != nil:
+		return func(packet []byte) { s.conn.WriteToUDP(packet, peer) }
+	}
+	return nil
+}
 
+// Responds to the request by a separate response (RFC 7252, section 5.2.2). Confirmable requests have been
+// acknowledged already, they are answered by a confirmable response, which is retransmitted until acknowledged.
+func (s *Server) respondSeparately(msg *Message, requestSize int, send func(packet []byte)) {
+	resp := s.respond(nil, msg, requestSize)
+	if resp == nil || *resp.Code == *EmptyMessage {
+		logger.Debug("response suppressed by request")
+		return
+	}
+	resp.MessageID = NewMessageId()
+	if msg.Type == NonConfirmable {
+		resp.Type = NonConfirmable
 		logger.Debugf("will send message %v", resp)
-		// write response
-		respBuf := resp.ToBytes()
-		s.conn.WriteToUDP(respBuf, peer)
+		send(resp.ToBytes())
+		return
 	}
+	resp.Type = Confirmable
+	s.sendConfirmable(resp, msg.Source, send)
+}
+
+// Sends the confirmable message, retransmitting it until it is acknowledged or reset by the peer.
+func (s *Server) sendConfirmable(msg *Message, peer *net.UDPAddr, send func(packet []byte)) {
+	key := exchangeKey(peer, msg.MessageID)
+	acknowledged := make(chan struct{})
+	s.pendingLock.Lock()
+	s.pending[key] = acknowledged
+	s.pendingLock.Unlock()
+	defer func() {
+		s.pendingLock.Lock()
+		delete(s.pending, key)
+		s.pendingLock.Unlock()
+	}()
+
+	packet := msg.ToBytes()
+	timeout := s.parameters.initialTimeout()
+	for retransmissions := 0; ; retransmissions++ {
+		logger.Debugf("will send message %v", msg)
+		send(packet)
+
+		timer := time.NewTimer(timeout)
+		select {
+		case <-acknowledged:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if retransmissions >= s.parameters.MaxRetransmit {
+			logger.Debugf("message %v was not acknowledged by %v", msg.MessageID, peer)
+			return
+		}
+		timeout *= 2
+	}
+}
+
+// Marks the confirmable message sent to the peer as acknowledged.
+func (s *Server) acknowledge(peer *net.UDPAddr, messageID MessageIdType) {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+	key := exchangeKey(peer, messageID)
+	if acknowledged, ok := s.pending[key]; ok {
+		close(acknowledged)
+		delete(s.pending, key)
+	}
+}
+
+func exchangeKey(peer *net.UDPAddr, messageID MessageIdType) string {
+	return fmt.Sprintf("%v/%d", peer, messageID)
+}
+
+// Spec: requests with unrecognized or invalid critical options are answered by 4.02 Bad Option
+// if confirmable, and rejected by reset if non-confirmable. Confirmable responses, i.e. separate
+// responses, are rejected by reset, other responses are silently ignored (RFC 7252, section 5.4.1).
+func rejectBadOption(dst []byte, msg *Message) []byte {
+	reset := NewResetMessageBuilder().Code(EmptyMessage).MessageId(msg.MessageID).Token(&TokenType{})
 
+	if !msg.Code.IsRequest() {
+		if msg.Type == Confirmable {
+			return reset.Build().AppendTo(dst)
+		}
+		return nil
+	}
+	switch msg.Type {
+	case Confirmable:
+		return responseWithCode(msg, BadOption).AppendTo(dst)
+	case NonConfirmable:
+		return reset.Build().AppendTo(dst)
+	}
+	return nil
 }
 
 // Listen on specific port
 func (server *Server) ListenOn(port CoapPort) error {
-	var err error
-	server.conn, err = net.ListenUDP("udp", server.addr)
+	if server.security != nil {
+		return server.listenSecure()
+	}
 
+	conn, err := net.ListenUDP("udp", server.addr)
 	if err != nil {
 		return err
 	}
+	return server.Serve(conn)
+}
 
+// Serve handles messages received on the given connection, until the connection is closed.
+func (server *Server) Serve(conn *net.UDPConn) error {
+	server.conn = conn
 	defer server.conn.Close()
 
-	buffer := make([]byte, MaxPacketSize)
+	buffer := packetBuffers.Get().(*[]byte)
+	defer packetBuffers.Put(buffer)
 	logger.Infof("Server is listening on %v", server.conn.LocalAddr())
 
 	for {
-		n, peer, err := server.conn.ReadFromUDP(buffer)
+		n, peer, err := server.conn.ReadFromUDP(*buffer)
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
 			logger.Debug(err)
 			// try to read again if read failed
 			continue
 		}
 
-		server.handlePacket(buffer, n, peer)
+		server.handlePacket(*buffer, n, peer)
 	}
 }
 
 func (server *Server) routeRequest(msg *Message) *Message {
-	if pathOption, ok := (*msg.Options)[UriPath]; ok {
+	if msg.HasOption(OSCORE) {
+		return server.routeProtectedRequest(msg)
+	}
+
+	if msg.HasOption(ProxyUri) || msg.HasOption(ProxyScheme) {
+		return server.routeProxyRequest(msg)
+	}
+
+	if pathOption := msg.Options.Get(UriPath); len(pathOption) > 0 {
 		p := UriPathOptionToString(pathOption)
 		if handler, ok := server.resources[p]; ok {
 
+			if handler.RequireFreshness && !server.hasFreshEcho(msg, time.Now()) {
+				return server.echoChallenge(msg)
+			}
+
+			if resp := handler.evaluateConditions(msg); resp != nil {
+				return resp
+			}
+
+			// handlers get their own copy, so that changes to it do not affect the exchange
+			request := msg.Clone()
+
 			switch *msg.Code {
 
 			case *GET:
-				if handler.OnGET != nil {
-					if resp, err := handler.OnGET(msg); err != nil {
+				if len(handler.Representations) > 0 {
+					return handler.tagResponse(handler.negotiate(request))
+				} else if handler.OnGET != nil {
+					if resp, err := handler.OnGET(request); err != nil {
 						return NewInternalServerErrorResponseMessage(msg)
 					} else {
-						return resp
+						return handler.tagResponse(resp)
 					}
 				} else {
 					return NewMethodNotAllowedResponseMessage(msg)
@@ -190,7 +465,7 @@ func (server *Server) routeRequest(msg *Message) *Message {
 
 			case *POST:
 				if handler.OnPOST != nil {
-					if resp, err := handler.OnPOST(msg); err != nil {
+					if resp, err := handler.OnPOST(request); err != nil {
 						return NewInternalServerErrorResponseMessage(msg)
 					} else {
 						return resp
@@ -201,7 +476,7 @@ func (server *Server) routeRequest(msg *Message) *Message {
 
 			case *PUT:
 				if handler.OnPUT != nil {
-					if resp, err := handler.OnPUT(msg); err != nil {
+					if resp, err := handler.OnPUT(request); err != nil {
 						return NewInternalServerErrorResponseMessage(msg)
 					} else {
 						return resp
@@ -212,7 +487,7 @@ func (server *Server) routeRequest(msg *Message) *Message {
 
 			case *DELETE:
 				if handler.OnDELETE != nil {
-					if resp, err := handler.OnDELETE(msg); err != nil {
+					if resp, err := handler.OnDELETE(request); err != nil {
 						return NewInternalServerErrorResponseMessage(msg)
 					} else {
 						return resp
@@ -222,9 +497,15 @@ func (server *Server) routeRequest(msg *Message) *Message {
 				}
 
 			default:
+				// Spec: unrecognized or unsupported methods are answered by 4.05 Method Not Allowed.
+				if msg.Code.IsRequest() {
+					return NewMethodNotAllowedResponseMessage(msg)
+				}
 				return NewBadRequestResponseMessage(msg)
 			}
 
+		} else if resp := server.routeReverseProxyRequest(msg, p); resp != nil {
+			return resp
 		} else {
 			// no handler found
 			return NewNotFoundResponseMessage(msg)
@@ -250,3 +531,8 @@ func (s *Server) RemoveResourceByPath(path string) {
 		delete(s.resources, path)
 	}
 }
+
+// DefineOption defines a custom option of given number, which the server accepts in requests.
+func (s *Server) DefineOption(number OptionNumberType, definition OptionDefinition) error {
+	return s.options.Define(number, definition)
+}
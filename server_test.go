@@ -40,7 +40,7 @@ func TestNewSecureCoapServer(t *testing.T) {
 			res := &Resource{
 				Path: "/",
 			}
-			server, err := NewSecureCoapServer(params, res)
+			server, err := NewSecureCoapServer(params, testSecurityConfig, res)
 			c.Convey("Then the parameters should be the same", func() {
 				c.So(err, c.ShouldBeNil)
 				c.So(server.String(), c.ShouldNotBeNil)
@@ -56,12 +56,24 @@ func TestNewSecureCoapServer(t *testing.T) {
 	})
 }
 
+func TestNewSecureCoapServerWithoutPSKStore(t *testing.T) {
+	c.Convey("Given a new secure coap server", t, func() {
+		c.Convey("When the security config has no PSK store", func() {
+			_, err := NewSecureCoapServerWithDefaultParameters(SecurityConfig{}, &Resource{Path: "/rd"})
+
+			c.Convey("Then an error is returned", func() {
+				c.So(err, c.ShouldNotBeNil)
+			})
+		})
+	})
+}
+
 func TestNewSecureCoapServerWithDefaultParameters(t *testing.T) {
 	c.Convey("Given a new secure coap server with default parameters", t, func() {
 		c.Convey("When the server is created", func() {
 
 			res := &Resource{Path: "/rd"}
-			server, err := NewSecureCoapServerWithDefaultParameters(res)
+			server, err := NewSecureCoapServerWithDefaultParameters(testSecurityConfig, res)
 
 			c.Convey("Then the parameters are equal the default parameters", func() {
 				c.So(err, c.ShouldBeNil)
@@ -321,3 +333,62 @@ func TestServer_RouteMessageWithInvalidMethodCode(t *testing.T) {
 		})
 	})
 }
+
+func TestServer_HandlersGetRequestCopy(t *testing.T) {
+	c.Convey("Given a coap server with a handler changing the request", t, func() {
+		server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{
+			Path: "/rd",
+			OnPOST: func(request *Message) (*Message, error) {
+				(*request.Token)[0] ^= 0xFF
+				request.Options.Del(UriPath)
+				return responseWithCode(request, Changed), nil
+			},
+		})
+		msg := NewConfirmableMessageBuilder().
+			Code(POST).
+			WithRandomMessageId().
+			WithRandomToken().
+			Option(UriPath, []byte("rd")).
+			Build()
+		encoded := msg.ToBytes()
+
+		c.Convey("When the request is routed", func() {
+			resp := server.routeRequest(msg)
+
+			c.Convey("Then the request is unchanged", func() {
+				c.So(*resp.Code, c.ShouldResemble, *Changed)
+				c.So(msg.ToBytes(), c.ShouldResemble, encoded)
+			})
+		})
+	})
+}
+
+func benchmarkServerHandlePacket(b *testing.B, noCopy bool) {
+	server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{
+		Path: "/sensors/temp",
+		OnPOST: func(request *Message) (*Message, error) {
+			return responseWithCode(request, Changed), nil
+		},
+	})
+	server.DecodeWithoutCopy(noCopy)
+	packet := benchmarkMessage()
+	peer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4711}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buffer := packetBuffers.Get().(*[]byte)
+		if server.appendResponse((*buffer)[:0], packet, peer, nil, nil) == nil {
+			b.Fatal("no response")
+		}
+		packetBuffers.Put(buffer)
+	}
+}
+
+func BenchmarkServer_HandlePacket(b *testing.B) {
+	benchmarkServerHandlePacket(b, false)
+}
+
+func BenchmarkServer_HandlePacketWithoutCopy(b *testing.B) {
+	benchmarkServerHandlePacket(b, true)
+}
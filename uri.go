@@ -0,0 +1,156 @@
+package coap
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var InvalidURI = errors.New("invalid URI")
+
+// Default ports of the URI schemes (RFC 7252, section 6).
+var defaultPorts = map[string]uint64{
+	"coap":  uint64(InsecurePort),
+	"coaps": uint64(SecurePort),
+}
+
+// NewRequest creates a confirmable request with random message ID and token to the given coap or coaps URI,
+// e.g. NewRequest(GET, "coap://example.com/sensors/temp?unit=C").
+// Spec: the URI is decomposed into Uri-Host, Uri-Port, Uri-Path and Uri-Query options (RFC 7252, section 6.4).
+func NewRequest(method *CodeType, uri string) (*Message, error) {
+	if method == nil || !method.IsRequest() {
+		return nil, fmt.Errorf("%w: %v is not a method", InvalidCode, method)
+	}
+	target, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", InvalidURI, err)
+	}
+	if !target.IsAbs() {
+		return nil, fmt.Errorf("%w: %q is not absolute", InvalidURI, uri)
+	}
+	scheme := strings.ToLower(target.Scheme)
+	defaultPort, ok := defaultPorts[scheme]
+	if !ok {
+		return nil, fmt.Errorf("%w: scheme %q is not supported", InvalidURI, target.Scheme)
+	}
+	if target.Fragment != "" || strings.Contains(uri, "#") {
+		return nil, fmt.Errorf("%w: %q has a fragment", InvalidURI, uri)
+	}
+	if target.Host == "" || target.Hostname() == "" {
+		return nil, fmt.Errorf("%w: %q has no host", InvalidURI, uri)
+	}
+
+	request := NewConfirmableMessageBuilder().Code(method).WithRandomMessageId().WithRandomToken().Build()
+	request.endpoint = &url.URL{Scheme: scheme, Host: target.Host}
+
+	// the host is percent-decoded already
+	if host := target.Hostname(); net.ParseIP(host) == nil {
+		request.Options.Add(UriHost, OptionValueType(strings.ToLower(host)))
+	}
+	if p := target.Port(); p != "" {
+		port, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid port %q", InvalidURI, p)
+		}
+		if port != defaultPort {
+			request.Options.Add(UriPort, uintOptionValue(port))
+		}
+	}
+	if path := target.EscapedPath(); path != "" && path != "/" {
+		for _, segment := range strings.Split(path[1:], "/") {
+			value, err := url.PathUnescape(segment)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", InvalidURI, err)
+			}
+			request.Options.Add(UriPath, OptionValueType(value))
+		}
+	}
+	if target.RawQuery != "" || target.ForceQuery {
+		for _, argument := range strings.Split(target.RawQuery, "&") {
+			value, err := url.PathUnescape(argument)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", InvalidURI, err)
+			}
+			request.Options.Add(UriQuery, OptionValueType(value))
+		}
+	}
+	return request, nil
+}
+
+// URI returns the URI of the request, as given by Proxy-Uri or composed of the Uri-* options
+// (RFC 7252, section 6.5). Host and port default to those of the URI the request was created with.
+// Scheme is given by Proxy-Scheme, otherwise coaps for requests received over a secure session.
+func (m *Message) URI() string {
+	if proxyUri, ok := m.GetString(ProxyUri); ok {
+		return proxyUri
+	}
+
+	scheme, host, port := "coap", "", ""
+	if m.endpoint != nil {
+		scheme, host, port = m.endpoint.Scheme, m.endpoint.Hostname(), m.endpoint.Port()
+	} else if m.Identity != nil {
+		scheme = "coaps"
+	}
+	if s, ok := m.GetString(ProxyScheme); ok {
+		scheme = strings.ToLower(s)
+	}
+	if h, ok := m.GetString(UriHost); ok {
+		host = h
+	}
+	if p, ok := m.GetUint(UriPort); ok {
+		port = strconv.FormatUint(p, 10)
+	}
+	if defaultPort, ok := defaultPorts[scheme]; ok && port == strconv.FormatUint(defaultPort, 10) {
+		port = ""
+	}
+
+	var b strings.Builder
+	b.WriteString(scheme)
+	b.WriteString("://")
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		b.WriteString("[" + host + "]")
+	} else {
+		b.WriteString(escapeURIComponent(host, ""))
+	}
+	if port != "" {
+		b.WriteString(":" + port)
+	}
+
+	path := m.Options.Get(UriPath)
+	if len(path) == 0 {
+		b.WriteString("/")
+	}
+	for _, segment := range path {
+		b.WriteString("/")
+		b.WriteString(escapeURIComponent(string(segment), ":@"))
+	}
+	for i, argument := range m.Options.Get(UriQuery) {
+		if i == 0 {
+			b.WriteString("?")
+		} else {
+			b.WriteString("&")
+		}
+		b.WriteString(escapeURIComponent(string(argument), ":@/?"))
+	}
+	return b.String()
+}
+
+// Percent-encodes all characters, except unreserved characters, sub-delims other than "&" and
+// the given characters allowed (RFC 3986, section 2).
+func escapeURIComponent(s string, allowed string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case 'a' <= ch && ch <= 'z', 'A' <= ch && ch <= 'Z', '0' <= ch && ch <= '9',
+			strings.IndexByte("-._~!$'()*+,;=", ch) >= 0, strings.IndexByte(allowed, ch) >= 0:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
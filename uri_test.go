@@ -0,0 +1,118 @@
+package coap
+
+import (
+	"errors"
+	"testing"
+
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewRequest(t *testing.T) {
+	c.Convey("Given equivalent URIs (RFC 7252, section 6.3)", t, func() {
+		uris := []string{
+			"coap://example.com:5683/~sensors/temp.xml",
+			"coap://EXAMPLE.com/%7Esensors/temp.xml",
+			"coap://EXAMPLE.com:/%7esensors/temp.xml",
+		}
+
+		c.Convey("Then the requests have the same options", func() {
+			for _, uri := range uris {
+				req, err := NewRequest(GET, uri)
+				c.So(err, c.ShouldBeNil)
+				c.So(req.Type, c.ShouldEqual, Confirmable)
+				c.So(*req.Code, c.ShouldResemble, *GET)
+				c.So(*req.Options, c.ShouldResemble, OptionsType{
+					{UriHost, OptionValueType("example.com")},
+					{UriPath, OptionValueType("~sensors")},
+					{UriPath, OptionValueType("temp.xml")},
+				})
+				c.So(req.URI(), c.ShouldEqual, "coap://example.com/~sensors/temp.xml")
+			}
+		})
+	})
+
+	c.Convey("Given an URI with IP literal, port, percent-encodings and query", t, func() {
+		uri := "coaps://[2001:db8::2:1]:61616//a%2Fb/?x=1&y=a%26b"
+
+		c.Convey("When a request is created", func() {
+			req, err := NewRequest(POST, uri)
+
+			c.Convey("Then Uri-Host is elided and the other options are decoded", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(*req.Options, c.ShouldResemble, OptionsType{
+					{UriPort, uintOptionValue(61616)},
+					{UriPath, OptionValueType("")},
+					{UriPath, OptionValueType("a/b")},
+					{UriPath, OptionValueType("")},
+					{UriQuery, OptionValueType("x=1")},
+					{UriQuery, OptionValueType("y=a&b")},
+				})
+			})
+			c.Convey("And the URI is composed again", func() {
+				c.So(req.URI(), c.ShouldEqual, uri)
+			})
+		})
+	})
+
+	c.Convey("Given an URI with default port of the secure scheme", t, func() {
+		req, err := NewRequest(GET, "coaps://192.0.2.1:5684")
+
+		c.Convey("Then neither Uri-Host nor Uri-Port are included", func() {
+			c.So(err, c.ShouldBeNil)
+			c.So(len(*req.Options), c.ShouldEqual, 0)
+			c.So(req.URI(), c.ShouldEqual, "coaps://192.0.2.1/")
+		})
+	})
+
+	c.Convey("Given invalid URIs", t, func() {
+		uris := []string{
+			"/sensors/temp",
+			"http://example.com/",
+			"coap://example.com/#frag",
+			"coap:///sensors",
+			"coap://example.com:99999/",
+		}
+
+		c.Convey("Then creating a request fails", func() {
+			for _, uri := range uris {
+				_, err := NewRequest(GET, uri)
+				c.So(errors.Is(err, InvalidURI), c.ShouldBeTrue)
+			}
+		})
+	})
+
+	c.Convey("Given a response code as method", t, func() {
+		_, err := NewRequest(Content, "coap://example.com/")
+
+		c.Convey("Then creating a request fails", func() {
+			c.So(errors.Is(err, InvalidCode), c.ShouldBeTrue)
+		})
+	})
+}
+
+func TestMessage_URI(t *testing.T) {
+	c.Convey("Given a request received over a secure session", t, func() {
+		req := NewConfirmableMessageBuilder().
+			Code(GET).
+			WithRandomMessageId().
+			WithRandomToken().
+			Option(UriHost, OptionValueType("example.com")).
+			Option(UriPort, uintOptionValue(5684)).
+			Option(UriPath, OptionValueType("a b")).
+			Option(UriQuery, OptionValueType("q=ü")).
+			Build()
+		req.Identity = &PeerIdentity{}
+
+		c.Convey("Then its URI is composed with coaps scheme and percent-encodings", func() {
+			c.So(req.URI(), c.ShouldEqual, "coaps://example.com/a%20b?q=%C3%BC")
+		})
+	})
+
+	c.Convey("Given a request with Proxy-Uri", t, func() {
+		req := newProxyRequest(GET).Option(ProxyUri, OptionValueType("http://example.com/a")).Build()
+
+		c.Convey("Then its URI is the Proxy-Uri", func() {
+			c.So(req.URI(), c.ShouldEqual, "http://example.com/a")
+		})
+	})
+}
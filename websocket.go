@@ -0,0 +1,260 @@
+package coap
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Resource path and subprotocol used for CoAP over WebSockets (RFC 8323, section 4).
+const (
+	WebSocketPath        = "/.well-known/coap"
+	WebSocketSubprotocol = "coap"
+)
+
+// Options of the CSM signaling message. Signaling options have their own number space
+// per signaling code, therefore they are not part of any OptionRegistry.
+const (
+	MaxMessageSize    OptionNumberType = 2
+	BlockWiseTransfer OptionNumberType = 4
+)
+
+type webSocketHandler struct {
+	server   *Server
+	upgrader websocket.Upgrader
+}
+
+// Reads and parses a CoAP Message from a WebSocket frame.
+// Reliable transports carry neither message type nor message ID, so these are left zero.
+// Options are checked against the given registry. The message references the frame.
+func decodeWebSocket(buffer []byte, registry *OptionRegistry) (*Message, error) {
+
+	if len(buffer) < 2 {
+		// packet is too short
+		return nil, PacketIsTooShort
+	}
+
+	// Spec: the length is given by the WebSocket frame, so the Len nibble MUST be zero.
+	if buffer[0]>>4 != 0 {
+		return nil, MessageFormatError
+	}
+
+	tokenLength := buffer[0] & 15
+
+	if tokenLength > 8 {
+		return nil, InvalidTokenLength
+	}
+	if len(buffer) < int(2+tokenLength) {
+		// packet too short
+		return nil, PacketIsTooShort
+	}
+
+	code := &CodeType{
+		CodeClass:  CodeClassType(buffer[1] >> 5),
+		CodeDetail: CodeDetailType(buffer[1] & 31),
+	}
+
+	tkn := TokenType(buffer[2 : 2+tokenLength : 2+tokenLength])
+
+	// signaling options are not checked against the registry
+	if code.CodeClass == 7 {
+		registry = nil
+	}
+
+	opts, payload, err := decodeOptionsAndPayload(buffer[2+int(tokenLength):], registry)
+	var optionError *OptionError
+	if errors.As(err, &optionError) {
+		// the code and token allow the message to be rejected
+		return &Message{Code: code, Token: &tkn, Options: &OptionsType{}}, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Code:    code,
+		Token:   &tkn,
+		Options: opts,
+		Payload: payload,
+	}, nil
+}
+
+// Encode the message to a WebSocket frame, type and message ID are omitted.
+func (m *Message) toWebSocketBytes() []byte {
+	pkt := []byte{byte(len(*m.Token)), byte(m.Code.CodeClass<<5) + byte(m.Code.CodeDetail)}
+	pkt = append(pkt, *m.Token...)
+	return m.appendOptionsAndPayload(pkt)
+}
+
+// Capabilities and Settings Message, which is sent first on every connection.
+func newCapabilitiesMessage() *Message {
+	size := make([]byte, 2)
+	binary.BigEndian.PutUint16(size, MaxPacketSize)
+
+	return NewConfirmableMessageBuilder().
+		Code(CSM).
+		MessageId(0).
+		Token(&TokenType{}).
+		Option(MaxMessageSize, size).
+		Build()
+}
+
+// WebSocketOptions configure the WebSocket handler of a server.
+type WebSocketOptions struct {
+	// CheckOrigin decides whether a browser page of the given Origin may connect.
+	// If nil, only pages of the same origin as the handler may connect.
+	CheckOrigin func(r *http.Request) bool
+}
+
+// AllowOrigins creates an origin policy, which accepts the given origins, e.g. "https://dashboard.example.com".
+// Clients sending no Origin header, i.e. non-browser clients, are accepted as well.
+func AllowOrigins(origins ...string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, o := range origins {
+			if strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// WebSocketHandler returns a http.Handler, which serves CoAP over WebSockets using the
+// resources of the server. It is meant to be mounted at WebSocketPath.
+// Only browser pages of the same origin may connect.
+func (s *Server) WebSocketHandler() http.Handler {
+	return s.WebSocketHandlerWithOptions(WebSocketOptions{})
+}
+
+// WebSocketHandlerWithOptions returns a http.Handler like WebSocketHandler, using the given options.
+func (s *Server) WebSocketHandlerWithOptions(options WebSocketOptions) http.Handler {
+	return &webSocketHandler{
+		server: s,
+		upgrader: websocket.Upgrader{
+			Subprotocols:    []string{WebSocketSubprotocol},
+			ReadBufferSize:  MaxPacketSize,
+			WriteBufferSize: MaxPacketSize,
+			CheckOrigin:     options.CheckOrigin,
+		},
+	}
+}
+
+// ListenWebSocket serves CoAP over WebSockets on the given TCP address, e.g. ":8080".
+func (s *Server) ListenWebSocket(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle(WebSocketPath, s.WebSocketHandler())
+	logger.Infof("Server is listening for WebSockets on %v%v", addr, WebSocketPath)
+	return http.ListenAndServe(addr, mux)
+}
+
+func (h *webSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Spec: the client MUST include the subprotocol name "coap" in the opening handshake.
+	if !hasSubprotocol(websocket.Subprotocols(r), WebSocketSubprotocol) {
+		http.Error(w, "subprotocol 'coap' is required", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// upgrader has already replied with an error
+		logger.Debugf("websocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(MaxPacketSize)
+
+	logger.Debugf("websocket connection from %s", r.RemoteAddr)
+
+	err = conn.WriteMessage(websocket.BinaryMessage, newCapabilitiesMessage().toWebSocketBytes())
+	if err != nil {
+		logger.Debug(err)
+		return
+	}
+
+	for {
+		frameType, frame, err := conn.ReadMessage()
+		if err != nil {
+			logger.Debug(err)
+			return
+		}
+		if frameType != websocket.BinaryMessage {
+			// Spec: CoAP messages are always carried in binary frames.
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseUnsupportedData, "binary frames only"), time.Time{})
+			return
+		}
+
+		msg, err := decodeWebSocket(frame, h.server.options)
+		var optionError *OptionError
+		if errors.As(err, &optionError) && msg.Code.CodeClass == 0 {
+			// Spec: requests with unrecognized critical options are answered by 4.02 Bad Option.
+			conn.WriteMessage(websocket.BinaryMessage, responseWithCode(msg, BadOption).toWebSocketBytes())
+			continue
+		}
+		if err != nil {
+			logger.Debugf("error decoding message: %v", err)
+			// message format errors abort the connection
+			abort := NewConfirmableMessageBuilder().Code(Abort).MessageId(0).Token(&TokenType{}).Build()
+			conn.WriteMessage(websocket.BinaryMessage, abort.toWebSocketBytes())
+			return
+		}
+		logger.Debugf("message received: %v", msg)
+
+		if *msg.Code == *Release || *msg.Code == *Abort {
+			return
+		}
+
+		if resp := h.server.handleWebSocketMessage(msg); resp != nil {
+			logger.Debugf("will send message %v", resp)
+			if err := conn.WriteMessage(websocket.BinaryMessage, resp.toWebSocketBytes()); err != nil {
+				logger.Debug(err)
+				return
+			}
+		}
+	}
+}
+
+// Handles a message received over WebSocket and returns the response, if any.
+func (s *Server) handleWebSocketMessage(msg *Message) *Message {
+	switch {
+
+	case *msg.Code == *Ping:
+		return NewConfirmableMessageBuilder().Code(Pong).MessageId(0).Token(msg.Token).Build()
+
+	case *msg.Code == *EmptyMessage:
+		// Spec: empty messages MUST be silently ignored.
+		return nil
+
+	case msg.Code.CodeClass == 0:
+		if res := msg.Validate(); res != Ok {
+			return responseWithCode(msg, res)
+		}
+		resp := s.routeRequest(msg)
+		// there are no acknowledgements to send in place of suppressed responses
+		if msg.SuppressedResponses().Suppresses(resp.Code) {
+			return nil
+		}
+		return resp
+
+	default:
+		// CSM, Pong and responses need no answer
+		return nil
+	}
+}
+
+func hasSubprotocol(protocols []string, protocol string) bool {
+	for _, p := range protocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package coap
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	c "github.com/smartystreets/goconvey/convey"
+)
+
+func dialWebSocket(url string) (*websocket.Conn, error) {
+	dialer := websocket.Dialer{Subprotocols: []string{WebSocketSubprotocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(url, "http")+WebSocketPath, nil)
+	return conn, err
+}
+
+func readWebSocketMessage(conn *websocket.Conn) (*Message, error) {
+	_, frame, err := conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	return decodeWebSocket(frame, DefaultOptionRegistry)
+}
+
+func TestWebSocketMessageRoundTrip(t *testing.T) {
+	c.Convey("Given a request message", t, func() {
+		msg := NewConfirmableMessageBuilder().
+			Code(POST).
+			WithRandomMessageId().
+			WithRandomToken().
+			Option(UriPath, []byte("rd")).
+			WithPayload(ContentTypeTextPlain, []byte("hello")).
+			Build()
+
+		c.Convey("When encoded and decoded as WebSocket frame", func() {
+			b := msg.toWebSocketBytes()
+			decoded, err := decodeWebSocket(b, DefaultOptionRegistry)
+
+			c.Convey("Then length nibble is zero and the message is preserved", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(b[0]>>4, c.ShouldEqual, 0)
+				c.So(*decoded.Code, c.ShouldResemble, *POST)
+				c.So(*decoded.Token, c.ShouldResemble, *msg.Token)
+				c.So(decoded.Options.Get(UriPath), c.ShouldResemble, []OptionValueType{[]byte("rd")})
+				c.So(decoded.Payload.Content, c.ShouldResemble, []byte("hello"))
+			})
+		})
+	})
+}
+
+func TestWebSocketFrameWithLengthIsRejected(t *testing.T) {
+	c.Convey("Given a frame with non-zero length nibble", t, func() {
+		b := []byte{0x10, 0x01, 0xB2}
+
+		c.Convey("When decoded", func() {
+			_, err := decodeWebSocket(b, DefaultOptionRegistry)
+
+			c.Convey("Then 'Message Format Error' is indicated by error", func() {
+				c.So(err, c.ShouldEqual, MessageFormatError)
+			})
+		})
+	})
+}
+
+func TestWebSocketHandler(t *testing.T) {
+	c.Convey("Given a coap server mounted as WebSocket handler", t, func() {
+		server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{
+			Path: "/rd",
+			OnGET: func(request *Message) (*Message, error) {
+				return NewContentResponseMessage(request), nil
+			},
+		})
+		mux := http.NewServeMux()
+		mux.Handle(WebSocketPath, server.WebSocketHandler())
+		ts := httptest.NewServer(mux)
+		defer ts.Close()
+
+		conn, err := dialWebSocket(ts.URL)
+		c.So(err, c.ShouldBeNil)
+		defer conn.Close()
+
+		c.Convey("When the connection is established", func() {
+			csm, err := readWebSocketMessage(conn)
+
+			c.Convey("Then the server sends a CSM first", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(*csm.Code, c.ShouldResemble, *CSM)
+				c.So(conn.Subprotocol(), c.ShouldEqual, WebSocketSubprotocol)
+			})
+		})
+
+		c.Convey("When a GET request is sent", func() {
+			readWebSocketMessage(conn)
+
+			req := NewConfirmableMessageBuilder().
+				Code(GET).
+				WithRandomMessageId().
+				WithRandomToken().
+				Option(UriPath, []byte("rd")).
+				Build()
+			conn.WriteMessage(websocket.BinaryMessage, req.toWebSocketBytes())
+			resp, err := readWebSocketMessage(conn)
+
+			c.Convey("Then the resource responds with matching token", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(*resp.Code, c.ShouldResemble, *Content)
+				c.So(*resp.Token, c.ShouldResemble, *req.Token)
+			})
+		})
+
+		c.Convey("When a Ping is sent", func() {
+			readWebSocketMessage(conn)
+
+			tkn := TokenType{0xCA, 0xFE}
+			ping := NewConfirmableMessageBuilder().Code(Ping).MessageId(0).Token(&tkn).Build()
+			conn.WriteMessage(websocket.BinaryMessage, ping.toWebSocketBytes())
+			pong, err := readWebSocketMessage(conn)
+
+			c.Convey("Then a Pong with the same token is returned", func() {
+				c.So(err, c.ShouldBeNil)
+				c.So(*pong.Code, c.ShouldResemble, *Pong)
+				c.So(*pong.Token, c.ShouldResemble, tkn)
+			})
+		})
+	})
+}
+
+func TestWebSocketHandlerRequiresSubprotocol(t *testing.T) {
+	c.Convey("Given a coap server mounted as WebSocket handler", t, func() {
+		server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{Path: "/rd"})
+		ts := httptest.NewServer(server.WebSocketHandler())
+		defer ts.Close()
+
+		c.Convey("When a client connects without 'coap' subprotocol", func() {
+			_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+
+			c.Convey("Then the handshake is rejected", func() {
+				c.So(err, c.ShouldNotBeNil)
+				c.So(resp.StatusCode, c.ShouldEqual, http.StatusBadRequest)
+			})
+		})
+	})
+}
+
+func TestWebSocketHandlerOriginPolicy(t *testing.T) {
+	c.Convey("Given a coap server mounted as WebSocket handler", t, func() {
+		server, _ := NewInsecureCoapServerWithDefaultParameters(&Resource{Path: "/rd"})
+		header := http.Header{"Origin": {"https://dashboard.example.com"}}
+		dialer := websocket.Dialer{Subprotocols: []string{WebSocketSubprotocol}}
+
+		c.Convey("When a page of another origin connects to the default handler", func() {
+			ts := httptest.NewServer(server.WebSocketHandler())
+			defer ts.Close()
+			_, resp, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), header)
+
+			c.Convey("Then the handshake is rejected", func() {
+				c.So(err, c.ShouldNotBeNil)
+				c.So(resp.StatusCode, c.ShouldEqual, http.StatusForbidden)
+			})
+		})
+
+		c.Convey("When a page of an allowed origin connects", func() {
+			ts := httptest.NewServer(server.WebSocketHandlerWithOptions(WebSocketOptions{
+				CheckOrigin: AllowOrigins("https://dashboard.example.com"),
+			}))
+			defer ts.Close()
+			conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), header)
+
+			c.Convey("Then the connection is established", func() {
+				c.So(err, c.ShouldBeNil)
+				conn.Close()
+			})
+		})
+
+		c.Convey("When a page of an origin not allowed connects", func() {
+			ts := httptest.NewServer(server.WebSocketHandlerWithOptions(WebSocketOptions{
+				CheckOrigin: AllowOrigins("https://dashboard.example.com"),
+			}))
+			defer ts.Close()
+			_, resp, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"),
+				http.Header{"Origin": {"https://evil.example.com"}})
+
+			c.Convey("Then the handshake is rejected", func() {
+				c.So(err, c.ShouldNotBeNil)
+				c.So(resp.StatusCode, c.ShouldEqual, http.StatusForbidden)
+			})
+		})
+	})
+}